@@ -0,0 +1,193 @@
+package replay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// BlobHeader replaces body of a recorded HTTP message stored as a blob, referencing it by hash, see WithBlobs.
+const BlobHeader = "X-Replay-Body-Blob"
+
+// DefaultBlobThreshold is the body size in bytes above which bodies are stored as blobs,
+// unless another threshold is passed to WithBlobs.
+const DefaultBlobThreshold = 64 << 10
+
+// blobExt is the extension of blob files, named by hash of their content.
+const blobExt = ".blob"
+
+var blobRef = regexp.MustCompile(`sha256:([0-9a-f]{64})`)
+
+// WithBlobs stores recorded bodies larger than threshold bytes, DefaultBlobThreshold if not positive,
+// in content-addressed directory dir, so identical bodies are stored once across test cases.
+// Recordings reference blobs by hash, use GCBlobs to remove blobs no longer referenced.
+// Directories starting with a dot are not test cases, which makes testdata/.blobs a good choice.
+func WithBlobs(dir string, threshold int) Option {
+	return func(o *options) {
+		if threshold <= 0 {
+			threshold = DefaultBlobThreshold
+		}
+		o.blobDir = dir
+		o.blobThreshold = threshold
+	}
+}
+
+// writeBlob stores data unless a blob with the same content exists, and returns reference to it.
+func (s *fileStore) writeBlob(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	name := filepath.Join(s.blobDir, hash+blobExt)
+	if _, err := os.Stat(name); err == nil {
+		return "sha256:" + hash, nil
+	}
+	if err := os.MkdirAll(s.blobDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create blob directory: [%w]", err)
+	}
+	if err := s.writeFile(name, data); err != nil {
+		return "", fmt.Errorf("failed to write blob: [%w]", err)
+	}
+	return "sha256:" + hash, nil
+}
+
+func (s *fileStore) readBlob(ref string) ([]byte, error) {
+	m := blobRef.FindStringSubmatch(ref)
+	if m == nil || m[0] != ref {
+		return nil, fmt.Errorf("invalid blob reference %q", ref)
+	}
+	if s.blobDir == "" {
+		return nil, fmt.Errorf("recording references blob %s, but no blob directory is set", ref)
+	}
+	data, err := s.readFile(filepath.Join(s.blobDir, m[1]+blobExt))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob: [%w]", err)
+	}
+	return data, nil
+}
+
+// storeBlob returns reference to body stored as a blob, or empty string if body is below the threshold.
+func (s *fileStore) storeBlob(body []byte) (string, error) {
+	if s.blobDir == "" || len(body) <= s.blobThreshold {
+		return "", nil
+	}
+	return s.writeBlob(body)
+}
+
+// writeMessage writes HTTP message dump, storing body above the threshold as a blob.
+func (s *fileStore) writeMessage(name string, msg []byte) error {
+	i := bytes.Index(msg, []byte("\r\n\r\n"))
+	if i < 0 {
+		return s.writeFile(name, msg)
+	}
+	head, body := msg[:i+2], msg[i+4:]
+	ref, err := s.storeBlob(body)
+	if err != nil {
+		return err
+	}
+	if ref == "" {
+		return s.writeFile(name, msg)
+	}
+	var buf bytes.Buffer
+	buf.Write(head)
+	fmt.Fprintf(&buf, "%s: %s\r\n\r\n", BlobHeader, ref)
+	return s.writeFile(name, buf.Bytes())
+}
+
+// readMessage reads HTTP message dump written by writeMessage, restoring body stored as a blob.
+func (s *fileStore) readMessage(name string) ([]byte, error) {
+	msg, err := s.readFile(name)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.Index(msg, []byte("\r\n\r\n"))
+	if i < 0 {
+		return msg, nil
+	}
+	lines := strings.Split(string(msg[:i]), "\r\n")
+	for j, line := range lines {
+		key, value, ok := strings.Cut(line, ":")
+		if j == 0 || !ok || !strings.EqualFold(key, BlobHeader) {
+			continue
+		}
+		body, err := s.readBlob(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", name, err)
+		}
+		lines = append(lines[:j], lines[j+1:]...)
+		out := []byte(strings.Join(lines, "\r\n") + "\r\n\r\n")
+		return append(out, body...), nil
+	}
+	return msg, nil
+}
+
+// GCBlobs removes blobs from blobDir not referenced by any recording under roots, returning removed files.
+// Pass WithEncryption to read encrypted recordings with a key other than DefaultKeyEnv.
+func GCBlobs(blobDir string, roots []string, opts ...Option) ([]string, error) {
+	if len(roots) == 0 {
+		return nil, errors.New("no recordings to look for blob references in")
+	}
+	store, err := newFileStore(newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	blobAbs, err := filepath.Abs(blobDir)
+	if err != nil {
+		return nil, err
+	}
+	referenced := make(map[string]bool)
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if abs, err := filepath.Abs(path); err == nil && abs == blobAbs {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			switch filepath.Ext(path) {
+			case ".data", ".err", ".actual", ".record":
+			default:
+				return nil
+			}
+			b, err := store.readFile(path)
+			if err != nil {
+				return err
+			}
+			for _, m := range blobRef.FindAllSubmatch(b, -1) {
+				referenced[string(m[1])] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	entries, err := os.ReadDir(blobDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, e := range entries {
+		hash, ok := strings.CutSuffix(e.Name(), blobExt)
+		if e.IsDir() || !ok || referenced[hash] {
+			continue
+		}
+		path := filepath.Join(blobDir, e.Name())
+		if err := os.Remove(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
@@ -0,0 +1,161 @@
+package replay_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/daulet/replay"
+)
+
+var largeBody = strings.Repeat("large response body\n", 100)
+
+func serveLarge(t *testing.T) string {
+	return serveHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, largeBody)
+	}))
+}
+
+func blobs(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestBlobsRunner(t *testing.T) {
+	testdataDir := t.TempDir()
+	blobDir := filepath.Join(testdataDir, ".blobs")
+	appAddr := serveLarge(t)
+
+	// two test cases recording the same response share a blob
+	for _, testCase := range []string{"first", "second"} {
+		testDir := filepath.Join(testdataDir, testCase)
+		if err := os.Mkdir(testDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		runner, err := replay.NewHTTPRunner(0, appAddr, testDir, replay.WithBlobs(blobDir, 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := runner.Serve(); err != nil {
+				t.Error(err)
+			}
+		}()
+		<-runner.Ready()
+		resp, err := http.Post("http://"+runner.Addr()+"/", "text/plain", strings.NewReader("small request body"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		runner.Stop()
+		wg.Wait()
+
+		b, err := os.ReadFile(filepath.Join(testDir, "response0.data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(b, []byte(replay.BlobHeader+": sha256:")) || bytes.Contains(b, []byte(largeBody)) {
+			t.Errorf("response body isn't stored as blob:\n%s", b)
+		}
+		b, err = os.ReadFile(filepath.Join(testDir, "request0.data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(b, []byte("small request body")) {
+			t.Errorf("request body below threshold isn't stored inline:\n%s", b)
+		}
+
+		if err := runner.Replay(false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := blobs(t, blobDir); len(got) != 1 {
+		t.Fatalf("got blobs %v, want one shared blob", got)
+	}
+
+	removed, err := replay.GCBlobs(blobDir, []string{testdataDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("removed referenced blobs %v", removed)
+	}
+	for _, testCase := range []string{"first", "second"} {
+		if err := os.RemoveAll(filepath.Join(testdataDir, testCase)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	removed, err = replay.GCBlobs(blobDir, []string{testdataDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || len(blobs(t, blobDir)) != 0 {
+		t.Errorf("removed %v, want the unreferenced blob removed", removed)
+	}
+}
+
+func TestBlobsHTTPServer(t *testing.T) {
+	dir := t.TempDir()
+	recordFile := filepath.Join(dir, "http.record")
+	blobDir := filepath.Join(dir, ".blobs")
+
+	srv, err := replay.NewHTTPServer(0, true, serveLarge(t), recordFile, replay.WithBlobs(blobDir, 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post("http://"+srv.Addr()+"/", "text/plain", strings.NewReader(largeBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(recordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(`"BodyBlob": "sha256:`)) || bytes.Contains(b, []byte("large response body")) {
+		t.Errorf("bodies aren't stored as blobs:\n%s", b)
+	}
+	// request and response bodies are the same
+	if got := blobs(t, blobDir); len(got) != 1 {
+		t.Fatalf("got blobs %v, want one", got)
+	}
+
+	srv, err = replay.NewHTTPServer(0, false, "localhost:1", recordFile, replay.WithBlobs(blobDir, 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	resp, err = http.Post("http://"+srv.Addr()+"/", "text/plain", strings.NewReader(largeBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != largeBody {
+		t.Errorf("got %s: %q", resp.Status, body)
+	}
+
+	if _, err := replay.NewHTTPServer(0, false, "localhost:1", recordFile); err == nil {
+		t.Error("expected error replaying blob references without blob directory")
+	}
+}
@@ -0,0 +1,393 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// BodyEncodingHeader marks bodies and multipart parts stored base64 encoded, because they are binary.
+const BodyEncodingHeader = "X-Replay-Body-Encoding"
+
+// multipartBoundary replaces random boundaries of multipart bodies, so recordings are stable.
+const multipartBoundary = "replay-boundary"
+
+// textMediaTypes are non text/* media types with human readable content.
+var textMediaTypes = map[string]bool{
+	"application/json":                  true,
+	"application/xml":                   true,
+	"application/javascript":            true,
+	"application/x-www-form-urlencoded": true,
+	"application/yaml":                  true,
+	"application/x-yaml":                true,
+	"application/graphql":               true,
+}
+
+// isText reports whether body of contentType is human readable. Bodies of text types still have to be
+// valid UTF-8, unknown and missing content types also must not contain control characters.
+func isText(contentType string, body []byte) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "audio/"),
+		strings.HasPrefix(mediaType, "video/"), strings.HasPrefix(mediaType, "font/"),
+		mediaType == "application/octet-stream", strings.Contains(mediaType, "protobuf"),
+		strings.HasPrefix(mediaType, "application/grpc"):
+		return false
+	case !utf8.Valid(body):
+		return false
+	case strings.HasPrefix(mediaType, "text/"), textMediaTypes[mediaType],
+		strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	for _, c := range body {
+		if c < 0x20 && c != '\n' && c != '\r' && c != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// storeBody converts body with header h to the form it's recorded in: binary bodies are base64 encoded
+// and multipart bodies get a fixed boundary with binary parts base64 encoded. It returns a copy of h
+// if it had to be changed. Bodies already in recorded form are returned as is.
+func storeBody(h http.Header, body []byte) (http.Header, []byte) {
+	if len(body) == 0 || h.Get(BodyEncodingHeader) != "" {
+		return h, body
+	}
+	contentType := h.Get("Content-Type")
+	if mediaType, params, err := mime.ParseMediaType(contentType); err == nil && strings.HasPrefix(mediaType, "multipart/") {
+		parts, err := readParts(body, params["boundary"])
+		if err == nil {
+			for _, p := range parts {
+				if p.header.Get(BodyEncodingHeader) == "" && !isText(p.header.Get("Content-Type"), p.body) {
+					p.header.Set(BodyEncodingHeader, "base64")
+					p.body = encodeBase64(p.body)
+				}
+			}
+			params["boundary"] = multipartBoundary
+			h = h.Clone()
+			h.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+			body = writeParts(parts, multipartBoundary)
+			setContentLength(h, len(body))
+			return h, body
+		}
+	}
+	if isText(contentType, body) {
+		return h, body
+	}
+	h = h.Clone()
+	h.Set(BodyEncodingHeader, "base64")
+	body = encodeBase64(body)
+	setContentLength(h, len(body))
+	return h, body
+}
+
+// loadBody reverses storeBody, returning a copy of h without BodyEncodingHeader.
+func loadBody(h http.Header, body []byte) (http.Header, []byte, error) {
+	if h.Get(BodyEncodingHeader) != "" {
+		b, err := decodeBase64(body)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode body: [%w]", err)
+		}
+		h = h.Clone()
+		h.Del(BodyEncodingHeader)
+		setContentLength(h, len(b))
+		return h, b, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] != multipartBoundary {
+		return h, body, nil
+	}
+	parts, err := readParts(body, multipartBoundary)
+	if err != nil {
+		return h, body, nil
+	}
+	for _, p := range parts {
+		if p.header.Get(BodyEncodingHeader) == "" {
+			continue
+		}
+		if p.body, err = decodeBase64(p.body); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode multipart body: [%w]", err)
+		}
+		p.header.Del(BodyEncodingHeader)
+	}
+	body = writeParts(parts, multipartBoundary)
+	h = h.Clone()
+	setContentLength(h, len(body))
+	return h, body, nil
+}
+
+// storeRequest returns a shallow copy of r with body in recorded form, see storeBody. Body of r is preserved.
+func storeRequest(r *http.Request) (*http.Request, error) {
+	body, err := snapshotBody(&r.Body)
+	if err != nil {
+		return nil, err
+	}
+	h, body := storeBody(r.Header, body)
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Header = h
+	if body != nil {
+		r2.Body = io.NopCloser(bytes.NewReader(body))
+		r2.ContentLength = int64(len(body))
+	}
+	return r2, nil
+}
+
+// storeResponse returns a shallow copy of resp with body in recorded form, see storeBody. Body of resp is preserved.
+func storeResponse(resp *http.Response) (*http.Response, error) {
+	body, err := snapshotBody(&resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	h, body := storeBody(resp.Header, body)
+	resp2 := new(http.Response)
+	*resp2 = *resp
+	resp2.Header = h
+	if body != nil {
+		resp2.Body = io.NopCloser(bytes.NewReader(body))
+		resp2.ContentLength = int64(len(body))
+	}
+	return resp2, nil
+}
+
+// loadRequest restores body of recorded request r, see loadBody.
+func loadRequest(r *http.Request) error {
+	body, err := snapshotBody(&r.Body)
+	if err != nil || body == nil {
+		return err
+	}
+	h, body, err := loadBody(r.Header, body)
+	if err != nil {
+		return err
+	}
+	r.Header = h
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	return nil
+}
+
+type bodyPart struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+func readParts(body []byte, boundary string) ([]*bodyPart, error) {
+	if boundary == "" {
+		return nil, fmt.Errorf("missing multipart boundary")
+	}
+	var parts []*bodyPart
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(p)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, &bodyPart{header: p.Header, body: b})
+	}
+}
+
+func writeParts(parts []*bodyPart, boundary string) []byte {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.SetBoundary(boundary)
+	for _, p := range parts {
+		w, _ := mw.CreatePart(p.header)
+		w.Write(p.body)
+	}
+	mw.Close()
+	return buf.Bytes()
+}
+
+// encodeBase64 encodes b in lines of 76 characters, so recordings stay reviewable.
+func encodeBase64(b []byte) []byte {
+	s := base64.StdEncoding.EncodeToString(b)
+	var buf bytes.Buffer
+	for len(s) > 76 {
+		buf.WriteString(s[:76])
+		buf.WriteString("\r\n")
+		s = s[76:]
+	}
+	buf.WriteString(s)
+	return buf.Bytes()
+}
+
+func decodeBase64(b []byte) ([]byte, error) {
+	s := strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, string(b))
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// responseDiff reports differences between want and got responses dumped by dumpResponse, empty if there are none.
+// Binary bodies are compared by size and hash, forms field by field, everything else as text.
+// Captured values are masked first, so known differences are ignored.
+func responseDiff(want, got []byte, masks captureMasks) (string, error) {
+	wantHead, wantHeader, wantBody, err := splitResponse(want)
+	if err != nil {
+		return "", err
+	}
+	gotHead, _, gotBody, err := splitResponse(got)
+	if err != nil {
+		return "", err
+	}
+	wantHead, gotHead = masks.heads(wantHead, gotHead)
+	wantBody, gotBody = masks.bodies(wantBody, gotBody)
+	diff := cmp.Diff(wantHead, gotHead)
+	if bytes.Equal(wantBody, gotBody) {
+		return diff, nil
+	}
+	return diff + bodyDiff(wantHeader.Get("Content-Type"), wantBody, gotBody), nil
+}
+
+// splitResponse splits response dump into its head and decoded body. Content-Length is left out of the head,
+// since it only differs if bodies do.
+func splitResponse(raw []byte) (string, http.Header, []byte, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), nil)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to read response: [%w]", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to read response body: [%w]", err)
+	}
+	var head strings.Builder
+	fmt.Fprintf(&head, "%s %s\r\n", resp.Proto, resp.Status)
+	resp.Header.WriteSubset(&head, map[string]bool{"Content-Length": true})
+	h, body, err := loadBody(resp.Header, body)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return head.String(), h, body, nil
+}
+
+func bodyDiff(contentType string, want, got []byte) string {
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		wantForm, err1 := url.ParseQuery(string(want))
+		gotForm, err2 := url.ParseQuery(string(got))
+		if err1 == nil && err2 == nil {
+			return fieldsDiff(formFields(wantForm), formFields(gotForm))
+		}
+	case strings.HasPrefix(mediaType, "multipart/"):
+		wantParts, err1 := readParts(want, params["boundary"])
+		gotParts, err2 := readParts(got, params["boundary"])
+		if err1 == nil && err2 == nil {
+			return fieldsDiff(partFields(wantParts), partFields(gotParts))
+		}
+	}
+	if !isText(contentType, want) || !isText(contentType, got) {
+		return binaryDiff("body", want, got)
+	}
+	return cmp.Diff(string(want), string(got))
+}
+
+type field struct {
+	contentType string
+	value       []byte
+}
+
+func formFields(form url.Values) map[string]field {
+	fields := make(map[string]field)
+	for k, vs := range form {
+		for i, v := range vs {
+			fields[fieldName(k, i, len(vs))] = field{"text/plain", []byte(v)}
+		}
+	}
+	return fields
+}
+
+// partFields names parts by form field name, or position if a part isn't a form field.
+func partFields(parts []*bodyPart) map[string]field {
+	counts := make(map[string]int)
+	names := make([]string, len(parts))
+	for i, p := range parts {
+		_, params, _ := mime.ParseMediaType(p.header.Get("Content-Disposition"))
+		names[i] = params["name"]
+		if names[i] == "" {
+			names[i] = fmt.Sprintf("part %d", i)
+		}
+		counts[names[i]]++
+	}
+	fields := make(map[string]field)
+	seen := make(map[string]int)
+	for i, p := range parts {
+		name := fieldName(names[i], seen[names[i]], counts[names[i]])
+		seen[names[i]]++
+		fields[name] = field{p.header.Get("Content-Type"), p.body}
+	}
+	return fields
+}
+
+func fieldName(name string, i, n int) string {
+	if n == 1 {
+		return name
+	}
+	return fmt.Sprintf("%s[%d]", name, i)
+}
+
+func fieldsDiff(want, got map[string]field) string {
+	var names []string
+	for name := range want {
+		names = append(names, name)
+	}
+	for name := range got {
+		if _, ok := want[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var buf strings.Builder
+	for _, name := range names {
+		w, inWant := want[name]
+		g, inGot := got[name]
+		switch {
+		case !inGot:
+			fmt.Fprintf(&buf, "field %q: missing\n", name)
+		case !inWant:
+			fmt.Fprintf(&buf, "field %q: unexpected\n", name)
+		case bytes.Equal(w.value, g.value):
+		case !isText(w.contentType, w.value) || !isText(g.contentType, g.value):
+			buf.WriteString(binaryDiff(fmt.Sprintf("field %q", name), w.value, g.value))
+		default:
+			fmt.Fprintf(&buf, "field %q:\n%s", name, cmp.Diff(string(w.value), string(g.value)))
+		}
+	}
+	return buf.String()
+}
+
+// binaryDiff summarizes difference of binary want and got values by their size and hash.
+func binaryDiff(name string, want, got []byte) string {
+	return fmt.Sprintf("%s:\n-\t%s\n+\t%s\n", name, describeBinary(want), describeBinary(got))
+}
+
+func describeBinary(b []byte) string {
+	sum := sha256.Sum256(b)
+	return fmt.Sprintf("%d bytes, sha256 %s", len(b), hex.EncodeToString(sum[:]))
+}
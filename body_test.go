@@ -0,0 +1,151 @@
+package replay_test
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/daulet/replay"
+)
+
+// serveUpload starts an application that responds to multipart uploads with a form echoing the fields
+// prefixed with prefix, and a binary thumbnail.
+func serveUpload(t *testing.T, prefix string, thumbnail []byte) string {
+	return serveHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", mw.FormDataContentType())
+		mw.WriteField("title", prefix+r.FormValue("title"))
+		fw, _ := mw.CreateFormFile("thumbnail", "thumb.png")
+		fw.Write(thumbnail)
+		mw.Close()
+	}))
+}
+
+func sendUpload(t *testing.T, addr string) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("title", "cat")
+	fw, _ := mw.CreateFormFile("image", "cat.png")
+	fw.Write([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x01\x00"))
+	mw.Close()
+	resp, err := http.Post("http://"+addr+"/upload", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %s", resp.Status)
+	}
+}
+
+func TestBinaryMultipartRunner(t *testing.T) {
+	thumbnail := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x00")
+	testDir := t.TempDir()
+	runner, err := replay.NewHTTPRunner(0, serveUpload(t, "re: ", thumbnail), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := runner.Serve(); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-runner.Ready()
+	sendUpload(t, runner.Addr())
+	runner.Stop()
+	wg.Wait()
+
+	for _, name := range []string{"request0.data", "response0.data"} {
+		b, err := os.ReadFile(filepath.Join(testDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(b, []byte("boundary=replay-boundary")) {
+			t.Errorf("%s doesn't have normalized boundary:\n%s", name, b)
+		}
+		if !bytes.Contains(b, []byte(replay.BodyEncodingHeader+": base64")) {
+			t.Errorf("%s doesn't have binary part base64 encoded:\n%s", name, b)
+		}
+		if bytes.Contains(b, []byte("PNG")) {
+			t.Errorf("%s contains raw binary:\n%s", name, b)
+		}
+	}
+
+	// request is restored on replay, so the application parses the upload
+	runner, err = replay.NewHTTPRunner(0, serveUpload(t, "re: ", thumbnail), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Replay(false); err != nil {
+		t.Fatal(err)
+	}
+
+	runner, err = replay.NewHTTPRunner(0, serveUpload(t, "fwd: ", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x01")), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runner.Replay(false)
+	if err == nil {
+		t.Fatal("expected diff")
+	}
+	for _, want := range []string{`field "title":`, `"re: cat"`, `"fwd: cat"`, `field "thumbnail":`, "12 bytes, sha256 "} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("diff doesn't contain %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestBodyTextHTTPServer(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "http.record")
+	srv, err := replay.NewHTTPServer(0, true, serveUpload(t, "re: ", []byte{0, 1, 2}), recordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post("http://"+srv.Addr()+"/upload", "text/plain", strings.NewReader("not a form"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(recordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"not a form"`, `"BodyText": "request Content-Type isn't multipart/form-data\n"`} {
+		if !bytes.Contains(b, []byte(want)) {
+			t.Errorf("record doesn't contain %s:\n%s", want, b)
+		}
+	}
+
+	srv, err = replay.NewHTTPServer(0, false, "localhost:1", recordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	resp, err = http.Post("http://"+srv.Addr()+"/upload", "text/plain", strings.NewReader("not a form"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || !strings.HasPrefix(string(body), "request Content-Type isn't") {
+		t.Errorf("got %s: %s", resp.Status, body)
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/daulet/replay"
+	"golang.org/x/term"
+)
+
+const (
+	reset   = "\x1b[0m"
+	bold    = "\x1b[1m"
+	reverse = "\x1b[7m"
+	red     = "\x1b[31m"
+	green   = "\x1b[32m"
+)
+
+func browse(args []string, stdout io.Writer) error {
+	flags := newFlagSet("browse", "testdata...")
+	keyEnv := flags.String("key-env", "", "environment variable to read the key of encrypted recordings from")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errUsage
+	}
+	b, err := newBrowser(flags.Args(), encryption(*keyEnv))
+	if err != nil {
+		return err
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return errors.New("browse needs an interactive terminal")
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+	// switch to the alternate screen and hide the cursor, so the shell is left as it was
+	fmt.Fprint(stdout, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(stdout, "\x1b[?25h\x1b[?1049l")
+
+	in := bufio.NewReader(os.Stdin)
+	for !b.quit {
+		width, height, err := term.GetSize(fd)
+		if err != nil {
+			width, height = 80, 24
+		}
+		fmt.Fprint(stdout, "\x1b[H\x1b[2J"+b.render(width, height))
+		k, err := readKey(in)
+		if err != nil {
+			return err
+		}
+		if err := b.handle(k); err != nil {
+			b.status = err.Error()
+		}
+	}
+	return nil
+}
+
+// readKey reads a key press from terminal input in raw mode, naming special keys, e.g. "up" or "enter".
+func readKey(r *bufio.Reader) (string, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	switch c {
+	case '\r', '\n':
+		return "enter", nil
+	case 127, '\b':
+		return "backspace", nil
+	case 3:
+		// Ctrl-C doesn't interrupt in raw mode
+		return "q", nil
+	case 0x1b:
+		if r.Buffered() == 0 {
+			return "esc", nil
+		}
+		if c, _ := r.ReadByte(); c != '[' && c != 'O' {
+			return "esc", nil
+		}
+		seq, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch seq {
+		case 'A':
+			return "up", nil
+		case 'B':
+			return "down", nil
+		case 'C':
+			return "right", nil
+		case 'D':
+			return "left", nil
+		case '5', '6':
+			// page keys are terminated by a tilde
+			r.ReadByte()
+			if seq == '5' {
+				return "pgup", nil
+			}
+			return "pgdown", nil
+		}
+		return "", nil
+	}
+	return string(c), nil
+}
+
+// browser lists test cases and shows their exchanges, kept apart from the terminal, so it's testable.
+type browser struct {
+	dirs []string
+	opts []replay.Option
+	// differs counts exchanges of each test case whose last replay differed
+	differs map[string]int
+
+	selected int
+	// exchanges of the open test case, nil while the list of test cases is shown
+	exchanges []*replay.Exchange
+	exchange  int
+	// showActual shows recorded and actual responses instead of request and response
+	showActual bool
+	scroll     int
+	// height of the last rendered screen, to page through exchanges
+	height int
+	status string
+	quit   bool
+}
+
+func newBrowser(roots []string, opts []replay.Option) (*browser, error) {
+	dirs, err := replay.FindTestCases(roots)
+	if err != nil {
+		return nil, err
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("no test cases in %s", strings.Join(roots, ", "))
+	}
+	b := &browser{dirs: dirs, opts: opts, differs: map[string]int{}, height: 24}
+	for _, dir := range dirs {
+		b.countDiffers(dir)
+	}
+	return b, nil
+}
+
+func (b *browser) countDiffers(dir string) {
+	actual, _ := filepath.Glob(filepath.Join(dir, "response*.actual"))
+	b.differs[dir] = len(actual)
+}
+
+func (b *browser) handle(k string) error {
+	b.status = ""
+	if k == "q" {
+		b.quit = true
+		return nil
+	}
+	if b.exchanges == nil {
+		switch k {
+		case "up", "k":
+			b.selected = (b.selected + len(b.dirs) - 1) % len(b.dirs)
+		case "down", "j":
+			b.selected = (b.selected + 1) % len(b.dirs)
+		case "enter", "right", "l":
+			return b.open()
+		case "esc":
+			b.quit = true
+		}
+		return nil
+	}
+	page := b.height - 4
+	switch k {
+	case "left", "h", "p":
+		if b.exchange > 0 {
+			b.show(b.exchange - 1)
+		}
+	case "right", "l", "n":
+		if b.exchange < len(b.exchanges)-1 {
+			b.show(b.exchange + 1)
+		}
+	case "up", "k":
+		if b.scroll > 0 {
+			b.scroll--
+		}
+	case "down", "j":
+		b.scroll++
+	case "pgup":
+		b.scroll -= page
+		if b.scroll < 0 {
+			b.scroll = 0
+		}
+	case "pgdown":
+		b.scroll += page
+	case "d":
+		if b.exchanges[b.exchange].Actual == nil {
+			b.status = "the last replay responded as recorded"
+			return nil
+		}
+		b.showActual = !b.showActual
+	case "a":
+		return b.accept()
+	case "esc", "backspace":
+		b.exchanges = nil
+	}
+	return nil
+}
+
+// open opens the selected test case on its first exchange that differs, if any.
+func (b *browser) open() error {
+	exchanges, err := replay.ReadExchanges(b.dirs[b.selected], b.opts...)
+	if err != nil {
+		return err
+	}
+	if len(exchanges) == 0 {
+		return fmt.Errorf("%s has no exchanges", b.dirs[b.selected])
+	}
+	b.exchanges = exchanges
+	b.show(0)
+	for i, ex := range exchanges {
+		if ex.Actual != nil {
+			b.show(i)
+			break
+		}
+	}
+	return nil
+}
+
+func (b *browser) show(i int) {
+	b.exchange, b.scroll = i, 0
+	b.showActual = b.exchanges[i].Actual != nil
+}
+
+// accept replaces the recorded response of the shown exchange with the actual one.
+func (b *browser) accept() error {
+	dir, i := b.dirs[b.selected], b.exchange
+	if b.exchanges[i].Actual == nil {
+		b.status = "nothing to accept, the last replay responded as recorded"
+		return nil
+	}
+	if err := replay.AcceptActual(dir, i, b.opts...); err != nil {
+		return err
+	}
+	exchanges, err := replay.ReadExchanges(dir, b.opts...)
+	if err != nil {
+		return err
+	}
+	b.exchanges = exchanges
+	b.show(i)
+	b.countDiffers(dir)
+	b.status = fmt.Sprintf("accepted actual response %d", i)
+	return nil
+}
+
+// render draws the screen of width by height characters, lines are separated for terminals in raw mode.
+func (b *browser) render(width, height int) string {
+	b.height = height
+	var lines []string
+	if b.exchanges == nil {
+		lines = b.renderList(width, height)
+	} else {
+		lines = b.renderExchange(width, height)
+	}
+	return strings.Join(lines, "\r\n")
+}
+
+func (b *browser) renderList(width, height int) []string {
+	lines := []string{bold + fit(fmt.Sprintf("%d test cases", len(b.dirs)), width) + reset}
+	rows := height - 2
+	first := 0
+	if b.selected >= rows {
+		first = b.selected - rows + 1
+	}
+	for i := first; i < len(b.dirs) && i < first+rows; i++ {
+		var suffix string
+		if n := b.differs[b.dirs[i]]; n > 0 {
+			suffix = fmt.Sprintf("  %d differ", n)
+		}
+		line := fit("  "+b.dirs[i], width-len(suffix))
+		if suffix != "" {
+			line += red + suffix + reset
+		}
+		if i == b.selected {
+			line = reverse + line + reset
+		}
+		lines = append(lines, line)
+	}
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	return append(lines, b.footer("↑↓ select  enter open  q quit", width))
+}
+
+func (b *browser) renderExchange(width, height int) []string {
+	ex := b.exchanges[b.exchange]
+	title := fmt.Sprintf("%s  exchange %d/%d", b.dirs[b.selected], b.exchange+1, len(b.exchanges))
+	if ex.Actual != nil {
+		title += "  differs from the last replay"
+	}
+	lines := []string{bold + fit(title, width) + reset}
+
+	leftTitle, rightTitle := "request", "response"
+	left, right := splitLines(ex.Request), splitLines(ex.Response)
+	if ex.Response == nil {
+		right = []string{"(no recorded response)"}
+	} else if ex.Failed {
+		rightTitle = "response (error)"
+	}
+	var removed, added []bool
+	if b.showActual {
+		leftTitle, rightTitle = "recorded response", "actual response"
+		left, right = right, splitLines(ex.Actual)
+		removed, added = changedLines(left, right)
+	}
+	colWidth := (width - 3) / 2
+	lines = append(lines, bold+fit(leftTitle, colWidth)+" │ "+fit(rightTitle, colWidth)+reset)
+
+	rows := height - 3
+	n := len(left)
+	if len(right) > n {
+		n = len(right)
+	}
+	b.clampScroll(n, rows)
+	for i := b.scroll; i < b.scroll+rows; i++ {
+		lines = append(lines, column(left, removed, i, colWidth, red)+" │ "+column(right, added, i, colWidth, green))
+	}
+	return append(lines, b.footer("←→ exchange  ↑↓ scroll  d toggle diff  a accept actual  esc back  q quit", width))
+}
+
+// clampScroll keeps scroll within n lines shown rows at a time.
+func (b *browser) clampScroll(n, rows int) {
+	if b.scroll > n-rows {
+		b.scroll = n - rows
+	}
+	if b.scroll < 0 {
+		b.scroll = 0
+	}
+}
+
+func (b *browser) footer(help string, width int) string {
+	if b.status != "" {
+		return reverse + fit(b.status, width) + reset
+	}
+	return fit(help, width)
+}
+
+// column returns i-th of lines fit to width, colored if it's marked as changed.
+func column(lines []string, changed []bool, i, width int, color string) string {
+	if i >= len(lines) {
+		return fit("", width)
+	}
+	s := fit(lines[i], width)
+	if i < len(changed) && changed[i] {
+		return color + s + reset
+	}
+	return s
+}
+
+// splitLines splits a dumped HTTP message into lines printable in a column.
+func splitLines(b []byte) []string {
+	if b == nil {
+		return nil
+	}
+	s := strings.ReplaceAll(string(b), "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\t", "    ")
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// fit truncates or pads s with spaces to width characters, replacing control characters.
+func fit(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	runes := []rune(s)
+	for i, r := range runes {
+		if r < ' ' || r == 0x7f {
+			runes[i] = '.'
+		}
+	}
+	if len(runes) > width {
+		return string(runes[:width-1]) + "…"
+	}
+	return string(runes) + strings.Repeat(" ", width-len(runes))
+}
+
+// maxLCSCells bounds the table of the line diff, longer messages are compared line by line.
+const maxLCSCells = 1 << 22
+
+// changedLines marks lines of a and b that aren't part of their longest common subsequence,
+// i.e. lines removed from a and added in b.
+func changedLines(a, b []string) (removed, added []bool) {
+	removed, added = make([]bool, len(a)), make([]bool, len(b))
+	if len(a)*len(b) > maxLCSCells {
+		for i := range a {
+			removed[i] = i >= len(b) || a[i] != b[i]
+		}
+		for i := range b {
+			added[i] = i >= len(a) || a[i] != b[i]
+		}
+		return removed, added
+	}
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			removed[i] = true
+			i++
+		default:
+			added[j] = true
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		removed[i] = true
+	}
+	for ; j < len(b); j++ {
+		added[j] = true
+	}
+	return removed, added
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBrowser(t *testing.T) {
+	dir := t.TempDir()
+	testDir := filepath.Join(dir, "orders")
+	if err := os.MkdirAll(testDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"request0.data":    "GET /orders HTTP/1.1\r\nHost: app\r\n\r\n",
+		"response0.data":   "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\n[]",
+		"request1.data":    "GET /orders/1 HTTP/1.1\r\nHost: app\r\n\r\n",
+		"response1.data":   "HTTP/1.1 200 OK\r\nContent-Length: 12\r\n\r\n{\"id\": \"1\"}\n",
+		"response1.actual": "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\n{\"id\": 1}\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(testDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := newBrowser([]string{dir}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if screen := b.render(80, 10); !strings.Contains(screen, testDir) || !strings.Contains(screen, red+"  1 differ") {
+		t.Errorf("got list:\n%s", screen)
+	}
+
+	// the test case opens on the exchange that differs, showing both responses
+	if err := b.handle("enter"); err != nil {
+		t.Fatal(err)
+	}
+	screen := b.render(80, 10)
+	for _, want := range []string{"exchange 2/2", "recorded response", "actual response", red + `{"id": "1"}`, green + `{"id": 1}`} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("exchange screen doesn't contain %q:\n%s", want, screen)
+		}
+	}
+	if err := b.handle("d"); err != nil {
+		t.Fatal(err)
+	}
+	if screen := b.render(80, 10); !strings.Contains(screen, "GET /orders/1 HTTP/1.1") {
+		t.Errorf("got request screen:\n%s", screen)
+	}
+
+	if err := b.handle("a"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(testDir, "response1.data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != files["response1.actual"] {
+		t.Errorf("got accepted response %q, want %q", got, files["response1.actual"])
+	}
+	if b.differs[testDir] != 0 {
+		t.Errorf("got %d differing exchanges after accepting, want 0", b.differs[testDir])
+	}
+
+	// the other exchange responded as recorded
+	for _, k := range []string{"left", "a"} {
+		if err := b.handle(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.HasPrefix(b.status, "nothing to accept") {
+		t.Errorf("got status %q", b.status)
+	}
+	for _, k := range []string{"esc", "q"} {
+		if err := b.handle(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.exchanges != nil || !b.quit {
+		t.Error("expected to quit from the list of test cases")
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("\x1b[A\x1b[Bq\r\x1b[6~\x7f"))
+	var got []string
+	for i := 0; i < 6; i++ {
+		k, err := readKey(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, k)
+	}
+	if want := []string{"up", "down", "q", "enter", "pgdown", "backspace"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestChangedLines(t *testing.T) {
+	removed, added := changedLines([]string{"a", "b", "c", "d"}, []string{"a", "c", "e", "d", "f"})
+	if want := []bool{false, true, false, false}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("got removed %v, want %v", removed, want)
+	}
+	if want := []bool{false, false, true, false, true}; !reflect.DeepEqual(added, want) {
+		t.Errorf("got added %v, want %v", added, want)
+	}
+}
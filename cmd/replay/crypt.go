@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/daulet/replay"
+	"github.com/daulet/replay/internal/atomicfile"
+)
+
+func keygen(args []string, stdout io.Writer) error {
+	flags := newFlagSet("keygen", "")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	key, err := replay.GenerateKey()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, key)
+	return nil
+}
+
+func encrypt(args []string, stdout io.Writer) error {
+	flags := newFlagSet("encrypt", "path...")
+	keyEnv := flags.String("key-env", replay.DefaultKeyEnv, "environment variable to read the key from")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	key, err := replay.KeyFromEnv(*keyEnv)
+	if err != nil {
+		return err
+	}
+	return forEachRecording(flags.Args(), func(path string, data []byte) error {
+		// test case files are kept in plain text, they are only walked to rotate and decrypt ones
+		// encrypted by earlier versions
+		if replay.IsEncrypted(data) || filepath.Base(path) == replay.TestCaseFile {
+			return nil
+		}
+		data, err := replay.Encrypt(data, key)
+		if err != nil {
+			return err
+		}
+		return writeFile(path, data)
+	})
+}
+
+func decrypt(args []string, stdout io.Writer) error {
+	flags := newFlagSet("decrypt", "path...")
+	keyEnv := flags.String("key-env", replay.DefaultKeyEnv, "environment variable to read the key from")
+	inPlace := flags.Bool("w", false, "write decrypted recordings in place instead of printing them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	key, err := replay.KeyFromEnv(*keyEnv)
+	if err != nil {
+		return err
+	}
+	return forEachRecording(flags.Args(), func(path string, data []byte) error {
+		if !replay.IsEncrypted(data) {
+			return nil
+		}
+		data, err := replay.Decrypt(data, key)
+		if err != nil {
+			return err
+		}
+		if *inPlace {
+			return writeFile(path, data)
+		}
+		fmt.Fprintf(stdout, "==> %s <==\n", path)
+		_, err = stdout.Write(data)
+		fmt.Fprintln(stdout)
+		return err
+	})
+}
+
+func rotate(args []string, stdout io.Writer) error {
+	flags := newFlagSet("rotate", "path...")
+	oldKeyEnv := flags.String("old-key-env", replay.DefaultKeyEnv, "environment variable to read the current key from")
+	newKeyEnv := flags.String("new-key-env", "", "environment variable to read the new key from")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *newKeyEnv == "" {
+		flags.Usage()
+		return errUsage
+	}
+	oldKey, err := replay.KeyFromEnv(*oldKeyEnv)
+	if err != nil {
+		return err
+	}
+	newKey, err := replay.KeyFromEnv(*newKeyEnv)
+	if err != nil {
+		return err
+	}
+	return forEachRecording(flags.Args(), func(path string, data []byte) error {
+		if !replay.IsEncrypted(data) {
+			return nil
+		}
+		data, err := replay.Decrypt(data, oldKey)
+		if err != nil {
+			return err
+		}
+		data, err = replay.Encrypt(data, newKey)
+		if err != nil {
+			return err
+		}
+		return writeFile(path, data)
+	})
+}
+
+// forEachRecording calls fn with contents of every recording file in paths, walking directories.
+func forEachRecording(paths []string, fn func(path string, data []byte) error) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("%w: no paths given", errUsage)
+	}
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || (path != root && !isRecording(path)) {
+				return nil
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err := fn(path, data); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isRecording reports whether path is a runner test case file, a dependency recording or a blob.
+func isRecording(path string) bool {
+	if filepath.Base(path) == replay.TestCaseFile {
+		return true
+	}
+	switch filepath.Ext(path) {
+	case ".data", ".err", ".actual", ".record", ".blob":
+		return true
+	}
+	return false
+}
+
+// writeFile replaces contents of existing path atomically, keeping its permissions,
+// so an interrupted run never leaves a partially written recording.
+func writeFile(path string, data []byte) error {
+	return atomicfile.WriteFile(path, data, 0o644)
+}
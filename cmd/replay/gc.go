@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/daulet/replay"
+)
+
+func gc(args []string, stdout io.Writer) error {
+	flags := newFlagSet("gc", "path...")
+	blobDir := flags.String("blobs", "", "blob directory to collect")
+	keyEnv := flags.String("key-env", "", "environment variable to read the key of encrypted recordings from")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *blobDir == "" {
+		flags.Usage()
+		return errUsage
+	}
+	var opts []replay.Option
+	if *keyEnv != "" {
+		opts = append(opts, replay.WithEncryption(*keyEnv))
+	}
+	removed, err := replay.GCBlobs(*blobDir, flags.Args(), opts...)
+	for _, path := range removed {
+		fmt.Fprintf(stdout, "removed %s\n", path)
+	}
+	return err
+}
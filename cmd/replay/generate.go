@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/daulet/replay"
+)
+
+func curl(args []string, stdout io.Writer) error {
+	flags := newFlagSet("curl", "testdir")
+	file := flags.String("f", "", "file with curl commands, one per line, stdin if empty")
+	keyEnv := flags.String("key-env", "", "environment variable to read the key to encrypt recordings with from")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errUsage
+	}
+	in := io.Reader(os.Stdin)
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	commands, err := readCommands(in)
+	if err != nil {
+		return err
+	}
+	if len(commands) == 0 {
+		return fmt.Errorf("no curl commands")
+	}
+	if err := replay.GenerateFromCurl(commands, flags.Arg(0), encryption(*keyEnv)...); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "generated %s with %d requests\n", flags.Arg(0), len(commands))
+	return nil
+}
+
+func openapi(args []string, stdout io.Writer) error {
+	flags := newFlagSet("openapi", "spec.yaml testdata")
+	keyEnv := flags.String("key-env", "", "environment variable to read the key to encrypt recordings with from")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return errUsage
+	}
+	names, err := replay.GenerateFromOpenAPI(flags.Arg(0), flags.Arg(1), encryption(*keyEnv)...)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Fprintf(stdout, "generated %s\n", name)
+	}
+	return nil
+}
+
+func coverage(args []string, stdout io.Writer) error {
+	flags := newFlagSet("coverage", "spec.yaml testdata...")
+	keyEnv := flags.String("key-env", "", "environment variable to read the key to decrypt recordings with from")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return errUsage
+	}
+	report, err := replay.Coverage(flags.Arg(0), flags.Args()[1:], encryption(*keyEnv)...)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(stdout, report.String())
+	return err
+}
+
+func encryption(keyEnv string) []replay.Option {
+	if keyEnv == "" {
+		return nil
+	}
+	return []replay.Option{replay.WithEncryption(keyEnv)}
+}
+
+// readCommands reads command lines, joining lines continued with a backslash and skipping empty lines and comments.
+func readCommands(r io.Reader) ([]string, error) {
+	var commands []string
+	var command strings.Builder
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if command.Len() == 0 && (strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#")) {
+			continue
+		}
+		command.WriteString(line)
+		if strings.HasSuffix(line, `\`) {
+			command.WriteString("\n")
+			continue
+		}
+		commands = append(commands, command.String())
+		command.Reset()
+	}
+	if command.Len() > 0 {
+		commands = append(commands, command.String())
+	}
+	return commands, s.Err()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daulet/replay"
+)
+
+var _ replay.Logger = (*textLogger)(nil)
+
+// textLogger writes logs as lines of text, e.g. "15:04:05.000 INFO proxied exchange method=GET status=200",
+// debug logs only if debug is set.
+type textLogger struct {
+	debug bool
+
+	mux sync.Mutex
+	w   io.Writer
+}
+
+func (l *textLogger) Debug(msg string, args ...any) {
+	if l.debug {
+		l.log("DEBUG", msg, args)
+	}
+}
+
+func (l *textLogger) Info(msg string, args ...any)  { l.log("INFO", msg, args) }
+func (l *textLogger) Warn(msg string, args ...any)  { l.log("WARN", msg, args) }
+func (l *textLogger) Error(msg string, args ...any) { l.log("ERROR", msg, args) }
+
+func (l *textLogger) log(level, msg string, args []any) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s", time.Now().Format("15:04:05.000"), level, msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			fmt.Fprintf(&b, " %v", args[i])
+			break
+		}
+		fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+	}
+	b.WriteByte('\n')
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	io.WriteString(l.w, b.String())
+}
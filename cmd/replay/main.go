@@ -0,0 +1,91 @@
+// Command replay manages recordings made by github.com/daulet/replay.
+//
+// Usage:
+//
+//	replay <command> [flags] [arguments]
+//
+// Run "replay help" for the list of commands.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+type command struct {
+	summary string
+	run     func(args []string, stdout io.Writer) error
+}
+
+var commands = map[string]command{
+	"keygen":   {"print a new random encryption key", keygen},
+	"encrypt":  {"encrypt recordings in place", encrypt},
+	"decrypt":  {"decrypt recordings to stdout, or in place with -w", decrypt},
+	"rotate":   {"re-encrypt recordings with a new key", rotate},
+	"gc":       {"remove blobs not referenced by recordings", gc},
+	"pcap":     {"import HTTP traffic from a tcpdump capture as a test case", importPCAP},
+	"curl":     {"generate a test case from curl commands", curl},
+	"openapi":  {"generate test cases from request examples of an OpenAPI document", openapi},
+	"coverage": {"report OpenAPI operations, statuses and parameters test cases don't exercise", coverage},
+	"pact":     {"export a dependency recording as a Pact consumer contract", pact},
+	"verify":   {"verify a provider against a Pact consumer contract", verify},
+	"stub":     {"serve a dependency recording as a mock server until interrupted", stub},
+	"browse":   {"browse test cases and review differing responses saved by replaying with -actual", browse},
+}
+
+var errUsage = errors.New("usage")
+
+func main() {
+	err := run(os.Args[1:], os.Stdout)
+	if errors.Is(err, errUsage) || errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout io.Writer) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(os.Stderr)
+		return errUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "replay: unknown command %q\n", args[0])
+		usage(os.Stderr)
+		return errUsage
+	}
+	return cmd.run(args[1:], stdout)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: replay <command> [flags] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `Run "replay <command> -h" for command flags.`)
+}
+
+// newFlagSet returns a flag set for command name that reports errors instead of exiting.
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: replay %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daulet/replay"
+	"github.com/daulet/replay/internal/pcap"
+)
+
+func TestEncryptRotateDecrypt(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "case", "request0.data")
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	const plain = "GET /foo HTTP/1.1\r\n\r\n"
+	if err := os.WriteFile(file, []byte(plain), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	testCase := filepath.Join(dir, "case", replay.TestCaseFile)
+	const testCasePlain = `{"captures":[{"name":"id","response":0,"jsonPath":"id"}]}`
+	if err := os.WriteFile(testCase, []byte(testCasePlain), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for i := 0; i < 2; i++ {
+		var out bytes.Buffer
+		if err := run([]string{"keygen"}, &out); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, strings.TrimSpace(out.String()))
+	}
+	t.Setenv("TEST_OLD_KEY", keys[0])
+	t.Setenv("TEST_NEW_KEY", keys[1])
+
+	steps := []struct {
+		args []string
+		key  string
+	}{
+		{args: []string{"encrypt", "-key-env", "TEST_OLD_KEY", dir}, key: keys[0]},
+		{args: []string{"rotate", "-old-key-env", "TEST_OLD_KEY", "-new-key-env", "TEST_NEW_KEY", dir}, key: keys[1]},
+	}
+	for _, step := range steps {
+		if err := run(step.args, &bytes.Buffer{}); err != nil {
+			t.Fatalf("%v: %v", step.args, err)
+		}
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := replay.ParseKey(step.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := replay.Decrypt(b, key)
+		if err != nil {
+			t.Fatalf("%v: %v", step.args, err)
+		}
+		if string(got) != plain {
+			t.Errorf("%v: got %q, want %q", step.args, got, plain)
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Errorf("%v: got mode %v, want permissions kept", step.args, info.Mode())
+		}
+	}
+
+	// test case file stays in plain text
+	if b, _ := os.ReadFile(testCase); string(b) != testCasePlain {
+		t.Errorf("got %q, want %q", b, testCasePlain)
+	}
+
+	var out bytes.Buffer
+	if err := run([]string{"decrypt", "-key-env", "TEST_NEW_KEY", dir}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), plain) {
+		t.Errorf("got %q, want it to contain %q", out.String(), plain)
+	}
+
+	if err := run([]string{"decrypt", "-key-env", "TEST_NEW_KEY", "-w", dir}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(file); string(b) != plain {
+		t.Errorf("got %q, want %q", b, plain)
+	}
+}
+
+func TestRotateEncryptedTestCase(t *testing.T) {
+	dir := t.TempDir()
+	var keys [][]byte
+	for _, env := range []string{"TEST_OLD_KEY", "TEST_NEW_KEY"} {
+		var out bytes.Buffer
+		if err := run([]string{"keygen"}, &out); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv(env, strings.TrimSpace(out.String()))
+		key, err := replay.ParseKey(strings.TrimSpace(out.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	// test case file encrypted by earlier versions
+	const plain = `{"captures":[{"name":"id","response":0,"jsonPath":"id"}]}`
+	data, err := replay.Encrypt([]byte(plain), keys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, replay.TestCaseFile)
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := run([]string{"rotate", "-old-key-env", "TEST_OLD_KEY", "-new-key-env", "TEST_NEW_KEY", dir}, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := replay.Decrypt(b, keys[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != plain {
+		t.Errorf("got %q, want %q", got, plain)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	if err := run([]string{"bogus"}, &bytes.Buffer{}); err == nil {
+		t.Error("expected error for unknown command")
+	}
+}
+
+func TestGC(t *testing.T) {
+	dir := t.TempDir()
+	blobDir := filepath.Join(dir, ".blobs")
+	if err := os.MkdirAll(filepath.Join(dir, "case"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(blobDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	used, unused := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	files := map[string]string{
+		filepath.Join(dir, "case", "response0.data"): "HTTP/1.1 200 OK\r\n" + replay.BlobHeader + ": sha256:" + used + "\r\n\r\n",
+		filepath.Join(blobDir, used+".blob"):         "used",
+		filepath.Join(blobDir, unused+".blob"):       "unused",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	if err := run([]string{"gc", "-blobs", blobDir, dir}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "removed " + filepath.Join(blobDir, unused+".blob") + "\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+	if _, err := os.Stat(filepath.Join(blobDir, used+".blob")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImportPCAP(t *testing.T) {
+	dir := t.TempDir()
+	capture := filepath.Join(dir, "capture.pcap")
+	f, err := os.Create(capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := pcap.NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := netip.MustParseAddrPort("10.0.0.1:50000"), netip.MustParseAddrPort("10.0.0.2:80")
+	for _, seg := range []struct {
+		src, dst netip.AddrPort
+		seq      uint32
+		flags    uint8
+		payload  string
+	}{
+		{client, server, 0, pcap.FlagSYN, ""},
+		{server, client, 0, pcap.FlagSYN | pcap.FlagACK, ""},
+		{client, server, 1, pcap.FlagACK, "GET / HTTP/1.1\r\nHost: app\r\n\r\n"},
+		{server, client, 1, pcap.FlagACK, "HTTP/1.1 204 No Content\r\n\r\n"},
+	} {
+		if err := w.WriteSegment(time.Now(), seg.src, seg.dst, seg.seq, seg.flags, []byte(seg.payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	testDir := filepath.Join(dir, "case")
+	var out bytes.Buffer
+	if err := run([]string{"pcap", "-port", "80", capture, testDir}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "imported 1 exchanges into " + testDir + "\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+	for _, name := range []string{"request0.data", "response0.data"} {
+		if _, err := os.Stat(filepath.Join(testDir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := run([]string{"pcap", capture}, &out); !errors.Is(err, errUsage) {
+		t.Errorf("got %v, want usage error", err)
+	}
+}
+
+func TestGenerateFromCurl(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "commands.sh")
+	commands := "# create an order\ncurl -X POST localhost/orders \\\n  -d item=book\n\ncurl localhost/orders\n"
+	if err := os.WriteFile(file, []byte(commands), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	testDir := filepath.Join(dir, "case")
+	var out bytes.Buffer
+	if err := run([]string{"curl", "-f", file, testDir}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "generated " + testDir + " with 2 requests\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+	b, err := os.ReadFile(filepath.Join(testDir, "request0.data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(b), "\r\n\r\nitem=book") {
+		t.Errorf("got request %q", b)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/daulet/replay"
+)
+
+func pact(args []string, stdout io.Writer) error {
+	flags := newFlagSet("pact", "http.record pact.json")
+	consumer := flags.String("consumer", "consumer", "name of the application that made the recording")
+	provider := flags.String("provider", "", "name of the recorded dependency, the record file name if empty")
+	keyEnv := flags.String("key-env", "", "environment variable to read the key to decrypt the recording with from")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return errUsage
+	}
+	record, out := flags.Arg(0), flags.Arg(1)
+	if *provider == "" {
+		*provider = strings.TrimSuffix(filepath.Base(record), filepath.Ext(record))
+	}
+	p, err := replay.ExportPact(record, *consumer, *provider, encryption(*keyEnv)...)
+	if err != nil {
+		return err
+	}
+	if err := replay.WritePact(out, p); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "exported %d interactions into %s\n", len(p.Interactions), out)
+	return nil
+}
+
+func verify(args []string, stdout io.Writer) error {
+	flags := newFlagSet("verify", "pact.json provider-addr")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return errUsage
+	}
+	p, err := replay.ReadPact(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := replay.VerifyPact(p, flags.Arg(1)); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "verified %d interactions of %s with %s\n", len(p.Interactions), p.Consumer.Name, p.Provider.Name)
+	return nil
+}
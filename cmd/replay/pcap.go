@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/daulet/replay"
+)
+
+func importPCAP(args []string, stdout io.Writer) error {
+	flags := newFlagSet("pcap", "capture.pcap testdir")
+	host := flags.String("host", "", "IP address of the server to import connections to, any if empty")
+	port := flags.Int("port", 0, "port of the server to import connections to, any if zero")
+	record := flags.Bool("record", false, "write a dependency recording file instead of a test case directory")
+	keyEnv := flags.String("key-env", "", "environment variable to read the key to encrypt recordings with from")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return errUsage
+	}
+	var opts []replay.Option
+	if *keyEnv != "" {
+		opts = append(opts, replay.WithEncryption(*keyEnv))
+	}
+	capture, out := flags.Arg(0), flags.Arg(1)
+	filter := replay.PCAPFilter{Host: *host, Port: *port}
+	imp := replay.ImportPCAP
+	if *record {
+		imp = replay.ImportPCAPRecording
+	}
+	n, err := imp(capture, filter, out, opts...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "imported %d exchanges into %s\n", n, out)
+	return nil
+}
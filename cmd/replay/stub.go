@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/daulet/replay"
+)
+
+func stub(args []string, stdout io.Writer) error {
+	flags := newFlagSet("stub", "http.record")
+	port := flags.Int("port", 8080, "port to listen on")
+	fallbackStatus := flags.Int("fallback-status", http.StatusNotFound, "status of responses to requests matching no interaction")
+	fallbackBody := flags.String("fallback-body", "", "body of responses to requests matching no interaction")
+	fallbackAddr := flags.String("fallback-addr", "", "address of the dependency to proxy requests matching no interaction to, instead of responding with -fallback-status")
+	reload := flags.Duration("reload", time.Second, "how often to check the record file for changes, 0 to never reload it")
+	keyEnv := flags.String("key-env", "", "environment variable to read the key to decrypt the recording with from")
+	verbose := flags.Bool("v", false, "log how every request is matched, not only requests that match nothing")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errUsage
+	}
+	opts := encryption(*keyEnv)
+	switch {
+	case *fallbackAddr != "":
+		opts = append(opts, replay.WithFallback(httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: *fallbackAddr})))
+	case *fallbackBody != "" || *fallbackStatus != http.StatusNotFound:
+		status, body := *fallbackStatus, *fallbackBody
+		opts = append(opts, replay.WithFallback(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			io.WriteString(w, body)
+		})))
+	}
+	if *reload <= 0 {
+		*reload = -1
+	}
+	opts = append(opts, replay.WithReloadInterval(*reload), replay.WithLogger(&textLogger{debug: *verbose, w: os.Stderr}))
+
+	srv, err := replay.NewStubServer(*port, flags.Arg(0), opts...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "serving %s on %s\n", flags.Arg(0), srv.Addr())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	<-interrupt
+	return srv.Close()
+}
@@ -0,0 +1,643 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WithOpenAPI validates requests and responses against specFile, an OpenAPI 3 document in YAML or JSON.
+// The runner validates requests it replays and responses of the application, the record/replay server
+// validates requests of the application and responses of the dependency, recorded or replayed.
+// Violations are reported as SchemaError, separately from response diffs.
+func WithOpenAPI(specFile string) Option {
+	return func(o *options) {
+		o.openAPI = specFile
+	}
+}
+
+// SchemaError lists requests and responses that violate the OpenAPI document, see WithOpenAPI.
+type SchemaError struct {
+	Violations []string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%d OpenAPI violations:\n  %s", len(e.Violations), strings.Join(e.Violations, "\n  "))
+}
+
+// schemaError returns SchemaError for violations, nil if there are none.
+func schemaError(violations []string) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	return &SchemaError{Violations: violations}
+}
+
+// contract validates HTTP messages against an OpenAPI document.
+type contract struct {
+	doc      map[string]any
+	basePath string
+	routes   []*contractRoute
+}
+
+// contractRoute is an operation of the document.
+type contractRoute struct {
+	method   string
+	path     string
+	segments []string
+	op       map[string]any
+	params   []map[string]any
+}
+
+func loadContract(specFile string) (*contract, error) {
+	raw, err := readOpenAPI(specFile)
+	if err != nil {
+		return nil, err
+	}
+	doc, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("OpenAPI document %q isn't an object", specFile)
+	}
+	c := &contract{doc: doc}
+	if servers, ok := doc["servers"].([]any); ok && len(servers) > 0 {
+		if server, ok := c.resolve(servers[0]).(map[string]any); ok {
+			if u, err := url.Parse(fmt.Sprint(server["url"])); err == nil {
+				c.basePath = strings.TrimSuffix(u.Path, "/")
+			}
+		}
+	}
+	paths, _ := doc["paths"].(map[string]any)
+	for _, path := range sortedKeys(paths) {
+		item, _ := c.resolve(paths[path]).(map[string]any)
+		for _, method := range openAPIMethods {
+			op, ok := c.resolve(item[method]).(map[string]any)
+			if !ok {
+				continue
+			}
+			c.routes = append(c.routes, &contractRoute{
+				method:   strings.ToUpper(method),
+				path:     path,
+				segments: strings.Split(strings.Trim(path, "/"), "/"),
+				op:       op,
+				params:   c.parameters(item["parameters"], op["parameters"]),
+			})
+		}
+	}
+	// literal segments take precedence over templated ones
+	sort.SliceStable(c.routes, func(i, j int) bool {
+		return templated(c.routes[i].segments) < templated(c.routes[j].segments)
+	})
+	return c, nil
+}
+
+func templated(segments []string) int {
+	n := 0
+	for _, s := range segments {
+		if strings.HasPrefix(s, "{") {
+			n++
+		}
+	}
+	return n
+}
+
+// parameters merges parameters of the path item with parameters of the operation, which override them.
+func (c *contract) parameters(common, own any) []map[string]any {
+	var params []map[string]any
+	seen := map[string]bool{}
+	for _, list := range []any{own, common} {
+		items, _ := c.resolve(list).([]any)
+		for _, item := range items {
+			p, ok := c.resolve(item).(map[string]any)
+			if !ok {
+				continue
+			}
+			key := fmt.Sprint(p["in"], " ", p["name"])
+			if !seen[key] {
+				seen[key] = true
+				params = append(params, p)
+			}
+		}
+	}
+	return params
+}
+
+// resolve follows local reference in v, if it's one.
+func (c *contract) resolve(v any) any {
+	for depth := 0; depth < 32; depth++ {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return v
+		}
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return v
+		}
+		if v, ok = lookupRefOK(c.doc, ref); !ok {
+			return nil
+		}
+	}
+	return nil
+}
+
+func lookupRefOK(root any, ref string) (any, bool) {
+	v, err := lookupRef(root, ref)
+	return v, err == nil
+}
+
+// match returns the route of the operation for method and path, and values of path parameters.
+func (c *contract) match(method, path string) (*contractRoute, map[string]string) {
+	path, ok := strings.CutPrefix(path, c.basePath)
+	if !ok {
+		return nil, nil
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, rt := range c.routes {
+		if rt.method != method || len(rt.segments) != len(segments) {
+			continue
+		}
+		values := map[string]string{}
+		for i, s := range rt.segments {
+			if name, ok := strings.CutPrefix(s, "{"); ok {
+				v, err := url.PathUnescape(segments[i])
+				if err != nil {
+					v = segments[i]
+				}
+				values[strings.TrimSuffix(name, "}")] = v
+				continue
+			}
+			if s != segments[i] {
+				values = nil
+				break
+			}
+		}
+		if values != nil {
+			return rt, values
+		}
+	}
+	return nil, nil
+}
+
+// checkRequests validates recorded requests, preserving their bodies. A nil contract accepts everything.
+func (c *contract) checkRequests(reqs []*http.Request) []string {
+	if c == nil {
+		return nil
+	}
+	var violations []string
+	for _, req := range reqs {
+		violations = append(violations, c.checkRequest(req)...)
+	}
+	return violations
+}
+
+// checkResponses validates responses to reqs, failed requests are skipped.
+func (c *contract) checkResponses(reqs []*http.Request, resps []*httpResponse) []string {
+	if c == nil {
+		return nil
+	}
+	var violations []string
+	for i, resp := range resps {
+		if resp.resp != nil {
+			violations = append(violations, c.checkResponse(reqs[i], resp.resp)...)
+		}
+	}
+	return violations
+}
+
+// checkRequest validates req, preserving its body.
+func (c *contract) checkRequest(req *http.Request) []string {
+	body, err := snapshotBody(&req.Body)
+	if err != nil {
+		return []string{fmt.Sprintf("%s %s: failed to read request body: %v", req.Method, req.URL.Path, err)}
+	}
+	return c.validateRequest(req.Method, req.URL, req.Header, body)
+}
+
+// checkResponse validates resp to req, preserving its body.
+func (c *contract) checkResponse(req *http.Request, resp *http.Response) []string {
+	decoded, err := decodeResponse(resp)
+	if err != nil {
+		return []string{fmt.Sprintf("%s %s: failed to decode response: %v", req.Method, req.URL.Path, err)}
+	}
+	body, err := snapshotBody(&decoded.Body)
+	if err != nil {
+		return []string{fmt.Sprintf("%s %s: failed to read response body: %v", req.Method, req.URL.Path, err)}
+	}
+	return c.validateResponse(req.Method, req.URL, resp.StatusCode, decoded.Header, body)
+}
+
+// validateRequest returns violations of request with header and body.
+func (c *contract) validateRequest(method string, u *url.URL, header http.Header, body []byte) []string {
+	call := fmt.Sprintf("%s %s", method, u.Path)
+	rt, pathValues := c.match(method, u.Path)
+	if rt == nil {
+		return []string{fmt.Sprintf("%s: no operation matches the request", call)}
+	}
+	var violations []string
+	report := func(format string, args ...any) {
+		violations = append(violations, call+": "+fmt.Sprintf(format, args...))
+	}
+	query := u.Query()
+	for _, p := range rt.params {
+		name, _ := p["name"].(string)
+		in, _ := p["in"].(string)
+		var values []string
+		switch in {
+		case "path":
+			if v, ok := pathValues[name]; ok {
+				values = []string{v}
+			}
+		case "query":
+			values = query[name]
+		case "header":
+			values = header.Values(name)
+		case "cookie":
+			if cookie, err := (&http.Request{Header: header}).Cookie(name); err == nil {
+				values = []string{cookie.Value}
+			}
+		}
+		if len(values) == 0 {
+			if required, _ := p["required"].(bool); required || in == "path" {
+				report("%s parameter %q is missing", in, name)
+			}
+			continue
+		}
+		for _, err := range c.validateParameter(p["schema"], values) {
+			report("%s parameter %q: %s", in, name, err)
+		}
+	}
+
+	rb, _ := c.resolve(rt.op["requestBody"]).(map[string]any)
+	if rb == nil {
+		return violations
+	}
+	if len(body) == 0 {
+		if required, _ := rb["required"].(bool); required {
+			report("request body is missing")
+		}
+		return violations
+	}
+	for _, err := range c.validateBody(rb["content"], header.Get("Content-Type"), body, "request") {
+		report("request %s", err)
+	}
+	return violations
+}
+
+// validateResponse returns violations of response to the request with status, header and body.
+func (c *contract) validateResponse(method string, u *url.URL, status int, header http.Header, body []byte) []string {
+	call := fmt.Sprintf("%s %s", method, u.Path)
+	rt, _ := c.match(method, u.Path)
+	if rt == nil {
+		// reported for the request
+		return nil
+	}
+	responses, _ := c.resolve(rt.op["responses"]).(map[string]any)
+	key, ok := documentedStatus(responses, status)
+	if !ok {
+		return []string{fmt.Sprintf("%s: response status %d isn't documented", call, status)}
+	}
+	r, _ := c.resolve(responses[key]).(map[string]any)
+	if r == nil || r["content"] == nil || len(body) == 0 {
+		return nil
+	}
+	var violations []string
+	for _, err := range c.validateBody(r["content"], header.Get("Content-Type"), body, "response") {
+		violations = append(violations, fmt.Sprintf("%s: response %d %s", call, status, err))
+	}
+	return violations
+}
+
+// documentedStatus returns key of responses that documents status: the code itself, its range, e.g. 4XX,
+// or default.
+func documentedStatus(responses map[string]any, status int) (string, bool) {
+	code := strconv.Itoa(status)
+	for _, key := range []string{code, code[:1] + "XX", "default"} {
+		if _, ok := responses[key]; ok {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// validateBody validates body of contentType against media types in content, only JSON bodies are validated
+// against the schema. Direction is either request or response, see readOnly and writeOnly.
+func (c *contract) validateBody(content any, contentType string, body []byte, direction string) []string {
+	media, _ := c.resolve(content).(map[string]any)
+	if len(media) == 0 {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+	spec, ok := media[mediaType]
+	if !ok {
+		if spec, ok = media[strings.SplitN(mediaType, "/", 2)[0]+"/*"]; !ok {
+			spec, ok = media["*/*"]
+		}
+	}
+	if !ok {
+		return []string{fmt.Sprintf("body: content type %q isn't documented", mediaType)}
+	}
+	if !isJSON(mediaType) {
+		return nil
+	}
+	m, _ := c.resolve(spec).(map[string]any)
+	if m == nil || m["schema"] == nil {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return []string{fmt.Sprintf("body isn't valid JSON: %v", err)}
+	}
+	var errs []string
+	c.validate(m["schema"], v, "$", direction, &errs, 0)
+	for i, err := range errs {
+		errs[i] = "body " + err
+	}
+	return errs
+}
+
+// validateParameter validates string values of a parameter against its schema.
+func (c *contract) validateParameter(schema any, values []string) []string {
+	s, _ := c.resolve(schema).(map[string]any)
+	if s == nil {
+		return nil
+	}
+	var v any
+	if schemaType(s) == "array" {
+		var items []any
+		for _, value := range values {
+			for _, item := range strings.Split(value, ",") {
+				items = append(items, parseParameter(c.resolve(s["items"]), item))
+			}
+		}
+		v = items
+	} else {
+		v = parseParameter(s, values[0])
+	}
+	var errs []string
+	c.validate(s, v, "$", "request", &errs, 0)
+	return errs
+}
+
+// parseParameter converts parameter value to the type of schema, leaving it a string if it doesn't parse.
+func parseParameter(schema any, value string) any {
+	s, _ := schema.(map[string]any)
+	switch schemaType(s) {
+	case "integer", "number":
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// schemaType returns the single non-null type of schema, empty if there is none.
+func schemaType(s map[string]any) string {
+	switch t := s["type"].(type) {
+	case string:
+		return t
+	case []any:
+		for _, item := range t {
+			if item != "null" {
+				return fmt.Sprint(item)
+			}
+		}
+	}
+	return ""
+}
+
+// validate collects violations of schema by JSON value v at path.
+func (c *contract) validate(schema, v any, path, direction string, errs *[]string, depth int) {
+	s, _ := c.resolve(schema).(map[string]any)
+	if s == nil || depth > 64 {
+		return
+	}
+	report := func(format string, args ...any) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+	}
+	for _, sub := range list(s["allOf"]) {
+		c.validate(sub, v, path, direction, errs, depth+1)
+	}
+	if anyOf := list(s["anyOf"]); len(anyOf) > 0 && c.matches(anyOf, v, direction, depth) == 0 {
+		report("doesn't match any schema of anyOf")
+	}
+	if oneOf := list(s["oneOf"]); len(oneOf) > 0 {
+		if n := c.matches(oneOf, v, direction, depth); n != 1 {
+			report("matches %d schemas of oneOf, want exactly 1", n)
+		}
+	}
+	if v == nil {
+		if nullable, _ := s["nullable"].(bool); nullable || s["type"] == nil || hasType(s, "null") {
+			return
+		}
+		report("expected %s, got null", s["type"])
+		return
+	}
+	if s["type"] != nil && !hasType(s, jsonType(v)) && !(jsonType(v) == "integer" && hasType(s, "number")) {
+		report("expected %s, got %s", formatType(s["type"]), jsonType(v))
+		return
+	}
+	if enum := list(s["enum"]); len(enum) > 0 {
+		got, _ := json.Marshal(v)
+		found := false
+		for _, e := range enum {
+			want, _ := json.Marshal(e)
+			found = found || string(got) == string(want)
+		}
+		if !found {
+			report("%s isn't one of enum values", got)
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		n := float64(len([]rune(v)))
+		if min, ok := number(s["minLength"]); ok && n < min {
+			report("length %v is less than minLength %v", n, min)
+		}
+		if max, ok := number(s["maxLength"]); ok && n > max {
+			report("length %v is greater than maxLength %v", n, max)
+		}
+		if pattern, ok := s["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+				report("%q doesn't match pattern %q", v, pattern)
+			}
+		}
+		if format, ok := s["format"].(string); ok && !validFormat(format, v) {
+			report("%q isn't a valid %s", v, format)
+		}
+	case float64:
+		if min, ok := number(s["minimum"]); ok {
+			if exclusive, _ := s["exclusiveMinimum"].(bool); v < min || exclusive && v == min {
+				report("%v is less than minimum %v", v, min)
+			}
+		}
+		if min, ok := number(s["exclusiveMinimum"]); ok && v <= min {
+			report("%v isn't greater than exclusiveMinimum %v", v, min)
+		}
+		if max, ok := number(s["maximum"]); ok {
+			if exclusive, _ := s["exclusiveMaximum"].(bool); v > max || exclusive && v == max {
+				report("%v is greater than maximum %v", v, max)
+			}
+		}
+		if max, ok := number(s["exclusiveMaximum"]); ok && v >= max {
+			report("%v isn't less than exclusiveMaximum %v", v, max)
+		}
+	case []any:
+		n := float64(len(v))
+		if min, ok := number(s["minItems"]); ok && n < min {
+			report("%v items are less than minItems %v", n, min)
+		}
+		if max, ok := number(s["maxItems"]); ok && n > max {
+			report("%v items are more than maxItems %v", n, max)
+		}
+		if s["items"] != nil {
+			for i, item := range v {
+				c.validate(s["items"], item, childPath(path, strconv.Itoa(i)), direction, errs, depth+1)
+			}
+		}
+	case map[string]any:
+		props, _ := c.resolve(s["properties"]).(map[string]any)
+		for _, name := range list(s["required"]) {
+			name := fmt.Sprint(name)
+			if _, ok := v[name]; ok {
+				continue
+			}
+			// read-only properties aren't sent in requests, write-only ones aren't returned
+			prop, _ := c.resolve(props[name]).(map[string]any)
+			if readOnly, _ := prop["readOnly"].(bool); readOnly && direction == "request" {
+				continue
+			}
+			if writeOnly, _ := prop["writeOnly"].(bool); writeOnly && direction == "response" {
+				continue
+			}
+			report("required property %q is missing", name)
+		}
+		for _, name := range sortedKeys(v) {
+			if prop, ok := props[name]; ok {
+				c.validate(prop, v[name], childPath(path, name), direction, errs, depth+1)
+				continue
+			}
+			switch additional := s["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					report("property %q isn't allowed", name)
+				}
+			case map[string]any:
+				c.validate(additional, v[name], childPath(path, name), direction, errs, depth+1)
+			}
+		}
+	}
+}
+
+// matches returns the number of schemas v is valid against.
+func (c *contract) matches(schemas []any, v any, direction string, depth int) int {
+	n := 0
+	for _, sub := range schemas {
+		var errs []string
+		c.validate(sub, v, "$", direction, &errs, depth+1)
+		if len(errs) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func childPath(path, key string) string {
+	if path == "$" {
+		return key
+	}
+	return path + "." + key
+}
+
+func list(v any) []any {
+	l, _ := v.([]any)
+	return l
+}
+
+func hasType(s map[string]any, t string) bool {
+	switch want := s["type"].(type) {
+	case string:
+		return want == t
+	case []any:
+		for _, item := range want {
+			if item == t || t == "integer" && item == "number" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func formatType(t any) string {
+	if l, ok := t.([]any); ok {
+		types := make([]string, len(l))
+		for i, item := range l {
+			types[i] = fmt.Sprint(item)
+		}
+		return strings.Join(types, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+// jsonType returns JSON schema type of decoded JSON value.
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func number(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// validFormat checks well-known string formats, other formats are accepted as is.
+func validFormat(format, v string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, v)
+		return err == nil
+	case "date":
+		_, err := time.Parse("2006-01-02", v)
+		return err == nil
+	case "uuid":
+		return uuidPattern.MatchString(v)
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package replay_test
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/daulet/replay"
+)
+
+const widgetsSpec = `openapi: 3.0.3
+paths:
+  /widgets/{id}:
+    get:
+      parameters:
+        - {name: id, in: path, required: true, schema: {type: integer}}
+        - {name: verbose, in: query, schema: {type: boolean}}
+      responses:
+        200:
+          description: widget
+          content:
+            application/json:
+              schema: {$ref: '#/components/schemas/Widget'}
+        404:
+          description: not found
+components:
+  schemas:
+    Widget:
+      type: object
+      required: [id, name, color]
+      additionalProperties: false
+      properties:
+        id: {type: integer, readOnly: true}
+        name: {type: string, minLength: 1}
+        color: {type: string, enum: [red, green]}
+`
+
+func serveWidgets(t *testing.T) string {
+	return serveHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/widgets/1":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id": 1, "name": "gear", "color": "red"}`))
+		case "/widgets/2":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id": 2.5, "name": "", "size": 3}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+}
+
+func TestOpenAPIRunner(t *testing.T) {
+	dir := t.TempDir()
+	spec := filepath.Join(dir, "openapi.yaml")
+	if err := os.WriteFile(spec, []byte(widgetsSpec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	appAddr := serveWidgets(t)
+	testDir := filepath.Join(dir, "case")
+	if err := os.Mkdir(testDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	recordTestCase(t, appAddr, testDir, func(addr string) {
+		for _, path := range []string{"/widgets/1?verbose=true", "/widgets/2?verbose=maybe", "/widgets/x", "/gadgets"} {
+			resp, err := http.Get("http://" + addr + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+		}
+	})
+
+	runner, err := replay.NewHTTPRunner(0, appAddr, testDir, replay.WithOpenAPI(spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runner.Replay(false)
+	var schemaErr *replay.SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("got %v, want schema error", err)
+	}
+	want := []string{
+		`GET /widgets/2: query parameter "verbose": $: expected boolean, got string`,
+		`GET /widgets/x: path parameter "id": $: expected integer, got string`,
+		`GET /gadgets: no operation matches the request`,
+		`GET /widgets/2: response 200 body $: required property "color" is missing`,
+		`GET /widgets/2: response 200 body id: expected integer, got number`,
+		`GET /widgets/2: response 200 body name: length 0 is less than minLength 1`,
+		`GET /widgets/2: response 200 body $: property "size" isn't allowed`,
+		`GET /widgets/x: response status 500 isn't documented`,
+	}
+	if !reflect.DeepEqual(schemaErr.Violations, want) {
+		t.Errorf("got violations:\n%q\nwant:\n%q", schemaErr.Violations, want)
+	}
+}
+
+func TestOpenAPIServer(t *testing.T) {
+	dir := t.TempDir()
+	spec := filepath.Join(dir, "openapi.yaml")
+	if err := os.WriteFile(spec, []byte(widgetsSpec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := replay.NewHTTPServer(0, true, serveWidgets(t), filepath.Join(dir, "http.record"), replay.WithOpenAPI(spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	getAll(t, srv, "/widgets/1")
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err = replay.NewHTTPServer(0, true, serveWidgets(t), filepath.Join(dir, "http.record"), replay.WithOpenAPI(spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	getAll(t, srv, "/widgets/2")
+	err = srv.Close()
+	var schemaErr *replay.SchemaError
+	if !errors.As(err, &schemaErr) || len(schemaErr.Violations) != 4 {
+		t.Errorf("got %v, want 4 violations", err)
+	}
+}
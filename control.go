@@ -0,0 +1,181 @@
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ControlPrefix is the path prefix reserved for runner control endpoints,
+// unless they are served on a separate listener, see WithControlListener:
+//
+//	POST /_replay/stop      stop recording, Serve returns
+//	GET  /_replay/status    current test case and number of recorded requests
+//	GET  /_replay/requests  number of recorded requests
+//	POST /_replay/testcase  start recording a new test case, named by "name" query parameter
+//	POST /_replay/discard   discard the last recorded request and its response
+const ControlPrefix = "/_replay/"
+
+// WithControlListener serves runner control endpoints on provided listener,
+// so no path of the recorded application is reserved.
+func WithControlListener(l net.Listener) Option {
+	return func(o *options) {
+		o.controlListener = l
+	}
+}
+
+// RunnerStatus describes the test case runner is currently recording.
+type RunnerStatus struct {
+	TestCase string `json:"test_case"`
+	Dir      string `json:"dir"`
+	Requests int    `json:"requests"`
+}
+
+var (
+	errNothingToDiscard = errors.New("no recorded requests to discard")
+	errTestCaseExists   = errors.New("test case already has recordings")
+)
+
+func (h *httpRunner) controlHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(ControlPrefix+"stop", post(func(w http.ResponseWriter, r *http.Request) {
+		h.Stop()
+		writeJSON(w, http.StatusOK, h.Status())
+	}))
+	mux.HandleFunc(ControlPrefix+"status", get(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, h.Status())
+	}))
+	mux.HandleFunc(ControlPrefix+"requests", get(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]int{"requests": h.RequestCount()})
+	}))
+	mux.HandleFunc(ControlPrefix+"testcase", post(func(w http.ResponseWriter, r *http.Request) {
+		err := h.NewTestCase(r.URL.Query().Get("name"))
+		switch {
+		case errors.Is(err, errTestCaseExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			writeJSON(w, http.StatusOK, h.Status())
+		}
+	}))
+	mux.HandleFunc(ControlPrefix+"discard", post(func(w http.ResponseWriter, r *http.Request) {
+		err := h.DiscardLast()
+		switch {
+		case errors.Is(err, errNothingToDiscard):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			writeJSON(w, http.StatusOK, h.Status())
+		}
+	}))
+	return mux
+}
+
+func get(f http.HandlerFunc) http.HandlerFunc {
+	return allow(http.MethodGet, f)
+}
+
+func post(f http.HandlerFunc) http.HandlerFunc {
+	return allow(http.MethodPost, f)
+}
+
+func allow(method string, f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			http.Error(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		f(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// Stop stops recording, Serve returns once all in-flight requests are recorded.
+// It is safe to call Stop multiple times.
+func (h *httpRunner) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.done)
+	})
+}
+
+// Status returns the test case being recorded.
+func (h *httpRunner) Status() RunnerStatus {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	return RunnerStatus{
+		TestCase: filepath.Base(h.writeDir),
+		Dir:      h.writeDir,
+		Requests: len(h.exchanges),
+	}
+}
+
+// RequestCount returns number of requests recorded in current test case.
+func (h *httpRunner) RequestCount() int {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	return len(h.exchanges)
+}
+
+// NewTestCase continues recording into a new test case, a sibling directory of the current one.
+func (h *httpRunner) NewTestCase(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid test case name %q", name)
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	dir := filepath.Join(filepath.Dir(h.writeDir), name)
+	if _, err := os.Stat(filepath.Join(dir, "request0.data")); err == nil {
+		return fmt.Errorf("%w: %q", errTestCaseExists, dir)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create test case directory: [%w]", err)
+	}
+	if err := clearRecording(dir); err != nil {
+		return err
+	}
+	h.writeDir = dir
+	h.exchanges = nil
+	h.clearPending = false
+	h.logger.Info("recording new test case", "dir", dir)
+	return nil
+}
+
+// DiscardLast removes the last recorded request and its response from current test case. If the response
+// is still in flight, it isn't recorded when it arrives, and the next request takes the discarded index.
+func (h *httpRunner) DiscardLast() error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if len(h.exchanges) == 0 {
+		return errNothingToDiscard
+	}
+	ex := h.exchanges[len(h.exchanges)-1]
+	id := ex.index
+	for _, name := range []string{
+		fmt.Sprintf("request%v.data", id),
+		fmt.Sprintf("response%v.data", id),
+		fmt.Sprintf("response%v.err", id),
+		// actual response of a previous replay doesn't belong to the exchange recorded next at this index
+		fmt.Sprintf("response%v.actual", id),
+	} {
+		if err := os.Remove(filepath.Join(h.writeDir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to discard %q: [%w]", name, err)
+		}
+	}
+	ex.discarded = true
+	h.exchanges = h.exchanges[:len(h.exchanges)-1]
+	h.logger.Info("discarded last exchange", "dir", h.writeDir, "index", id)
+	return nil
+}
@@ -0,0 +1,220 @@
+package replay_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daulet/replay"
+)
+
+func TestControl(t *testing.T) {
+	// application with its own /stop route, which must be recorded like any other
+	appAddr := serveHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "app %s", r.URL.Path)
+	}))
+
+	ctrlLstr, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts []replay.Option
+	}{
+		{name: "prefix"},
+		{name: "listener", opts: []replay.Option{replay.WithControlListener(ctrlLstr)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testdataDir := t.TempDir()
+			runner, err := replay.NewHTTPRunner(0, appAddr, filepath.Join(testdataDir, "first"), test.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.MkdirAll(filepath.Join(testdataDir, "first"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := runner.Serve(); err != nil {
+					t.Error(err)
+				}
+			}()
+			<-runner.Ready()
+
+			control := func(method, path string) replay.RunnerStatus {
+				t.Helper()
+				req, err := http.NewRequest(method, fmt.Sprintf("http://%s%s%s", runner.ControlAddr(), replay.ControlPrefix, path), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					b, _ := io.ReadAll(resp.Body)
+					t.Fatalf("%s %s: %s: %s", method, path, resp.Status, b)
+				}
+				var status replay.RunnerStatus
+				if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+					t.Fatal(err)
+				}
+				return status
+			}
+			send := func(path string) {
+				t.Helper()
+				resp, err := http.Get(fmt.Sprintf("http://%s%s", runner.Addr(), path))
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+
+			send("/stop")
+			send("/foo")
+			// left by a previous replay of the test case
+			actual := filepath.Join(testdataDir, "first", "response1.actual")
+			if err := os.WriteFile(actual, []byte("HTTP/1.1 200 OK\r\n\r\nstale"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if got := control(http.MethodGet, "status"); got.TestCase != "first" || got.Requests != 2 {
+				t.Errorf("got status %+v, want 2 requests in %q", got, "first")
+			}
+			if got := control(http.MethodPost, "discard"); got.Requests != 1 {
+				t.Errorf("got %d requests after discard, want 1", got.Requests)
+			}
+			for _, name := range []string{"request1.data", "response1.data", "response1.actual"} {
+				if _, err := os.Stat(filepath.Join(testdataDir, "first", name)); !os.IsNotExist(err) {
+					t.Errorf("discarded %s is still on disk: %v", name, err)
+				}
+			}
+			b, err := os.ReadFile(filepath.Join(testdataDir, "first", "response0.data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "app /stop"; !strings.HasSuffix(string(b), want) {
+				t.Errorf("got response %q, want it to end with %q", b, want)
+			}
+
+			if got := control(http.MethodPost, "testcase?name=second"); got.TestCase != "second" || got.Requests != 0 {
+				t.Errorf("got status %+v, want empty %q", got, "second")
+			}
+			send("/bar")
+			if got := runner.RequestCount(); got != 1 {
+				t.Errorf("got %d requests, want 1", got)
+			}
+			if _, err := os.Stat(filepath.Join(testdataDir, "second", "request0.data")); err != nil {
+				t.Error(err)
+			}
+
+			control(http.MethodPost, "stop")
+			wg.Wait()
+		})
+	}
+}
+
+func TestControlErrors(t *testing.T) {
+	testdataDir := t.TempDir()
+	runner, err := replay.NewHTTPRunner(0, "localhost:1234", filepath.Join(testdataDir, "first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.DiscardLast(); err == nil {
+		t.Error("expected error discarding from empty test case")
+	}
+	for _, name := range []string{"", "..", "a/b"} {
+		if err := runner.NewTestCase(name); err == nil {
+			t.Errorf("expected error for test case name %q", name)
+		}
+	}
+	runner.Stop()
+	runner.Stop()
+	if err := runner.Serve(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestControlDiscardInFlight(t *testing.T) {
+	release := make(chan struct{})
+	appAddr := serveHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		fmt.Fprintf(w, "app %s", r.URL.Path)
+	}))
+	testDir := t.TempDir()
+	runner, err := replay.NewHTTPRunner(0, appAddr, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := runner.Serve(); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-runner.Ready()
+	send := func(path string) error {
+		resp, err := http.Get(fmt.Sprintf("http://%s%s", runner.Addr(), path))
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	slow := make(chan error, 1)
+	go func() {
+		slow <- send("/slow")
+	}()
+	for runner.RequestCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// discard the request while its response is in flight, the next request takes its index
+	if err := runner.DiscardLast(); err != nil {
+		t.Fatal(err)
+	}
+	if err := send("/fast"); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+	runner.Stop()
+	wg.Wait()
+
+	if got := runner.RequestCount(); got != 1 {
+		t.Errorf("got %d requests, want 1", got)
+	}
+	for name, want := range map[string]string{"request0.data": "GET /fast", "response0.data": "app /fast"} {
+		b, err := os.ReadFile(filepath.Join(testDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), want) {
+			t.Errorf("got %s %q, want it to contain %q", name, b, want)
+		}
+	}
+	for _, name := range []string{"request1.data", "response1.data"} {
+		if _, err := os.Stat(filepath.Join(testDir, name)); !os.IsNotExist(err) {
+			t.Errorf("got %s of discarded exchange: %v", name, err)
+		}
+	}
+}
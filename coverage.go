@@ -0,0 +1,175 @@
+package replay
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// CoverageReport maps requests of runner test cases to operations of an OpenAPI document.
+type CoverageReport struct {
+	Operations []*OperationCoverage
+	// Unmatched lists requests that match no operation, as "<test case>: <method> <path>".
+	Unmatched []string
+}
+
+// OperationCoverage describes how test cases exercise an operation.
+type OperationCoverage struct {
+	Method string
+	// Path is the path template of the operation, e.g. /orders/{id}.
+	Path        string
+	OperationID string
+	// TestCases are directories of test cases sending requests to the operation.
+	TestCases []string
+	Requests  int
+	// MissingStatuses are documented response statuses, e.g. 404, 4XX or default, no recorded response has.
+	MissingStatuses []string
+	// MissingParameters are documented query, header and cookie parameters no request sends, e.g. "query limit".
+	MissingParameters []string
+}
+
+// Covered reports whether any test case sends a request to the operation.
+func (op *OperationCoverage) Covered() bool {
+	return op.Requests > 0
+}
+
+// Covered returns number of operations exercised by test cases.
+func (r *CoverageReport) Covered() int {
+	n := 0
+	for _, op := range r.Operations {
+		if op.Covered() {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *CoverageReport) String() string {
+	var b strings.Builder
+	for _, op := range r.Operations {
+		if !op.Covered() {
+			fmt.Fprintf(&b, "%s %s: not exercised\n", op.Method, op.Path)
+			continue
+		}
+		fmt.Fprintf(&b, "%s %s: %d requests in %d test cases\n", op.Method, op.Path, op.Requests, len(op.TestCases))
+		if len(op.MissingStatuses) > 0 {
+			fmt.Fprintf(&b, "  statuses never exercised: %s\n", strings.Join(op.MissingStatuses, ", "))
+		}
+		if len(op.MissingParameters) > 0 {
+			fmt.Fprintf(&b, "  parameters never exercised: %s\n", strings.Join(op.MissingParameters, ", "))
+		}
+	}
+	for _, call := range r.Unmatched {
+		fmt.Fprintf(&b, "no operation matches %s\n", call)
+	}
+	fmt.Fprintf(&b, "%d/%d operations covered\n", r.Covered(), len(r.Operations))
+	return b.String()
+}
+
+// Coverage maps requests of runner test cases found in testDirs, searched recursively, to operations of
+// specFile, an OpenAPI 3 document in YAML or JSON, and reports operations, response statuses and parameters
+// never exercised. Only recorded responses count towards statuses.
+func Coverage(specFile string, testDirs []string, opts ...Option) (*CoverageReport, error) {
+	c, err := loadContract(specFile)
+	if err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
+	store, err := newFileStore(o)
+	if err != nil {
+		return nil, err
+	}
+	type usage struct {
+		op       *OperationCoverage
+		statuses map[string]bool
+		params   map[string]bool
+	}
+	report := &CoverageReport{}
+	usages := map[*contractRoute]*usage{}
+	for _, rt := range c.routes {
+		op := &OperationCoverage{Method: rt.method, Path: rt.path}
+		op.OperationID, _ = rt.op["operationId"].(string)
+		report.Operations = append(report.Operations, op)
+		usages[rt] = &usage{op: op, statuses: map[string]bool{}, params: map[string]bool{}}
+	}
+
+	dirs, err := FindTestCases(testDirs)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		h := &httpRunner{writeDir: dir, store: store, logger: o.logger}
+		reqs, resps, err := h.load(true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load test case %q: [%w]", dir, err)
+		}
+		for i, req := range reqs {
+			rt, _ := c.match(req.Method, req.URL.Path)
+			if rt == nil {
+				report.Unmatched = append(report.Unmatched, fmt.Sprintf("%s: %s %s", dir, req.Method, req.URL.Path))
+				continue
+			}
+			u := usages[rt]
+			u.op.Requests++
+			if n := len(u.op.TestCases); n == 0 || u.op.TestCases[n-1] != dir {
+				u.op.TestCases = append(u.op.TestCases, dir)
+			}
+			for _, p := range rt.params {
+				if sendsParameter(req, p) {
+					u.params[parameterKey(p)] = true
+				}
+			}
+			if resp := resps[i]; resp != nil && resp.resp != nil {
+				responses, _ := c.resolve(rt.op["responses"]).(map[string]any)
+				if key, ok := documentedStatus(responses, resp.resp.StatusCode); ok {
+					u.statuses[key] = true
+				}
+			}
+		}
+	}
+
+	for _, rt := range c.routes {
+		u := usages[rt]
+		responses, _ := c.resolve(rt.op["responses"]).(map[string]any)
+		for _, key := range sortedKeys(responses) {
+			if !u.statuses[key] {
+				u.op.MissingStatuses = append(u.op.MissingStatuses, key)
+			}
+		}
+		for _, p := range rt.params {
+			// path parameters are sent by every request to the operation
+			if p["in"] != "path" && !u.params[parameterKey(p)] {
+				u.op.MissingParameters = append(u.op.MissingParameters, parameterKey(p))
+			}
+		}
+	}
+	sort.Slice(report.Operations, func(i, j int) bool {
+		a, b := report.Operations[i], report.Operations[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Method < b.Method
+	})
+	return report, nil
+}
+
+// parameterKey identifies parameter p of an operation, e.g. "query limit".
+func parameterKey(p map[string]any) string {
+	return fmt.Sprint(p["in"], " ", p["name"])
+}
+
+// sendsParameter reports whether req has a value of query, header or cookie parameter p.
+func sendsParameter(req *http.Request, p map[string]any) bool {
+	name, _ := p["name"].(string)
+	switch p["in"] {
+	case "query":
+		return req.URL.Query().Has(name)
+	case "header":
+		return len(req.Header.Values(name)) > 0
+	case "cookie":
+		_, err := req.Cookie(name)
+		return err == nil
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package replay_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/daulet/replay"
+)
+
+const shopSpec = `openapi: 3.0.3
+paths:
+  /widgets:
+    get:
+      operationId: listWidgets
+      parameters:
+        - {name: limit, in: query, schema: {type: integer}}
+        - {name: X-Tenant, in: header, schema: {type: string}}
+      responses:
+        200: {description: widgets}
+    post:
+      operationId: createWidget
+      responses:
+        201: {description: created}
+  /widgets/{id}:
+    get:
+      operationId: getWidget
+      parameters:
+        - {name: id, in: path, required: true, schema: {type: integer}}
+      responses:
+        200: {description: widget}
+        404: {description: not found}
+        5XX: {description: failure}
+`
+
+func TestCoverage(t *testing.T) {
+	dir := t.TempDir()
+	spec := filepath.Join(dir, "openapi.yaml")
+	if err := os.WriteFile(spec, []byte(shopSpec), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	appAddr := serveWidgets(t)
+	cases := map[string][]string{
+		"list":  {"/widgets?limit=1"},
+		"fetch": {"/widgets/1", "/widgets/x", "/gadgets"},
+	}
+	for name, paths := range cases {
+		testDir := filepath.Join(dir, "testdata", name)
+		if err := os.MkdirAll(testDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		recordTestCase(t, appAddr, testDir, func(addr string) {
+			for _, path := range paths {
+				resp, err := http.Get("http://" + addr + path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+		})
+	}
+
+	report, err := replay.Coverage(spec, []string{filepath.Join(dir, "testdata")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, fetch := filepath.Join(dir, "testdata", "list"), filepath.Join(dir, "testdata", "fetch")
+	want := &replay.CoverageReport{
+		Operations: []*replay.OperationCoverage{
+			{
+				Method:            "GET",
+				Path:              "/widgets",
+				OperationID:       "listWidgets",
+				TestCases:         []string{list},
+				Requests:          1,
+				MissingStatuses:   []string{"200"},
+				MissingParameters: []string{"header X-Tenant"},
+			},
+			{
+				Method:          "POST",
+				Path:            "/widgets",
+				OperationID:     "createWidget",
+				MissingStatuses: []string{"201"},
+			},
+			{
+				Method:          "GET",
+				Path:            "/widgets/{id}",
+				OperationID:     "getWidget",
+				TestCases:       []string{fetch},
+				Requests:        2,
+				MissingStatuses: []string{"404"},
+			},
+		},
+		Unmatched: []string{fetch + ": GET /gadgets"},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("got report:\n%v\nwant:\n%v", report, want)
+	}
+	if got := report.Covered(); got != 2 {
+		t.Errorf("got %d covered operations, want 2", got)
+	}
+}
@@ -0,0 +1,123 @@
+package replay
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultKeyEnv is the environment variable encryption key is read from, unless another one is
+// passed to WithEncryption. Encrypted recordings found without encryption configured are decrypted
+// with this key.
+const DefaultKeyEnv = "REPLAY_KEY"
+
+// encryptedHeader starts every encrypted file, followed by base64 encoded nonce and ciphertext.
+const encryptedHeader = "replay-encrypted v1\n"
+
+var errNoKey = errors.New("recording is encrypted, but no key is set")
+
+// WithEncryption encrypts recordings at rest with AES-256-GCM key read from environment variable keyEnv,
+// DefaultKeyEnv if empty. The key is 32 bytes encoded as hex or base64, see GenerateKey.
+// Encrypted recordings are transparently decrypted on replay.
+func WithEncryption(keyEnv string) Option {
+	return func(o *options) {
+		if keyEnv == "" {
+			keyEnv = DefaultKeyEnv
+		}
+		o.keyEnv = keyEnv
+	}
+}
+
+// GenerateKey returns a new random hex encoded key.
+func GenerateKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate key: [%w]", err)
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// ParseKey decodes 32 byte key encoded as hex or base64.
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := hex.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.New("key must be 32 bytes encoded as hex or base64")
+}
+
+// KeyFromEnv reads and parses key from environment variable env.
+func KeyFromEnv(env string) ([]byte, error) {
+	s, ok := os.LookupEnv(env)
+	if !ok || s == "" {
+		return nil, fmt.Errorf("environment variable %s is not set", env)
+	}
+	key, err := ParseKey(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key in %s: [%w]", env, err)
+	}
+	return key, nil
+}
+
+// IsEncrypted reports whether data is an encrypted recording.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedHeader))
+}
+
+// Encrypt encrypts data with key, see ParseKey.
+func Encrypt(data, key []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: [%w]", err)
+	}
+	sealed := aead.Seal(nonce, nonce, data, []byte(encryptedHeader))
+	out := []byte(encryptedHeader)
+	out = append(out, base64.StdEncoding.EncodeToString(sealed)...)
+	return append(out, '\n'), nil
+}
+
+// Decrypt decrypts data encrypted with Encrypt.
+func Decrypt(data, key []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, errors.New("data is not encrypted")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data[len(encryptedHeader):])))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode encrypted data: [%w]", err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted data is truncated")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, []byte(encryptedHeader))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt, wrong key? [%w]", err)
+	}
+	return plain, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: [%w]", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,152 @@
+package replay_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/daulet/replay"
+)
+
+func TestEncryption(t *testing.T) {
+	key, err := replay.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_REPLAY_KEY", key)
+
+	testDir := t.TempDir()
+	appAddr := serve(t)
+
+	// dependency proxy in front of the application, to record both at once
+	recordFile := filepath.Join(testDir, "http.record")
+	srv, err := replay.NewHTTPServer(0, true, appAddr, recordFile, replay.WithEncryption("TEST_REPLAY_KEY"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner, err := replay.NewHTTPRunner(0, srv.Addr(), testDir, replay.WithEncryption("TEST_REPLAY_KEY"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := runner.Serve(); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-runner.Ready()
+	resp, err := http.Get(fmt.Sprintf("http://%s/foo", runner.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	runner.Stop()
+	wg.Wait()
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"request0.data", "response0.data", "http.record"} {
+		b, err := os.ReadFile(filepath.Join(testDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !replay.IsEncrypted(b) {
+			t.Errorf("%s is not encrypted:\n%s", name, b)
+		}
+	}
+
+	// replay decrypts with the key from default environment variable
+	t.Setenv(replay.DefaultKeyEnv, key)
+	srv, err = replay.NewHTTPServer(0, false, "localhost:1234", recordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.Get(fmt.Sprintf("http://%s/foo", srv.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "foo" {
+		t.Errorf("got %q, want %q", body, "foo")
+	}
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	runner, err = replay.NewHTTPRunner(0, appAddr, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Replay(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// wrong key
+	other, err := replay.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(replay.DefaultKeyEnv, other)
+	if err := runner.Replay(false); err == nil {
+		t.Error("expected error replaying with wrong key")
+	}
+}
+
+func TestEncryptionTestCaseFile(t *testing.T) {
+	key, err := replay.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_REPLAY_KEY", key)
+
+	testDir := t.TempDir()
+	appAddr := serveNondeterministic(t, "widget")
+	recordTestCase(t, appAddr, testDir, func(addr string) {
+		resp, err := http.Get("http://" + addr + "/widget")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	})
+	runner, err := replay.NewHTTPRunner(0, appAddr, testDir, replay.WithEncryption("TEST_REPLAY_KEY"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runner.DetectNondeterminism(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// test case is written in plain text, so it doesn't depend on the key, e.g. after rotation
+	b, err := os.ReadFile(filepath.Join(testDir, replay.TestCaseFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replay.IsEncrypted(b) {
+		t.Errorf("%s is encrypted", replay.TestCaseFile)
+	}
+	runner, err = replay.NewHTTPRunner(0, appAddr, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Replay(false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptionMissingKey(t *testing.T) {
+	if _, err := replay.NewHTTPRunner(0, "localhost:1234", t.TempDir(), replay.WithEncryption("TEST_REPLAY_MISSING_KEY")); err == nil {
+		t.Error("expected error for missing key")
+	}
+	t.Setenv("TEST_REPLAY_BAD_KEY", "too short")
+	if _, err := replay.NewHTTPServer(0, true, "localhost:1234", filepath.Join(t.TempDir(), "http.record"), replay.WithEncryption("TEST_REPLAY_BAD_KEY")); err == nil {
+		t.Error("expected error for invalid key")
+	}
+}
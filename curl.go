@@ -0,0 +1,318 @@
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// curlArgFlags are curl flags taking an argument, by short and long name.
+var curlArgFlags = map[string]string{
+	"X": "request", "H": "header", "d": "data", "u": "user", "A": "user-agent", "b": "cookie",
+	"e": "referer", "F": "form", "o": "output", "m": "max-time",
+	"request": "request", "header": "header", "data": "data", "data-raw": "data-raw",
+	"data-binary": "data-binary", "data-ascii": "data", "data-urlencode": "data-urlencode", "json": "json",
+	"user": "user", "user-agent": "user-agent", "cookie": "cookie", "referer": "referer", "url": "url",
+	"form": "form", "output": "output", "max-time": "max-time", "connect-timeout": "max-time",
+}
+
+// curlBoolFlags are curl flags without an argument that affect the request, by short and long name,
+// other known flags only affect how curl reports the response.
+var curlBoolFlags = map[string]string{
+	"G": "get", "I": "head", "get": "get", "head": "head",
+	"s": "", "S": "", "v": "", "k": "", "i": "", "L": "", "g": "", "f": "", "N": "",
+	"silent": "", "show-error": "", "verbose": "", "insecure": "", "include": "", "location": "",
+	"globoff": "", "fail": "", "no-buffer": "", "compressed": "compressed",
+}
+
+// curlAcceptEncoding is Accept-Encoding curl sends with --compressed.
+const curlAcceptEncoding = "deflate, gzip, br"
+
+// ParseCurl parses curl command line into the request it would send. Arguments are split as a POSIX shell does,
+// lines can be continued with a backslash. Flags that only affect output, e.g. -s or -L, are ignored,
+// unsupported flags are an error.
+func ParseCurl(command string) (*http.Request, error) {
+	args, err := splitShell(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 || args[0] != "curl" {
+		return nil, errors.New("not a curl command")
+	}
+	var (
+		method, rawURL string
+		header         = make(http.Header)
+		data           []string
+		form           []string
+		json, get      bool
+		compressed     bool
+	)
+	set := func(name, value string) error {
+		switch name {
+		case "request":
+			method = value
+		case "header":
+			k, v, ok := strings.Cut(value, ":")
+			if !ok {
+				return fmt.Errorf("invalid header %q", value)
+			}
+			header.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+		case "data", "data-binary":
+			if f, ok := strings.CutPrefix(value, "@"); ok {
+				b, err := os.ReadFile(f)
+				if err != nil {
+					return err
+				}
+				value = string(b)
+				if name == "data" {
+					value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+				}
+			}
+			data = append(data, value)
+		case "data-raw":
+			data = append(data, value)
+		case "data-urlencode":
+			if strings.ContainsRune(value, '@') && !strings.ContainsRune(value, '=') {
+				return fmt.Errorf("file content in --data-urlencode %q is not supported", value)
+			}
+			if k, v, ok := strings.Cut(value, "="); ok {
+				if k == "" {
+					value = url.QueryEscape(v)
+				} else {
+					value = k + "=" + url.QueryEscape(v)
+				}
+			} else {
+				value = url.QueryEscape(value)
+			}
+			data = append(data, value)
+		case "json":
+			json = true
+			data = append(data, value)
+		case "user":
+			user, pass, _ := strings.Cut(value, ":")
+			req := http.Request{Header: make(http.Header)}
+			req.SetBasicAuth(user, pass)
+			header.Set("Authorization", req.Header.Get("Authorization"))
+		case "user-agent":
+			header.Set("User-Agent", value)
+		case "cookie":
+			if !strings.ContainsRune(value, '=') {
+				return fmt.Errorf("cookie file %q is not supported", value)
+			}
+			header.Add("Cookie", value)
+		case "referer":
+			header.Set("Referer", value)
+		case "form":
+			form = append(form, value)
+		case "url":
+			rawURL = value
+		case "get":
+			get = true
+		case "head":
+			method = http.MethodHead
+		case "compressed":
+			compressed = true
+		}
+		return nil
+	}
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		var names []string
+		var value *string
+		switch {
+		case strings.HasPrefix(arg, "--"):
+			names = []string{arg[2:]}
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			// short flags can be combined, the last one can take an argument, e.g. -sSXPOST
+			for j := 1; j < len(arg); j++ {
+				name := arg[j : j+1]
+				names = append(names, name)
+				if _, ok := curlArgFlags[name]; ok && j+1 < len(arg) {
+					rest := arg[j+1:]
+					value = &rest
+					break
+				}
+			}
+		default:
+			if rawURL != "" {
+				return nil, fmt.Errorf("unexpected argument %q, only one URL is supported", arg)
+			}
+			rawURL = arg
+			continue
+		}
+		for k, name := range names {
+			if long, ok := curlBoolFlags[name]; ok {
+				if err := set(long, ""); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			long, ok := curlArgFlags[name]
+			if !ok {
+				return nil, fmt.Errorf("unsupported curl flag %q", arg)
+			}
+			if k != len(names)-1 {
+				return nil, fmt.Errorf("flag -%s in %q requires an argument", name, arg)
+			}
+			if value == nil {
+				if i+1 >= len(args) {
+					return nil, fmt.Errorf("flag %q requires an argument", arg)
+				}
+				i++
+				value = &args[i]
+			}
+			if err := set(long, *value); err != nil {
+				return nil, fmt.Errorf("flag %q: %w", arg, err)
+			}
+		}
+	}
+	if rawURL == "" {
+		return nil, errors.New("URL is missing")
+	}
+	// a header set with -H takes precedence, as it does in curl
+	if compressed && header.Get("Accept-Encoding") == "" {
+		header.Set("Accept-Encoding", curlAcceptEncoding)
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: [%w]", err)
+	}
+
+	var body io.Reader
+	switch {
+	case len(form) > 0 && len(data) > 0:
+		return nil, errors.New("form and data can't be combined")
+	case len(form) > 0:
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		for _, field := range form {
+			k, v, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid form field %q", field)
+			}
+			if strings.HasPrefix(v, "@") || strings.HasPrefix(v, "<") {
+				return nil, fmt.Errorf("file upload in form field %q is not supported", field)
+			}
+			if err := w.WriteField(k, v); err != nil {
+				return nil, err
+			}
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		body = &b
+		header.Set("Content-Type", w.FormDataContentType())
+		if method == "" {
+			method = http.MethodPost
+		}
+	case len(data) > 0 && get:
+		query := strings.Join(data, "&")
+		if u.RawQuery != "" {
+			query = u.RawQuery + "&" + query
+		}
+		u.RawQuery = query
+	case len(data) > 0:
+		sep := "&"
+		if json {
+			sep = ""
+		}
+		body = strings.NewReader(strings.Join(data, sep))
+		contentType := "application/x-www-form-urlencoded"
+		if json {
+			contentType = "application/json"
+			if header.Get("Accept") == "" {
+				header.Set("Accept", "application/json")
+			}
+		}
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", contentType)
+		}
+		if method == "" {
+			method = http.MethodPost
+		}
+	}
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	if host := header.Get("Host"); host != "" {
+		req.Host = host
+		header.Del("Host")
+	}
+	return req, nil
+}
+
+// splitShell splits command line into arguments as a POSIX shell does, without expansions.
+func splitShell(s string) ([]string, error) {
+	var (
+		args    []string
+		arg     strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+			// backslash newline continues the line
+			if r == '\n' {
+				continue
+			}
+			if quote == '"' && !strings.ContainsRune("\\\"$`", r) {
+				arg.WriteRune('\\')
+			}
+			arg.WriteRune(r)
+			inArg = true
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+				continue
+			}
+			arg.WriteRune(r)
+		case r == '\\':
+			escaped = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+				continue
+			}
+			arg.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		return nil, errors.New("trailing backslash")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
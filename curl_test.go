@@ -0,0 +1,130 @@
+package replay_test
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/daulet/replay"
+)
+
+func TestParseCurl(t *testing.T) {
+	for _, tc := range []struct {
+		command string
+		method  string
+		url     string
+		header  http.Header
+		body    string
+	}{
+		{
+			command: "curl example.com/health",
+			method:  http.MethodGet,
+			url:     "http://example.com/health",
+			header:  http.Header{},
+		},
+		{
+			command: `curl -sS -XPOST 'https://api.example.com/orders?dry_run=1' \
+  -H 'Content-Type: application/json' \
+  -H "X-Request-Id: \"abc\"" \
+  --data-raw '{"item": "book"}'`,
+			method: http.MethodPost,
+			url:    "https://api.example.com/orders?dry_run=1",
+			header: http.Header{"Content-Type": {"application/json"}, "X-Request-Id": {`"abc"`}},
+			body:   `{"item": "book"}`,
+		},
+		{
+			command: "curl -d name=alice -d 'tag=a b' --data-urlencode 'note=x&y' http://localhost:8080/users",
+			method:  http.MethodPost,
+			url:     "http://localhost:8080/users",
+			header:  http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+			body:    "name=alice&tag=a b&note=x%26y",
+		},
+		{
+			command: "curl -G -d q=go -d page=2 -u bob:secret http://localhost/search",
+			method:  http.MethodGet,
+			url:     "http://localhost/search?q=go&page=2",
+			header:  http.Header{"Authorization": {"Basic Ym9iOnNlY3JldA=="}},
+		},
+		{
+			command: `curl --json '{"a":1}' -I http://localhost/x`,
+			method:  http.MethodHead,
+			url:     "http://localhost/x",
+			header:  http.Header{"Content-Type": {"application/json"}, "Accept": {"application/json"}},
+			body:    `{"a":1}`,
+		},
+		{
+			command: "curl --compressed -s http://localhost/x",
+			method:  http.MethodGet,
+			url:     "http://localhost/x",
+			header:  http.Header{"Accept-Encoding": {"deflate, gzip, br"}},
+		},
+		{
+			command: "curl --compressed -H 'Accept-Encoding: gzip' http://localhost/x",
+			method:  http.MethodGet,
+			url:     "http://localhost/x",
+			header:  http.Header{"Accept-Encoding": {"gzip"}},
+		},
+	} {
+		req, err := replay.ParseCurl(tc.command)
+		if err != nil {
+			t.Errorf("%s: %v", tc.command, err)
+			continue
+		}
+		var body []byte
+		if req.Body != nil {
+			body, _ = io.ReadAll(req.Body)
+		}
+		if req.Method != tc.method || req.URL.String() != tc.url || !reflect.DeepEqual(req.Header, tc.header) || string(body) != tc.body {
+			t.Errorf("%s:\ngot  %s %s %v %q\nwant %s %s %v %q", tc.command, req.Method, req.URL, req.Header, body, tc.method, tc.url, tc.header, tc.body)
+		}
+	}
+
+	for command, want := range map[string]string{
+		"wget example.com":                    "not a curl command",
+		"curl -H":                             "requires an argument",
+		"curl --upload-file x example.com":    "unsupported curl flag",
+		"curl 'example.com":                   "unterminated",
+		"curl -F file=@photo.jpg example.com": "not supported",
+	} {
+		if _, err := replay.ParseCurl(command); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want error containing %q", command, err, want)
+		}
+	}
+}
+
+func TestGenerateFromCurl(t *testing.T) {
+	testDir := filepath.Join(t.TempDir(), "generated")
+	appAddr := serveOrders(t)
+	err := replay.GenerateFromCurl([]string{
+		`curl -X POST http://localhost/orders -H 'Content-Type: application/json' -d '{"Item":"book"}'`,
+		`curl http://localhost/orders`,
+	}, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"request0.data", "request1.data"} {
+		if _, err := os.Stat(filepath.Join(testDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runner, err := replay.NewHTTPRunner(0, appAddr, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Replay(false); err == nil {
+		t.Fatal("expected error for missing responses")
+	}
+	if err := runner.Replay(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(testDir, "response1.data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := replay.GenerateFromCurl([]string{"curl localhost"}, testDir); err == nil {
+		t.Error("expected error for existing test case")
+	}
+}
@@ -0,0 +1,215 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/daulet/replay/internal/atomicfile"
+)
+
+// nondeterministicPlaceholder replaces normalized values before responses are compared.
+const nondeterministicPlaceholder = "{{nondeterministic}}"
+
+// Normalization marks a nondeterministic value of a response, which is ignored when comparing responses.
+// Exactly one of Header and JSONPath is set.
+type Normalization struct {
+	// Response is the index of the response.
+	Response int `json:"response"`
+	// Header of the response.
+	Header string `json:"header,omitempty"`
+	// JSONPath into response body, "$" for the whole body.
+	JSONPath string `json:"jsonPath,omitempty"`
+}
+
+// normalize replaces values of response dump matching normalizations of i-th response with a placeholder.
+func (tc *TestCase) normalize(i int, rawResp []byte) ([]byte, error) {
+	var rules []Normalization
+	for _, n := range tc.Normalize {
+		if n.Response == i {
+			rules = append(rules, n)
+		}
+	}
+	if len(rules) == 0 {
+		return rawResp, nil
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(rawResp)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: [%w]", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: [%w]", err)
+	}
+	v, isJSON := decodeJSON(body)
+	var replaced bool
+	for _, n := range rules {
+		if n.Header != "" && resp.Header.Get(n.Header) != "" {
+			resp.Header.Set(n.Header, nondeterministicPlaceholder)
+		}
+		if n.JSONPath != "" && isJSON {
+			var ok bool
+			v, ok = parseJSONPath(n.JSONPath).replace(v, func(any) any { return nondeterministicPlaceholder })
+			replaced = replaced || ok
+		}
+	}
+	if replaced {
+		body = encodeJSON(v, body)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	setContentLength(resp.Header, len(body))
+	return httputil.DumpResponse(resp, true)
+}
+
+// DetectNondeterminism replays the test case twice and returns normalizations for headers and JSON fields
+// of responses that differ between the runs, excluding values already captured or normalized.
+// If write is set, they are added to the TestCaseFile, so following replays ignore them.
+func (h *httpRunner) DetectNondeterminism(write bool) ([]Normalization, error) {
+	tc, err := readTestCase(h.store, h.writeDir)
+	if err != nil {
+		return nil, err
+	}
+	type result struct {
+		rawResp  []byte
+		captured captures
+	}
+	var runs [2][]*result
+	for run := range runs {
+		reqs, wantResps, err := h.load(false)
+		if err != nil {
+			return nil, err
+		}
+		resps, captured, err := h.send(h.remoteAddr, tc, reqs, wantResps)
+		if err != nil {
+			return nil, err
+		}
+		for i, resp := range resps {
+			if resp.err != nil {
+				runs[run] = append(runs[run], nil)
+				continue
+			}
+			rawResp, err := h.dumpResult(resp)
+			if err != nil {
+				return nil, err
+			}
+			if rawResp, err = tc.normalize(i, rawResp); err != nil {
+				return nil, err
+			}
+			runs[run] = append(runs[run], &result{rawResp, captured})
+		}
+	}
+	var found []Normalization
+	for i := range runs[0] {
+		if i >= len(runs[1]) || runs[0][i] == nil || runs[1][i] == nil {
+			continue
+		}
+		a, b := runs[0][i], runs[1][i]
+		// captured values differ from run to run by design
+		found = append(found, diffResponses(i, a.rawResp, b.rawResp, a.captured, b.captured)...)
+	}
+	if write && len(found) > 0 {
+		tc.Normalize = append(tc.Normalize, found...)
+		if err := writeTestCase(h.writeDir, tc); err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// diffResponses returns normalizations for headers and JSON fields that differ between response dumps a and b,
+// modulo their captured values.
+func diffResponses(i int, a, b []byte, capturedA, capturedB captures) []Normalization {
+	_, ha, bodyA, err := splitResponse(a)
+	if err != nil {
+		return nil
+	}
+	_, hb, bodyB, err := splitResponse(b)
+	if err != nil {
+		return nil
+	}
+	masks := liveMasks(capturedA, capturedB)
+	bodyA, bodyB = masks.bodies(bodyA, bodyB)
+	var found []Normalization
+	var headers []string
+	for k := range ha {
+		if k == "Content-Length" || hb[k] == nil {
+			continue
+		}
+		if va, vb := masks.values(strings.Join(ha[k], " "), strings.Join(hb[k], " ")); va != vb {
+			headers = append(headers, k)
+		}
+	}
+	sort.Strings(headers)
+	for _, k := range headers {
+		found = append(found, Normalization{Response: i, Header: k})
+	}
+	va, okA := decodeJSON(bodyA)
+	vb, okB := decodeJSON(bodyB)
+	if !okA || !okB {
+		return found
+	}
+	var paths []string
+	diffJSON(va, vb, nil, &paths)
+	for _, p := range paths {
+		found = append(found, Normalization{Response: i, JSONPath: p})
+	}
+	return found
+}
+
+// diffJSON collects paths of values that differ between a and b. Arrays of different length differ as a whole.
+func diffJSON(a, b any, path jsonPath, paths *[]string) {
+	switch a := a.(type) {
+	case map[string]any:
+		if b, ok := b.(map[string]any); ok {
+			var keys []string
+			for k := range a {
+				// skip keys missing in b and keys that can't be addressed by a path
+				if _, ok := b[k]; ok && !strings.ContainsAny(k, ".*") {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				diffJSON(a[k], b[k], append(path[:len(path):len(path)], k), paths)
+			}
+			return
+		}
+	case []any:
+		if b, ok := b.([]any); ok && len(a) == len(b) {
+			for i := range a {
+				diffJSON(a[i], b[i], append(path[:len(path):len(path)], strconv.Itoa(i)), paths)
+			}
+			return
+		}
+	}
+	if reflect.DeepEqual(a, b) {
+		return
+	}
+	if len(path) == 0 {
+		*paths = append(*paths, "$")
+		return
+	}
+	*paths = append(*paths, path.String())
+}
+
+func writeTestCase(dir string, tc *TestCase) error {
+	b, err := json.MarshalIndent(tc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode test case: [%w]", err)
+	}
+	// test case is edited by hand and holds no recorded data, so it's never encrypted
+	if err := atomicfile.WriteFile(filepath.Join(dir, TestCaseFile), append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write test case: [%w]", err)
+	}
+	return nil
+}
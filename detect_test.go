@@ -0,0 +1,135 @@
+package replay_test
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/daulet/replay"
+)
+
+func randomID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// serveNondeterministic starts an application responding with random IDs next to stable values.
+func serveNondeterministic(t *testing.T, name string) string {
+	return serveHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-Id", randomID())
+		json.NewEncoder(w).Encode(map[string]any{
+			"name":       name,
+			"request_id": randomID(),
+			"items":      []map[string]string{{"id": "1", "etag": randomID()}},
+		})
+	}))
+}
+
+func recordTestCase(t *testing.T, appAddr, testDir string, send func(addr string)) {
+	t.Helper()
+	runner, err := replay.NewHTTPRunner(0, appAddr, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := runner.Serve(); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-runner.Ready()
+	send(runner.Addr())
+	runner.Stop()
+	wg.Wait()
+}
+
+func TestDetectNondeterminism(t *testing.T) {
+	testDir := t.TempDir()
+	appAddr := serveNondeterministic(t, "widget")
+	recordTestCase(t, appAddr, testDir, func(addr string) {
+		resp, err := http.Get("http://" + addr + "/widget")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	})
+
+	runner, err := replay.NewHTTPRunner(0, appAddr, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Replay(false); err == nil {
+		t.Fatal("expected nondeterministic responses to differ")
+	}
+	found, err := runner.DetectNondeterminism(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []replay.Normalization{
+		{Response: 0, Header: "X-Request-Id"},
+		{Response: 0, JSONPath: "items.0.etag"},
+		{Response: 0, JSONPath: "request_id"},
+	}
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("got %+v, want %+v", found, want)
+	}
+	if err := runner.Replay(false); err != nil {
+		t.Fatal(err)
+	}
+	// already normalized fields aren't reported again
+	found, err = runner.DetectNondeterminism(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 0 {
+		t.Errorf("got %+v, want none", found)
+	}
+
+	// stable fields are still compared
+	runner, err = replay.NewHTTPRunner(0, serveNondeterministic(t, "gadget"), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Replay(false); err == nil || !strings.Contains(err.Error(), `"ga"`) {
+		t.Errorf("got %v, want diff of stable field", err)
+	}
+}
+
+func TestDetectNondeterminismCaptures(t *testing.T) {
+	testDir := t.TempDir()
+	appAddr := serveOrders(t)
+	recordTestCase(t, appAddr, testDir, func(addr string) {
+		resp, err := http.Post("http://"+addr+"/orders", "application/json", strings.NewReader(`{"Item":"book"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	})
+	tc := `{"captures":[{"name":"order_id","response":0,"jsonPath":"id"}]}`
+	if err := os.WriteFile(filepath.Join(testDir, replay.TestCaseFile), []byte(tc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	runner, err := replay.NewHTTPRunner(0, appAddr, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := runner.DetectNondeterminism(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// captured order ID differs between runs by design
+	if len(found) != 0 {
+		t.Errorf("got %+v, want none", found)
+	}
+}
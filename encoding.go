@@ -0,0 +1,234 @@
+package replay
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// EncodingHeader replaces Content-Encoding header in recordings, which store bodies decoded,
+// so recordings are readable and compression differences don't show up in diffs.
+// Bodies are encoded again on replay if the client accepts the encoding.
+const EncodingHeader = "X-Replay-Content-Encoding"
+
+// contentEncodings lists encodings of header h in the order they were applied.
+func contentEncodings(h http.Header) []string {
+	var encs []string
+	for _, v := range h.Values("Content-Encoding") {
+		for _, enc := range strings.Split(v, ",") {
+			enc = strings.ToLower(strings.TrimSpace(enc))
+			if enc != "" && enc != "identity" {
+				encs = append(encs, enc)
+			}
+		}
+	}
+	return encs
+}
+
+// decodeContent decodes body encoded according to Content-Encoding of h. It returns a copy of h
+// with Content-Encoding replaced by EncodingHeader, or h itself if body isn't encoded
+// or the encoding is not supported, in which case body is kept as is.
+func decodeContent(h http.Header, body []byte) (http.Header, []byte, error) {
+	encs := contentEncodings(h)
+	if len(encs) == 0 || body == nil {
+		return h, body, nil
+	}
+	for _, enc := range encs {
+		if !supportedEncoding(enc) {
+			return h, body, nil
+		}
+	}
+	for i := len(encs) - 1; i >= 0; i-- {
+		var err error
+		body, err = decode(encs[i], body)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	h = h.Clone()
+	h.Del("Content-Encoding")
+	h.Set(EncodingHeader, strings.Join(encs, ", "))
+	setContentLength(h, len(body))
+	return h, body, nil
+}
+
+// encodeContent reverses decodeContent, if acceptEncoding of the client allows it. It returns a copy of h
+// without EncodingHeader, or h itself if body isn't decoded.
+func encodeContent(h http.Header, body []byte, acceptEncoding string) (http.Header, []byte, error) {
+	if h.Get(EncodingHeader) == "" {
+		return h, body, nil
+	}
+	h = h.Clone()
+	encs := strings.Split(h.Get(EncodingHeader), ",")
+	h.Del(EncodingHeader)
+	for i := range encs {
+		encs[i] = strings.TrimSpace(encs[i])
+		if !acceptsEncoding(acceptEncoding, encs[i]) {
+			setContentLength(h, len(body))
+			return h, body, nil
+		}
+	}
+	for _, enc := range encs {
+		var err error
+		body, err = encode(enc, body)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	h.Set("Content-Encoding", strings.Join(encs, ", "))
+	setContentLength(h, len(body))
+	return h, body, nil
+}
+
+// acceptsEncoding reports whether Accept-Encoding header value allows encoding enc.
+func acceptsEncoding(acceptEncoding, enc string) bool {
+	for _, v := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(v, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != enc && name != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if w, err := strconv.ParseFloat(q, 64); err == nil && w == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func supportedEncoding(enc string) bool {
+	switch enc {
+	case "gzip", "x-gzip", "deflate", "br":
+		return true
+	}
+	return false
+}
+
+func decode(enc string, body []byte) ([]byte, error) {
+	var r io.Reader
+	switch enc {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s body: [%w]", enc, err)
+		}
+		defer zr.Close()
+		r = zr
+	case "deflate":
+		// HTTP deflate is zlib-wrapped, though some servers send raw deflate data
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			fr := flate.NewReader(bytes.NewReader(body))
+			defer fr.Close()
+			r = fr
+			break
+		}
+		defer zr.Close()
+		r = zr
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", enc)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s body: [%w]", enc, err)
+	}
+	return b, nil
+}
+
+func encode(enc string, body []byte) ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		w   io.WriteCloser
+	)
+	switch enc {
+	case "gzip", "x-gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", enc)
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, fmt.Errorf("failed to encode %s body: [%w]", enc, err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode %s body: [%w]", enc, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeRequest returns a shallow copy of r with body decoded, see decodeContent. Body of r is preserved.
+func decodeRequest(r *http.Request) (*http.Request, error) {
+	if len(contentEncodings(r.Header)) == 0 {
+		return r, nil
+	}
+	body, err := snapshotBody(&r.Body)
+	if err != nil {
+		return nil, err
+	}
+	h, body, err := decodeContent(r.Header, body)
+	if err != nil || body == nil {
+		return r, err
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Header = h
+	r2.Body = io.NopCloser(bytes.NewReader(body))
+	r2.ContentLength = int64(len(body))
+	return r2, nil
+}
+
+// decodeResponse returns a shallow copy of resp with body decoded, see decodeContent. Body of resp is preserved.
+func decodeResponse(resp *http.Response) (*http.Response, error) {
+	if len(contentEncodings(resp.Header)) == 0 {
+		return resp, nil
+	}
+	body, err := snapshotBody(&resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	h, body, err := decodeContent(resp.Header, body)
+	if err != nil || body == nil {
+		return resp, err
+	}
+	resp2 := new(http.Response)
+	*resp2 = *resp
+	resp2.Header = h
+	resp2.Body = io.NopCloser(bytes.NewReader(body))
+	resp2.ContentLength = int64(len(body))
+	resp2.Uncompressed = false
+	return resp2, nil
+}
+
+// encodeRequest restores encoding of request body decoded by decodeRequest.
+func encodeRequest(r *http.Request) error {
+	if r.Header.Get(EncodingHeader) == "" {
+		return nil
+	}
+	body, err := snapshotBody(&r.Body)
+	if err != nil {
+		return err
+	}
+	h, body, err := encodeContent(r.Header, body, "*")
+	if err != nil {
+		return err
+	}
+	r.Header = h
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	return nil
+}
@@ -0,0 +1,254 @@
+package replay_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/daulet/replay"
+)
+
+const encodedBody = `{"message":"hello, compressed world"}`
+
+// serveEncoded starts an application that compresses responses with encoding named by the request path,
+// with different compression level on every call to simulate recompression differences.
+func serveEncoded(t *testing.T) string {
+	var (
+		mux   sync.Mutex
+		calls int
+	)
+	return serveHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		calls++
+		level := calls%9 + 1
+		mux.Unlock()
+
+		enc := strings.TrimPrefix(r.URL.Path, "/")
+		var (
+			buf bytes.Buffer
+			zw  io.WriteCloser
+		)
+		switch enc {
+		case "gzip":
+			zw, _ = gzip.NewWriterLevel(&buf, level)
+		case "deflate":
+			zw, _ = zlib.NewWriterLevel(&buf, level)
+		case "raw-deflate":
+			// some servers send raw deflate data as deflate
+			enc = "deflate"
+			zw, _ = flate.NewWriter(&buf, level)
+		case "br":
+			zw = brotli.NewWriterLevel(&buf, level)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		zw.Write([]byte(encodedBody))
+		zw.Close()
+		w.Header().Set("Content-Encoding", enc)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(buf.Bytes())
+	}))
+}
+
+func getEncoded(t *testing.T, addr, enc string, accept bool) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/%s", addr, enc), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accept {
+		req.Header.Set("Accept-Encoding", enc)
+	}
+	// don't let transport decode response, so encoding is observable
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestEncodingRunner(t *testing.T) {
+	appAddr := serveEncoded(t)
+
+	for _, enc := range []string{"gzip", "deflate", "br"} {
+		t.Run(enc, func(t *testing.T) {
+			testDir := t.TempDir()
+			runner, err := replay.NewHTTPRunner(0, appAddr, testDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := runner.Serve(); err != nil {
+					t.Error(err)
+				}
+			}()
+			<-runner.Ready()
+			resp, _ := getEncoded(t, runner.Addr(), enc, true)
+			runner.Stop()
+			wg.Wait()
+			if got := resp.Header.Get("Content-Encoding"); got != enc {
+				t.Errorf("application got Content-Encoding %q, want %q", got, enc)
+			}
+
+			b, err := os.ReadFile(filepath.Join(testDir, "response0.data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Contains(b, []byte(encodedBody)) {
+				t.Errorf("response isn't stored decoded:\n%s", b)
+			}
+			if !bytes.Contains(b, []byte(replay.EncodingHeader+": "+enc)) {
+				t.Errorf("response doesn't note original encoding:\n%s", b)
+			}
+
+			// compressed differently on replay, but decoded content is the same
+			if err := runner.Replay(false); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestEncodingRawDeflate(t *testing.T) {
+	testDir := t.TempDir()
+	runner, err := replay.NewHTTPRunner(0, serveEncoded(t), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := runner.Serve(); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-runner.Ready()
+	resp, _ := getEncoded(t, runner.Addr(), "raw-deflate", false)
+	runner.Stop()
+	wg.Wait()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	b, err := os.ReadFile(filepath.Join(testDir, "response0.data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(encodedBody)) {
+		t.Errorf("response isn't stored decoded:\n%s", b)
+	}
+}
+
+func TestEncodingCorruptBody(t *testing.T) {
+	appAddr := serveHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		w.Write([]byte("not brotli"))
+	}))
+	testDir := t.TempDir()
+	runner, err := replay.NewHTTPRunner(0, appAddr, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := runner.Serve(); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-runner.Ready()
+	for _, path := range []string{"/foo", "/bar"} {
+		resp, body := getEncoded(t, runner.Addr(), path[1:], false)
+		if resp.StatusCode != http.StatusOK || body != "not brotli" {
+			t.Errorf("got %d %q, want body passed through", resp.StatusCode, body)
+		}
+	}
+	runner.Stop()
+	wg.Wait()
+
+	// body is stored as received, and exchanges keep their indices
+	for i := 0; i < 2; i++ {
+		b, err := os.ReadFile(filepath.Join(testDir, fmt.Sprintf("response%d.data", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(b, []byte("not brotli")) {
+			t.Errorf("response%d isn't stored as received:\n%s", i, b)
+		}
+	}
+	for _, name := range []string{"response0.err", "response1.err", "response2.err", "response2.data"} {
+		if _, err := os.Stat(filepath.Join(testDir, name)); err == nil {
+			t.Errorf("unexpected %s", name)
+		}
+	}
+}
+
+func TestEncodingHTTPServer(t *testing.T) {
+	remoteAddr := serveEncoded(t)
+
+	for _, enc := range []string{"gzip", "deflate", "br"} {
+		t.Run(enc, func(t *testing.T) {
+			recordFile := filepath.Join(t.TempDir(), "http.record")
+			srv, err := replay.NewHTTPServer(0, true, remoteAddr, recordFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			getEncoded(t, srv.Addr(), enc, true)
+			getEncoded(t, srv.Addr(), enc, false)
+			if err := srv.Close(); err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(recordFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Contains(b, []byte(replay.EncodingHeader)) {
+				t.Errorf("record doesn't note original encoding:\n%s", b)
+			}
+
+			srv, err = replay.NewHTTPServer(0, false, remoteAddr, recordFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer srv.Close()
+
+			// client accepts encoding, response is encoded again
+			resp, body := getEncoded(t, srv.Addr(), enc, true)
+			if got := resp.Header.Get("Content-Encoding"); got != enc {
+				t.Errorf("got Content-Encoding %q, want %q", got, enc)
+			}
+			if body == encodedBody {
+				t.Errorf("got decoded body, want it encoded with %s", enc)
+			}
+			// client doesn't accept encoding, response is served decoded
+			resp, body = getEncoded(t, srv.Addr(), enc, false)
+			if got := resp.Header.Get("Content-Encoding"); got != "" {
+				t.Errorf("got Content-Encoding %q, want none", got)
+			}
+			if body != encodedBody {
+				t.Errorf("got body %q, want %q", body, encodedBody)
+			}
+		})
+	}
+}
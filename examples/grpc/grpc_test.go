@@ -2,11 +2,9 @@ package grpc_test
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"net"
 	"sync"
-	"syscall"
 	"testing"
 	"time"
 
@@ -14,7 +12,6 @@ import (
 	pb "examples/grpc/helloworld"
 
 	"github.com/stretchr/testify/require"
-	"golang.org/x/sys/unix"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -22,20 +19,12 @@ import (
 const TEST_TIMEOUT = 2 * time.Second
 
 func TestHelloWorld(t *testing.T) {
-	const port = 50051
-
 	var wg sync.WaitGroup
 	ctx, cancel := context.WithTimeout(context.Background(), TEST_TIMEOUT)
 	defer cancel()
 
-	lcfg := &net.ListenConfig{
-		Control: func(network, address string, c syscall.RawConn) error {
-			return c.Control(func(fd uintptr) {
-				unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
-			})
-		},
-	}
-	lis, err := lcfg.Listen(ctx, "tcp", fmt.Sprintf(":%d", port))
+	// ephemeral port, so the test doesn't collide with itself when run with -count
+	lis, err := net.Listen("tcp", "localhost:0")
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
@@ -58,7 +47,7 @@ func TestHelloWorld(t *testing.T) {
 
 	// act & assert
 	{
-		conn, err := grpc.NewClient(fmt.Sprintf("localhost:%d", port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
 		if err != nil {
 			log.Fatalf("did not connect: %v", err)
 		}
@@ -4,14 +4,23 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
 )
 
 func ServeApplication(ctx context.Context, port int, depAddr string) error {
+	lstr, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	return ServeApplicationListener(ctx, lstr, depAddr)
+}
+
+// ServeApplicationListener serves the application on lstr until ctx is done, e.g. to listen on an ephemeral port.
+func ServeApplicationListener(ctx context.Context, lstr net.Listener, depAddr string) error {
 	srv := &http.Server{
-		Addr: fmt.Sprintf(":%d", port),
 		Handler: &application{
 			dependencyAddr: depAddr,
 		},
@@ -22,7 +31,7 @@ func ServeApplication(ctx context.Context, port int, depAddr string) error {
 		srv.Shutdown(context.Background())
 	}()
 
-	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+	if err := srv.Serve(lstr); err != http.ErrServerClosed {
 		return err
 	}
 	return nil
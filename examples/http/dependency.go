@@ -4,13 +4,22 @@ import (
 	"context"
 	"fmt"
 	"html"
+	"net"
 	"net/http"
 )
 
 func ServeDependency(ctx context.Context, port int) error {
+	lstr, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	return ServeDependencyListener(ctx, lstr)
+}
+
+// ServeDependencyListener serves the dependency on lstr until ctx is done, e.g. to listen on an ephemeral port.
+func ServeDependencyListener(ctx context.Context, lstr net.Listener) error {
 	mux := http.NewServeMux()
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
 		Handler: mux,
 	}
 
@@ -27,7 +36,7 @@ func ServeDependency(ctx context.Context, port int) error {
 		srv.Shutdown(context.Background())
 	}()
 
-	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+	if err := srv.Serve(lstr); err != http.ErrServerClosed {
 		return err
 	}
 	return nil
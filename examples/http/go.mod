@@ -5,9 +5,9 @@ go 1.22.2
 require github.com/daulet/replay v0.0.0-20240706121105-21f96937e5de
 
 require (
+	github.com/andybalholm/brotli v1.1.0 // indirect
 	github.com/google/go-cmp v0.6.0 // indirect
-	github.com/google/go-replayers/httpreplay v1.2.0 // indirect
-	github.com/google/martian/v3 v3.3.2 // indirect
-	golang.org/x/net v0.6.0 // indirect
-	golang.org/x/text v0.8.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace github.com/daulet/replay => ../..
@@ -0,0 +1,51 @@
+package replay
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// GenerateFromCurl writes a runner test case into testDir sending requests of curl commands in order,
+// see ParseCurl. Responses are populated by Replay in update mode.
+func GenerateFromCurl(commands []string, testDir string, opts ...Option) error {
+	reqs := make([]*http.Request, len(commands))
+	for i, command := range commands {
+		req, err := ParseCurl(command)
+		if err != nil {
+			return fmt.Errorf("%d-th curl command: [%w]", i, err)
+		}
+		reqs[i] = req
+	}
+	return writeGenerated(testDir, reqs, newOptions(opts))
+}
+
+// writeGenerated writes reqs as requests of a new test case in dir, without responses.
+func writeGenerated(dir string, reqs []*http.Request, o *options) error {
+	if _, err := os.Stat(filepath.Join(dir, "request0.data")); err == nil {
+		return fmt.Errorf("%w: %q", errTestCaseExists, dir)
+	}
+	store, err := newFileStore(o)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create test case directory: [%w]", err)
+	}
+	if err := clearRecording(dir); err != nil {
+		return err
+	}
+	h := &httpRunner{writeDir: dir, redact: newRedactor(o.redaction), store: store, logger: o.logger}
+	for i, req := range reqs {
+		// client requests carry the length outside of headers, unlike recorded ones
+		if req.ContentLength > 0 {
+			req.Header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+		}
+		if err := h.writeRequest(dir, i, req); err != nil {
+			return fmt.Errorf("failed to write request file: [%w]", err)
+		}
+	}
+	return nil
+}
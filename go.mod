@@ -3,12 +3,10 @@ module github.com/daulet/replay
 go 1.20
 
 require (
+	github.com/andybalholm/brotli v1.1.0
 	github.com/google/go-cmp v0.6.0
-	github.com/google/go-replayers/httpreplay v1.2.0
+	golang.org/x/term v0.25.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	github.com/google/martian/v3 v3.3.2 // indirect
-	golang.org/x/net v0.6.0 // indirect
-	golang.org/x/text v0.8.0 // indirect
-)
+require golang.org/x/sys v0.26.0 // indirect
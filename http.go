@@ -2,23 +2,29 @@ package replay
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"sync"
-
-	"github.com/google/go-replayers/httpreplay"
+	"time"
 )
 
 var _ io.Closer = (*HTTPServer)(nil)
 
 type HTTPServer struct {
+	// internal control
+	ready chan struct{}
+
 	// internal state
-	wg  *sync.WaitGroup
-	srv *http.Server
-	r   recorderOrReplayer
+	wg      *sync.WaitGroup
+	lstr    net.Listener
+	srv     *http.Server
+	handler *httpHandler
+	r       recorderOrReplayer
 }
 
 type recorderOrReplayer interface {
@@ -26,10 +32,20 @@ type recorderOrReplayer interface {
 	Client() *http.Client
 }
 
+// NewHTTPServer starts a server that proxies incoming requests to remoteAddr and records interactions
+// into recordFile if record is set, otherwise replays interactions from recordFile. See WithRecordMode
+// for other modes.
+// Pass port 0 to listen on an ephemeral port, see Addr.
 // TODO strongly typed params for URL and Path
 // TODO perhaps Serving part should be separate from the constructor
-func NewHTTPServer(port int, record bool, remoteAddr string, recordFile string) (*HTTPServer, error) {
-	{
+func NewHTTPServer(port int, record bool, remoteAddr string, recordFile string, opts ...Option) (*HTTPServer, error) {
+	o := newOptions(opts)
+	store, err := newFileStore(o)
+	if err != nil {
+		return nil, err
+	}
+	mode := resolveRecordMode(o, record, recordFile)
+	if routedModes(o, mode) {
 		f, err := os.OpenFile(recordFile, os.O_CREATE|os.O_WRONLY, 0644)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create record file: [%w]", err)
@@ -37,44 +53,98 @@ func NewHTTPServer(port int, record bool, remoteAddr string, recordFile string)
 		f.Close()
 	}
 
-	var (
-		r   recorderOrReplayer
-		err error
-	)
-	r, err = httpreplay.NewReplayer(recordFile)
-	if record {
-		r, err = httpreplay.NewRecorderWithOpts(recordFile)
+	var r recorderOrReplayer
+	rd := newRedactor(o.redaction)
+	switch {
+	case len(o.routes) > 0:
+		r, err = newHybridRecorder(recordFile, rd, store, o, mode)
+	case mode == RecordAll:
+		r = newHTTPRecorder(recordFile, rd, store, o)
+	case mode == RecordNewEpisodes:
+		r, err = newEpisodeRecorder(recordFile, rd, store, o)
+	case mode == RecordPassthrough:
+		r = passthrough{}
+	default:
+		r, err = newHTTPReplayer(recordFile, rd, store, o)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to open record file: [%w]", err)
 	}
 
+	handler := &httpHandler{
+		remoteAddr: remoteAddr,
+		client:     r.Client(),
+		logger:     o.logger,
+	}
+	if o.openAPI != "" {
+		if handler.contract, err = loadContract(o.openAPI); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	if h, ok := r.(*hybridRecorder); ok {
+		handler.route = h.route
+	}
 	srv := &http.Server{
-		Addr: fmt.Sprintf(":%d", port),
-		Handler: &httpHandler{
-			remoteAddr: remoteAddr,
-			client:     r.Client(),
-		},
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: handler,
 	}
 
+	lstr := o.listener
+	if lstr == nil {
+		lstr, err = net.Listen("tcp", srv.Addr)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("failed to listen on address %q: [%w]", srv.Addr, err)
+		}
+	}
+	ready := make(chan struct{})
+	close(ready)
+
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		_ = srv.ListenAndServe()
+		if err := srv.Serve(lstr); err != http.ErrServerClosed {
+			o.logger.Error("record/replay server stopped serving", "error", err)
+		}
 	}()
+	o.logger.Info("record/replay server listening", "addr", dialAddr(lstr.Addr()), "remote", remoteAddr, "file", recordFile, "mode", mode)
 
 	return &HTTPServer{
-		wg:  &wg,
-		srv: srv,
-		r:   r,
+		ready:   ready,
+		wg:      &wg,
+		lstr:    lstr,
+		srv:     srv,
+		handler: handler,
+		r:       r,
 	}, nil
 }
 
+// Ready is closed once the server is listening for incoming requests.
+func (h *HTTPServer) Ready() <-chan struct{} {
+	return h.ready
+}
+
+// Addr returns the address server is listening on.
+func (h *HTTPServer) Addr() string {
+	return dialAddr(h.lstr.Addr())
+}
+
+// Close stops the server and writes recorded interactions when recording.
+// In strict mode, see WithStrict, it also reports interactions that didn't replay as recorded,
+// and with WithOpenAPI, requests and responses that violate the document as SchemaError.
 func (h *HTTPServer) Close() error {
+	h.handler.logger.Info("record/replay server shutting down", "addr", h.Addr())
 	err := h.srv.Shutdown(context.Background())
-	h.r.Close()
+	rErr := h.r.Close()
 	h.wg.Wait()
+	h.handler.mux.Lock()
+	defer h.handler.mux.Unlock()
+	err = errors.Join(err, rErr, schemaError(h.handler.violations))
+	if err != nil {
+		h.handler.logger.Error("record/replay server closed with errors", "error", err)
+	}
 	return err
 }
 
@@ -83,28 +153,71 @@ var _ http.Handler = (*httpHandler)(nil)
 type httpHandler struct {
 	remoteAddr string
 	client     *http.Client
+	// route, if set, returns the route overriding remote address and client for the request
+	route func(*http.Request) *route
+	// contract, if set, validates requests and responses, see WithOpenAPI
+	contract *contract
+	logger   Logger
+
+	mux        sync.Mutex
+	violations []string
+}
+
+func (h *httpHandler) report(violations []string) {
+	if len(violations) == 0 {
+		return
+	}
+	for _, v := range violations {
+		h.logger.Warn("OpenAPI violation", "violation", v)
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.violations = append(h.violations, violations...)
 }
 
+// ServeHTTP forwards the request, with its path and query, to the dependency via the recorder or replayer
+// and passes back status, headers and body of the response. A request that fails, e.g. the dependency
+// is down or no recorded interaction matches, gets 502 Bad Gateway with the error in the body.
 func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	remoteAddr, client := h.remoteAddr, h.client
+	if h.route != nil {
+		if rt := h.route(r); rt != nil {
+			client = rt.client
+			if rt.RemoteAddr != "" {
+				remoteAddr = rt.RemoteAddr
+			}
+		}
+	}
+	if h.contract != nil {
+		h.report(h.contract.checkRequest(r))
+	}
 	r.RequestURI = ""
-	u, err := url.Parse(fmt.Sprintf("http://%s%s", h.remoteAddr, r.URL.Path))
+	u, err := url.Parse(fmt.Sprintf("http://%s%s", remoteAddr, r.URL.RequestURI()))
 	if err != nil {
+		h.logger.Error("failed to build dependency URL", "remote", remoteAddr, "url", r.URL.RequestURI(), "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
 	}
 	r.URL = u
 	r.Host = u.Host
-	resp, err := h.client.Do(r)
+	start := time.Now()
+	resp, err := client.Do(r)
 	if err != nil {
-		w.WriteHeader(resp.StatusCode)
+		h.logger.Warn("dependency request failed", "method", r.Method, "url", u.String(), "error", err)
+		w.WriteHeader(http.StatusBadGateway)
 		w.Write([]byte(err.Error()))
 		return
 	}
 	defer resp.Body.Close()
-	if _, err := io.Copy(w, resp.Body); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
-		return
+	h.logger.Info("proxied exchange", "method", r.Method, "url", u.String(), "status", resp.StatusCode, "elapsed", time.Since(start))
+	if h.contract != nil {
+		h.report(h.contract.checkResponse(r, resp))
+	}
+	for k, v := range resp.Header {
+		w.Header()[k] = v
 	}
+	w.WriteHeader(resp.StatusCode)
+	// status is already sent, nothing to report to the client
+	_, _ = io.Copy(w, resp.Body)
 }
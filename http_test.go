@@ -1 +1,221 @@
 package replay_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/daulet/replay"
+)
+
+func TestHTTPServer(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "http.record")
+	remoteAddr := serve(t)
+
+	for _, record := range []bool{true, false} {
+		t.Run(fmt.Sprintf("record=%v", record), func(t *testing.T) {
+			srv, err := replay.NewHTTPServer(0, record, remoteAddr, recordFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			<-srv.Ready()
+
+			resp, err := http.Get(fmt.Sprintf("http://%s/foo", srv.Addr()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != "foo" {
+				t.Errorf("got %q, want %q", body, "foo")
+			}
+
+			if err := srv.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestHTTPServerStrict(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "http.record")
+	remoteAddr := serve(t)
+
+	get := func(t *testing.T, srv *replay.HTTPServer, path string) {
+		t.Helper()
+		resp, err := http.Get(fmt.Sprintf("http://%s%s", srv.Addr(), path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	srv, err := replay.NewHTTPServer(0, true, remoteAddr, recordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get(t, srv, "/foo")
+	get(t, srv, "/bar")
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		paths []string
+		want  []string
+	}{
+		{name: "all used", paths: []string{"/bar", "/foo"}},
+		{name: "unused", paths: []string{"/foo"}, want: []string{"GET /bar used 0 of 1 times"}},
+		{name: "unexpected", paths: []string{"/foo", "/bar", "/foo", "/baz"}, want: []string{
+			"unexpected request GET /foo",
+			"unexpected request GET /baz",
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv, err := replay.NewHTTPServer(0, false, remoteAddr, recordFile, replay.WithStrict())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, path := range tc.paths {
+				get(t, srv, path)
+			}
+			err = srv.Close()
+			if len(tc.want) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error doesn't contain %q:\n%v", want, err)
+				}
+			}
+		})
+	}
+
+	// interactions can be replayed any number of times
+	b, err := os.ReadFile(recordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = bytes.Replace(b, []byte(`"Response": {`), []byte(`"Times": -1, "Response": {`), 1)
+	if err := os.WriteFile(recordFile, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv, err = replay.NewHTTPServer(0, false, remoteAddr, recordFile, replay.WithStrict())
+	if err != nil {
+		t.Fatal(err)
+	}
+	get(t, srv, "/foo")
+	get(t, srv, "/foo")
+	get(t, srv, "/foo")
+	get(t, srv, "/bar")
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestHTTPServerHTTPReplayRecording replays a record file made by github.com/google/go-replayers/httpreplay,
+// which the record/replay server used before, to make sure the format stays compatible.
+func TestHTTPServerHTTPReplayRecording(t *testing.T) {
+	srv, err := replay.NewHTTPServer(0, false, "localhost:1", filepath.Join("testdata", "httpreplay", "http.record"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	for path, want := range map[string]string{
+		"/foo/25": `Hello, "/foo/25"`,
+		"/bar/49": `Hi, "/bar/49"`,
+	} {
+		resp, err := http.Get(fmt.Sprintf("http://%s%s", srv.Addr(), path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != want {
+			t.Errorf("%s: got %d %q, want %d %q", path, resp.StatusCode, body, http.StatusOK, want)
+		}
+		if got := resp.Header.Get("Content-Type"); got != "text/plain; charset=utf-8" {
+			t.Errorf("%s: got Content-Type %q, want recorded one", path, got)
+		}
+	}
+}
+
+func TestHTTPServerProxy(t *testing.T) {
+	remoteAddr := serveHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Query", r.URL.RawQuery)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	srv, err := replay.NewHTTPServer(0, true, remoteAddr, filepath.Join(t.TempDir(), "http.record"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	// query is forwarded, status and headers of the response are passed back
+	resp, err := http.Get(fmt.Sprintf("http://%s/foo?page=2", srv.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || resp.Header.Get("X-Query") != "page=2" {
+		t.Errorf("got %d with X-Query %q, want %d with %q", resp.StatusCode, resp.Header.Get("X-Query"), http.StatusAccepted, "page=2")
+	}
+
+	// dependency that can't be reached is reported as a bad gateway
+	srv, err = replay.NewHTTPServer(0, true, "localhost:1", filepath.Join(t.TempDir(), "http.record"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	resp, err = http.Get(fmt.Sprintf("http://%s/foo", srv.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("got %d, want %d", resp.StatusCode, http.StatusBadGateway)
+	}
+}
+
+func TestHTTPServerRedirectOtherAddress(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "http.record")
+	mux := http.NewServeMux()
+	mux.Handle("/old", http.RedirectHandler("/new", http.StatusMovedPermanently))
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("new"))
+	})
+	srv, err := replay.NewHTTPServer(0, true, serveHandler(t, mux), recordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getAll(t, srv, "/old")
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the followed redirect has Referer with the address of the dependency, which differs on replay
+	srv, err = replay.NewHTTPServer(0, false, "localhost:1", recordFile, replay.WithStrict())
+	if err != nil {
+		t.Fatal(err)
+	}
+	getAll(t, srv, "/old")
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
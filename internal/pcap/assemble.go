@@ -0,0 +1,140 @@
+package pcap
+
+import (
+	"net/netip"
+	"sort"
+	"time"
+)
+
+type flowKey struct {
+	src, dst netip.AddrPort
+}
+
+// assembler collects segments of TCP connections.
+type assembler struct {
+	conns []*conn
+	// open maps endpoints of a connection to the latest connection between them
+	open map[flowKey]*conn
+}
+
+// conn is a TCP connection being reassembled.
+type conn struct {
+	ends   [2]netip.AddrPort
+	halves [2]half
+	// client is the index of the end that opened the connection, -1 if the handshake wasn't seen
+	client int
+	closed bool
+}
+
+// half is one direction of a connection, from the corresponding end.
+type half struct {
+	isn     uint32
+	synSeen bool
+	segs    []segment
+}
+
+type segment struct {
+	seq     uint32
+	payload []byte
+	time    time.Time
+}
+
+func newAssembler() *assembler {
+	return &assembler{open: make(map[flowKey]*conn)}
+}
+
+func (a *assembler) add(p packet) {
+	c := a.open[flowKey{p.src, p.dst}]
+	if c == nil {
+		c = a.open[flowKey{p.dst, p.src}]
+	}
+	syn := p.flags&FlagSYN != 0 && p.flags&FlagACK == 0
+	// endpoints are reused by a new connection
+	if c != nil && syn && (c.closed || len(c.halves[0].segs)+len(c.halves[1].segs) > 0) {
+		c = nil
+	}
+	if c == nil {
+		c = &conn{ends: [2]netip.AddrPort{p.src, p.dst}, client: -1}
+		a.conns = append(a.conns, c)
+		a.open[flowKey{p.src, p.dst}] = c
+		delete(a.open, flowKey{p.dst, p.src})
+	}
+	dir := 0
+	if p.src != c.ends[0] {
+		dir = 1
+	}
+	h := &c.halves[dir]
+	if p.flags&FlagSYN != 0 {
+		h.isn, h.synSeen = p.seq+1, true
+		if syn {
+			c.client = dir
+		} else {
+			c.client = 1 - dir
+		}
+	}
+	if p.flags&(FlagFIN|FlagRST) != 0 {
+		c.closed = true
+	}
+	if len(p.payload) > 0 {
+		h.segs = append(h.segs, segment{seq: p.seq, payload: p.payload, time: p.time})
+	}
+}
+
+func (a *assembler) flows() []*Flow {
+	flows := make([]*Flow, 0, len(a.conns))
+	for _, c := range a.conns {
+		client := c.client
+		if client < 0 {
+			// assume the server listens on a well-known port, lower than an ephemeral one
+			client = 0
+			if c.ends[1].Port() > c.ends[0].Port() {
+				client = 1
+			}
+		}
+		server := 1 - client
+		flows = append(flows, &Flow{
+			Client:   c.ends[client],
+			Server:   c.ends[server],
+			ToServer: c.halves[client].stream(),
+			ToClient: c.halves[server].stream(),
+		})
+	}
+	return flows
+}
+
+// stream orders segments by sequence number, dropping retransmitted data, up to the first gap.
+func (h *half) stream() Stream {
+	var s Stream
+	if len(h.segs) == 0 {
+		return s
+	}
+	base := h.isn
+	if !h.synSeen {
+		// the earliest sequence number captured, modulo wraparound
+		base = h.segs[0].seq
+		for _, seg := range h.segs {
+			if int32(seg.seq-base) < 0 {
+				base = seg.seq
+			}
+		}
+	}
+	segs := make([]segment, len(h.segs))
+	copy(segs, h.segs)
+	rel := func(seg segment) int64 { return int64(int32(seg.seq - base)) }
+	sort.SliceStable(segs, func(i, j int) bool { return rel(segs[i]) < rel(segs[j]) })
+	var next int64
+	for _, seg := range segs {
+		start, end := rel(seg), rel(seg)+int64(len(seg.payload))
+		if start > next {
+			s.Truncated = true
+			break
+		}
+		if end <= next || start < 0 {
+			continue
+		}
+		s.marks = append(s.marks, mark{offset: len(s.Data), time: seg.time})
+		s.Data = append(s.Data, seg.payload[next-start:]...)
+		next = end
+	}
+	return s
+}
@@ -0,0 +1,226 @@
+// Package pcap reassembles TCP streams from packet captures in the classic pcap format,
+// as written by tcpdump, without depending on libpcap.
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+const (
+	magicMicros        = 0xa1b2c3d4
+	magicNanos         = 0xa1b23c4d
+	magicPCAPNG        = 0x0a0d0d0a
+	globalHeaderLen    = 24
+	recordHeaderLen    = 16
+	maxRecordLen       = 1 << 24
+	linkTypeNull       = 0
+	linkTypeEthernet   = 1
+	linkTypeRaw        = 101
+	linkTypeLinuxSLL   = 113
+	linkTypeLinuxSLL2  = 276
+	etherTypeIPv4      = 0x0800
+	etherTypeIPv6      = 0x86dd
+	etherTypeVLAN      = 0x8100
+	protocolTCP        = 6
+	ipv4FlagMoreFrags  = 0x2000
+	ipv4FragOffsetMask = 0x1fff
+)
+
+// TCP flags.
+const (
+	FlagFIN = 0x01
+	FlagSYN = 0x02
+	FlagRST = 0x04
+	FlagACK = 0x10
+)
+
+// Flow is a TCP connection reassembled from a capture.
+type Flow struct {
+	// Client opened the connection, Server accepted it. If the handshake wasn't captured,
+	// the endpoint with the lower port is assumed to be the server.
+	Client, Server netip.AddrPort
+	// ToServer and ToClient are bytes sent in each direction.
+	ToServer, ToClient Stream
+}
+
+// Stream is data sent in one direction of a flow.
+type Stream struct {
+	Data []byte
+	// Truncated is set if some of the data wasn't captured, Data ends before the gap.
+	Truncated bool
+
+	marks []mark
+}
+
+// mark is a timestamp of the packet data at offset arrived in.
+type mark struct {
+	offset int
+	time   time.Time
+}
+
+// TimeAt returns the time data at offset was captured, zero if it wasn't.
+func (s *Stream) TimeAt(offset int) time.Time {
+	i := sort.Search(len(s.marks), func(i int) bool { return s.marks[i].offset > offset })
+	if i == 0 {
+		return time.Time{}
+	}
+	return s.marks[i-1].time
+}
+
+// packet is a TCP segment.
+type packet struct {
+	time     time.Time
+	src, dst netip.AddrPort
+	seq      uint32
+	flags    uint8
+	payload  []byte
+}
+
+// Read reads a capture and returns TCP flows in order of their first packet.
+func Read(r io.Reader) ([]*Flow, error) {
+	header := make([]byte, globalHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read pcap header: [%w]", err)
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	var nanos bool
+	switch magic := binary.LittleEndian.Uint32(header); magic {
+	case magicMicros, magicNanos:
+		nanos = magic == magicNanos
+	case swap(magicMicros), swap(magicNanos):
+		order, nanos = binary.BigEndian, magic == swap(magicNanos)
+	case magicPCAPNG:
+		return nil, errors.New("pcapng format is not supported, convert the capture with: editcap -F pcap")
+	default:
+		return nil, fmt.Errorf("not a pcap file, magic %#x", magic)
+	}
+	linkType := order.Uint32(header[20:]) & 0x0fffffff
+
+	a := newAssembler()
+	record := make([]byte, recordHeaderLen)
+	for {
+		if _, err := io.ReadFull(r, record); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read packet header: [%w]", err)
+		}
+		sec, frac, length := order.Uint32(record), order.Uint32(record[4:]), order.Uint32(record[8:])
+		if length > maxRecordLen {
+			return nil, fmt.Errorf("packet length %d is too large", length)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("failed to read packet: [%w]", err)
+		}
+		if !nanos {
+			frac *= 1000
+		}
+		p, ok := decode(linkType, data)
+		if !ok {
+			continue
+		}
+		p.time = time.Unix(int64(sec), int64(frac)).UTC()
+		a.add(p)
+	}
+	return a.flows(), nil
+}
+
+func swap(v uint32) uint32 {
+	return v>>24 | v>>8&0xff00 | v<<8&0xff0000 | v<<24
+}
+
+// decode parses TCP segment from link layer frame, ok is false for other packets.
+func decode(linkType uint32, data []byte) (packet, bool) {
+	switch linkType {
+	case linkTypeNull:
+		if len(data) < 4 {
+			return packet{}, false
+		}
+		return decodeIP(data[4:])
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return packet{}, false
+		}
+		etherType, data := binary.BigEndian.Uint16(data[12:]), data[14:]
+		for etherType == etherTypeVLAN && len(data) >= 4 {
+			etherType, data = binary.BigEndian.Uint16(data[2:]), data[4:]
+		}
+		if etherType != etherTypeIPv4 && etherType != etherTypeIPv6 {
+			return packet{}, false
+		}
+		return decodeIP(data)
+	case linkTypeRaw:
+		return decodeIP(data)
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return packet{}, false
+		}
+		return decodeIP(data[16:])
+	case linkTypeLinuxSLL2:
+		if len(data) < 20 {
+			return packet{}, false
+		}
+		return decodeIP(data[20:])
+	}
+	return packet{}, false
+}
+
+// decodeIP parses TCP segment from IPv4 or IPv6 packet, fragmented packets and IPv6 extension headers
+// aren't supported.
+func decodeIP(data []byte) (packet, bool) {
+	if len(data) == 0 {
+		return packet{}, false
+	}
+	var src, dst netip.Addr
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < 20 {
+			return packet{}, false
+		}
+		headerLen, totalLen := int(data[0]&0x0f)*4, int(binary.BigEndian.Uint16(data[2:]))
+		frag := binary.BigEndian.Uint16(data[6:])
+		if data[9] != protocolTCP || frag&(ipv4FlagMoreFrags|ipv4FragOffsetMask) != 0 ||
+			headerLen < 20 || totalLen < headerLen || len(data) < headerLen {
+			return packet{}, false
+		}
+		src, _ = netip.AddrFromSlice(data[12:16])
+		dst, _ = netip.AddrFromSlice(data[16:20])
+		// frames can be padded, snapshot can cut the packet short
+		if totalLen < len(data) {
+			data = data[:totalLen]
+		}
+		data = data[headerLen:]
+	case 6:
+		if len(data) < 40 || data[6] != protocolTCP {
+			return packet{}, false
+		}
+		src, _ = netip.AddrFromSlice(data[8:24])
+		dst, _ = netip.AddrFromSlice(data[24:40])
+		if total := 40 + int(binary.BigEndian.Uint16(data[4:])); total < len(data) {
+			data = data[:total]
+		}
+		data = data[40:]
+	default:
+		return packet{}, false
+	}
+	if len(data) < 20 {
+		return packet{}, false
+	}
+	offset := int(data[12]>>4) * 4
+	if offset < 20 || offset > len(data) {
+		return packet{}, false
+	}
+	return packet{
+		src:     netip.AddrPortFrom(src, binary.BigEndian.Uint16(data[0:])),
+		dst:     netip.AddrPortFrom(dst, binary.BigEndian.Uint16(data[2:])),
+		seq:     binary.BigEndian.Uint32(data[4:]),
+		flags:   data[13],
+		payload: data[offset:],
+	}, true
+}
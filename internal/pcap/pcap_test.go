@@ -0,0 +1,94 @@
+package pcap_test
+
+import (
+	"bytes"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/daulet/replay/internal/pcap"
+)
+
+func TestRead(t *testing.T) {
+	client := netip.MustParseAddrPort("10.0.0.1:51000")
+	server := netip.MustParseAddrPort("10.0.0.2:80")
+	other := netip.MustParseAddrPort("10.0.0.3:51001")
+	start := time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+
+	var buf bytes.Buffer
+	w, err := pcap.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, seg := range []struct {
+		src, dst netip.AddrPort
+		seq      uint32
+		flags    uint8
+		payload  string
+	}{
+		{client, server, 999, pcap.FlagSYN, ""},
+		{server, client, 4999, pcap.FlagSYN | pcap.FlagACK, ""},
+		{client, server, 1000, pcap.FlagACK, ""},
+		// out of order and retransmitted
+		{client, server, 1006, pcap.FlagACK, "world"},
+		{client, server, 1000, pcap.FlagACK, "hello "},
+		{client, server, 1000, pcap.FlagACK, "hello "},
+		{server, client, 5000, pcap.FlagACK, "hi"},
+		// handshake isn't captured, data is missing in the middle
+		{other, server, 7, pcap.FlagACK, "abc"},
+		{other, server, 20, pcap.FlagACK, "xyz"},
+		{client, server, 1011, pcap.FlagFIN | pcap.FlagACK, ""},
+	} {
+		if err := w.WriteSegment(start.Add(time.Duration(i)*time.Second), seg.src, seg.dst, seg.seq, seg.flags, []byte(seg.payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flows, err := pcap.Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 2 {
+		t.Fatalf("got %d flows, want 2", len(flows))
+	}
+	f := flows[0]
+	if f.Client != client || f.Server != server {
+		t.Errorf("got client %v server %v, want %v and %v", f.Client, f.Server, client, server)
+	}
+	if got := string(f.ToServer.Data); got != "hello world" || f.ToServer.Truncated {
+		t.Errorf("got %q (truncated %v), want %q", got, f.ToServer.Truncated, "hello world")
+	}
+	if got := string(f.ToClient.Data); got != "hi" {
+		t.Errorf("got %q, want %q", got, "hi")
+	}
+	// "hello " arrived after "world"
+	if got, want := f.ToServer.TimeAt(0), start.Add(4*time.Second); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := f.ToServer.TimeAt(7), start.Add(3*time.Second); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	f = flows[1]
+	if f.Client != other || f.Server != server {
+		t.Errorf("got client %v server %v, want %v and %v", f.Client, f.Server, other, server)
+	}
+	if got := string(f.ToServer.Data); got != "abc" || !f.ToServer.Truncated {
+		t.Errorf("got %q (truncated %v), want truncated %q", got, f.ToServer.Truncated, "abc")
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	for name, tc := range map[string]struct {
+		data, want string
+	}{
+		"pcapng": {"\x0a\x0d\x0d\x0a" + strings.Repeat("\x00", 20), "pcapng"},
+		"other":  {strings.Repeat("x", 24), "not a pcap file"},
+		"short":  {"\xd4\xc3\xb2\xa1", "pcap header"},
+	} {
+		if _, err := pcap.Read(strings.NewReader(tc.data)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want error containing %q", name, err, tc.want)
+		}
+	}
+}
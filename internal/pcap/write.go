@@ -0,0 +1,67 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/netip"
+	"time"
+)
+
+// Writer writes TCP segments over IPv4 and Ethernet in the classic pcap format, e.g. to produce captures for tests.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter writes pcap header to w and returns a Writer of packets.
+func NewWriter(w io.Writer) (*Writer, error) {
+	header := make([]byte, globalHeaderLen)
+	binary.LittleEndian.PutUint32(header, magicNanos)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], 65535)
+	binary.LittleEndian.PutUint32(header[20:], linkTypeEthernet)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+// WriteSegment writes TCP segment with payload sent from src to dst at t. Checksums are left zero.
+func (w *Writer) WriteSegment(t time.Time, src, dst netip.AddrPort, seq uint32, flags uint8, payload []byte) error {
+	if !src.Addr().Is4() || !dst.Addr().Is4() {
+		return errors.New("only IPv4 addresses are supported")
+	}
+	const ethLen, ipLen, tcpLen = 14, 20, 20
+	frame := make([]byte, ethLen+ipLen+tcpLen+len(payload))
+	binary.BigEndian.PutUint16(frame[12:], etherTypeIPv4)
+
+	ip := frame[ethLen:]
+	ip[0] = 4<<4 | ipLen/4
+	binary.BigEndian.PutUint16(ip[2:], uint16(ipLen+tcpLen+len(payload)))
+	ip[8] = 64
+	ip[9] = protocolTCP
+	srcIP, dstIP := src.Addr().As4(), dst.Addr().As4()
+	copy(ip[12:], srcIP[:])
+	copy(ip[16:], dstIP[:])
+
+	tcp := ip[ipLen:]
+	binary.BigEndian.PutUint16(tcp[0:], src.Port())
+	binary.BigEndian.PutUint16(tcp[2:], dst.Port())
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	tcp[12] = tcpLen / 4 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	copy(tcp[tcpLen:], payload)
+
+	record := make([]byte, recordHeaderLen)
+	binary.LittleEndian.PutUint32(record, uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(t.Nanosecond()))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(frame)))
+	if _, err := w.w.Write(record); err != nil {
+		return err
+	}
+	_, err := w.w.Write(frame)
+	return err
+}
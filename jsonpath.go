@@ -0,0 +1,85 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a dot separated path into a JSON document, e.g. "items.0.id".
+// Element "*" matches any object key or array index.
+type jsonPath []string
+
+func parseJSONPath(s string) jsonPath {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "$"), ".")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ".")
+}
+
+func (p jsonPath) String() string {
+	return strings.Join(p, ".")
+}
+
+// replace calls fn on every value matching the path and replaces it with the result.
+// It reports whether any value matched.
+func (p jsonPath) replace(v any, fn func(any) any) (any, bool) {
+	if len(p) == 0 {
+		return fn(v), true
+	}
+	var matched bool
+	switch v := v.(type) {
+	case map[string]any:
+		for key, elem := range v {
+			if p[0] != "*" && p[0] != key {
+				continue
+			}
+			if nv, ok := p[1:].replace(elem, fn); ok {
+				v[key] = nv
+				matched = true
+			}
+		}
+	case []any:
+		for i, elem := range v {
+			if p[0] != "*" && p[0] != strconv.Itoa(i) {
+				continue
+			}
+			if nv, ok := p[1:].replace(elem, fn); ok {
+				v[i] = nv
+				matched = true
+			}
+		}
+	}
+	return v, matched
+}
+
+// decodeJSON decodes body preserving number formatting, ok is false if body is not JSON.
+func decodeJSON(body []byte) (v any, ok bool) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, false
+	}
+	// trailing garbage means it's not a JSON document
+	if dec.More() {
+		return nil, false
+	}
+	return v, true
+}
+
+// encodeJSON encodes v without escaping HTML characters, preserving trailing newline of the original body.
+func encodeJSON(v any, original []byte) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return original
+	}
+	b := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	if bytes.HasSuffix(original, []byte("\n")) {
+		b = append(b, '\n')
+	}
+	return b
+}
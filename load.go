@@ -0,0 +1,183 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LoadProfile describes how Load replays requests of a test case.
+type LoadProfile struct {
+	// RPS is the target rate of requests per second, as fast as possible if zero.
+	// The rate isn't reached if Concurrency requests can't keep up with it.
+	RPS float64
+	// Concurrency is the maximum number of requests in flight, one if zero.
+	Concurrency int
+	// Duration limits the time requests are sent for, no limit if zero.
+	Duration time.Duration
+	// Loop repeats requests of the test case until Duration elapses, otherwise each is sent once.
+	Loop bool
+}
+
+// LoadReport summarizes results of Load.
+type LoadReport struct {
+	// Requests is the number of requests sent.
+	Requests int
+	// Errors is the number of requests that failed or got a 5xx response.
+	Errors int
+	// Elapsed is the time it took to send requests and receive responses.
+	Elapsed time.Duration
+	// P50, P90 and P99 are percentiles of latency, time to receive a complete response.
+	P50, P90, P99 time.Duration
+	// Max is the highest latency.
+	Max time.Duration
+}
+
+// Throughput returns the number of requests per second.
+func (r *LoadReport) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Elapsed.Seconds()
+}
+
+// ErrorRate returns the fraction of requests that failed.
+func (r *LoadReport) ErrorRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Errors) / float64(r.Requests)
+}
+
+func (r *LoadReport) String() string {
+	return fmt.Sprintf("%d requests in %v, %.1f req/s, %.2f%% errors, latency p50 %v p90 %v p99 %v max %v",
+		r.Requests, r.Elapsed.Round(time.Millisecond), r.Throughput(), 100*r.ErrorRate(), r.P50, r.P90, r.P99, r.Max)
+}
+
+// Load replays recorded requests of the test case as a load profile, see LoadProfile. Responses aren't compared
+// to recorded ones. Test cases with captures aren't supported, since their requests depend on each other.
+func (h *httpRunner) Load(p LoadProfile) (*LoadReport, error) {
+	tc, err := readTestCase(h.store, h.writeDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(tc.Captures) > 0 {
+		return nil, errors.New("test case with captures can't be replayed as load")
+	}
+	if p.Loop && p.Duration <= 0 {
+		return nil, errors.New("looping load requires duration")
+	}
+	reqs, _, err := h.load(true)
+	if err != nil {
+		return nil, err
+	}
+	if len(reqs) == 0 {
+		return nil, fmt.Errorf("no recorded requests in %q", h.writeDir)
+	}
+	bodies := make([][]byte, len(reqs))
+	for i, req := range reqs {
+		if bodies[i], err = io.ReadAll(req.Body); err != nil {
+			return nil, fmt.Errorf("failed to read %d-th request body: [%w]", i, err)
+		}
+	}
+	concurrency := p.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	// default transport keeps only 2 idle connections per host, the rest would be reconnected for every request
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = concurrency
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+
+	var (
+		mux       sync.Mutex
+		latencies []time.Duration
+		failed    int
+	)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				req := reqs[i].Clone(context.Background())
+				req.Body = io.NopCloser(bytes.NewReader(bodies[i]))
+				start := time.Now()
+				ok := h.loadOne(client, req)
+				latency := time.Since(start)
+				mux.Lock()
+				latencies = append(latencies, latency)
+				if !ok {
+					failed++
+				}
+				mux.Unlock()
+			}
+		}()
+	}
+
+	var tick, done <-chan time.Time
+	if p.RPS > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / p.RPS))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	if p.Duration > 0 {
+		timer := time.NewTimer(p.Duration)
+		defer timer.Stop()
+		done = timer.C
+	}
+	start := time.Now()
+send:
+	for n := 0; p.Loop || n < len(reqs); n++ {
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-done:
+				break send
+			}
+		}
+		select {
+		case jobs <- n % len(reqs):
+		case <-done:
+			break send
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return newLoadReport(latencies, failed, time.Since(start)), nil
+}
+
+// loadOne sends req and reads the complete response, reporting whether it succeeded.
+func (h *httpRunner) loadOne(client *http.Client, req *http.Request) bool {
+	resp := h.sendOne(client, h.remoteAddr, req, nil)
+	if resp.err != nil {
+		return false
+	}
+	defer resp.resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.resp.Body); err != nil {
+		return false
+	}
+	return resp.resp.StatusCode < http.StatusInternalServerError
+}
+
+func newLoadReport(latencies []time.Duration, failed int, elapsed time.Duration) *LoadReport {
+	r := &LoadReport{Requests: len(latencies), Errors: failed, Elapsed: elapsed}
+	if len(latencies) == 0 {
+		return r
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	// nearest-rank percentile
+	percentile := func(p int) time.Duration {
+		return latencies[(len(latencies)*p+99)/100-1]
+	}
+	r.P50, r.P90, r.P99, r.Max = percentile(50), percentile(90), percentile(99), latencies[len(latencies)-1]
+	return r
+}
@@ -0,0 +1,113 @@
+package replay_test
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daulet/replay"
+)
+
+func TestLoad(t *testing.T) {
+	var calls atomic.Int32
+	appAddr := serveHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	testDir := t.TempDir()
+	recordTestCase(t, appAddr, testDir, func(addr string) {
+		for _, path := range []string{"/ok", "/fail"} {
+			resp, err := http.Get("http://" + addr + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+		}
+	})
+	runner, err := replay.NewHTTPRunner(0, appAddr, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("once", func(t *testing.T) {
+		report, err := runner.Load(replay.LoadProfile{Concurrency: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Requests != 2 || report.Errors != 1 {
+			t.Errorf("got %v, want 2 requests and 1 error", report)
+		}
+		if report.ErrorRate() != 0.5 {
+			t.Errorf("got error rate %v, want 0.5", report.ErrorRate())
+		}
+		if report.P50 <= 0 || report.P50 > report.Max {
+			t.Errorf("got %v, want positive latencies", report)
+		}
+	})
+
+	t.Run("loop", func(t *testing.T) {
+		before := calls.Load()
+		report, err := runner.Load(replay.LoadProfile{RPS: 50, Concurrency: 2, Duration: 200 * time.Millisecond, Loop: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := int(calls.Load() - before); got != report.Requests {
+			t.Errorf("app got %d requests, report has %d", got, report.Requests)
+		}
+		// 10 requests at 50 rps in 200ms, give or take a tick
+		if report.Requests < 5 || report.Requests > 11 {
+			t.Errorf("got %v, want about 10 requests", report)
+		}
+	})
+
+	t.Run("connections", func(t *testing.T) {
+		lstr, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var opened, closed atomic.Int32
+		srv := &http.Server{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+			ConnState: func(c net.Conn, state http.ConnState) {
+				switch state {
+				case http.StateNew:
+					opened.Add(1)
+				case http.StateClosed:
+					closed.Add(1)
+				}
+			},
+		}
+		go srv.Serve(lstr)
+		defer srv.Close()
+
+		runner, err := replay.NewHTTPRunner(0, lstr.Addr().String(), testDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := runner.Load(replay.LoadProfile{Concurrency: 4, Duration: 100 * time.Millisecond, Loop: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// every worker keeps its connection between requests
+		if got := int(opened.Load()); got > 4 {
+			t.Errorf("got %d connections for %d requests, want at most 4", got, report.Requests)
+		}
+		deadline := time.Now().Add(time.Second)
+		for closed.Load() != opened.Load() && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if closed.Load() != opened.Load() {
+			t.Errorf("got %d of %d connections closed after load", closed.Load(), opened.Load())
+		}
+	})
+
+	if _, err := runner.Load(replay.LoadProfile{Loop: true}); err == nil {
+		t.Error("expected error for endless load")
+	}
+}
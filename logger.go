@@ -0,0 +1,29 @@
+package replay
+
+// Logger receives structured logs of the runner and the record/replay and stub servers: every exchange
+// they proxy, record or replay, how requests are matched to recorded interactions, and failures that
+// don't surface as errors, e.g. writing a recording. Args are alternating keys and values.
+// *slog.Logger satisfies it.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// WithLogger sends logs to logger, by default they are discarded.
+func WithLogger(logger Logger) Option {
+	return func(o *options) {
+		if logger != nil {
+			o.logger = logger
+		}
+	}
+}
+
+// nopLogger discards logs.
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
@@ -0,0 +1,126 @@
+package replay_test
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/daulet/replay"
+)
+
+// testLogger collects logs as "LEVEL msg" lines, with arguments as a map.
+type testLogger struct {
+	mux     sync.Mutex
+	entries []string
+	args    []map[string]any
+}
+
+func (l *testLogger) log(level, msg string, args []any) {
+	m := map[string]any{}
+	for i := 0; i+1 < len(args); i += 2 {
+		m[fmt.Sprint(args[i])] = args[i+1]
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.entries = append(l.entries, level+" "+msg)
+	l.args = append(l.args, m)
+}
+
+func (l *testLogger) Debug(msg string, args ...any) { l.log("DEBUG", msg, args) }
+func (l *testLogger) Info(msg string, args ...any)  { l.log("INFO", msg, args) }
+func (l *testLogger) Warn(msg string, args ...any)  { l.log("WARN", msg, args) }
+func (l *testLogger) Error(msg string, args ...any) { l.log("ERROR", msg, args) }
+
+// find returns arguments of the first entry, nil if there's none.
+func (l *testLogger) find(entry string) map[string]any {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	for i, e := range l.entries {
+		if e == entry {
+			return l.args[i]
+		}
+	}
+	return nil
+}
+
+func TestLoggerHTTPServer(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "http.record")
+	remoteAddr := serveText(t, "recorded")
+
+	logger := &testLogger{}
+	srv, err := replay.NewHTTPServer(0, true, remoteAddr, recordFile, replay.WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	getAll(t, srv, "/foo")
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if args := logger.find("INFO proxied exchange"); args == nil || args["status"] != http.StatusOK {
+		t.Errorf("got logs %q, want proxied exchange", logger.entries)
+	}
+	if args := logger.find("INFO wrote recording"); args == nil || args["interactions"] != 1 {
+		t.Errorf("got logs %q, want written recording", logger.entries)
+	}
+
+	logger = &testLogger{}
+	srv, err = replay.NewHTTPServer(0, false, remoteAddr, recordFile, replay.WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	getAll(t, srv, "/foo")
+	resp, err := http.Get("http://" + srv.Addr() + "/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if args := logger.find("DEBUG matched recorded interaction"); args == nil || args["request"] != "GET /foo" {
+		t.Errorf("got logs %q, want matched /foo", logger.entries)
+	}
+	if args := logger.find("WARN no recorded interaction matches request"); args == nil || args["request"] != "GET /bar" {
+		t.Errorf("got logs %q, want unmatched /bar", logger.entries)
+	}
+	if logger.find("INFO record/replay server shutting down") == nil {
+		t.Errorf("got logs %q, want shutdown", logger.entries)
+	}
+}
+
+func TestLoggerRunner(t *testing.T) {
+	testDir := t.TempDir()
+	logger := &testLogger{}
+	runner, err := replay.NewHTTPRunner(0, serveText(t, "app"), testDir, replay.WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := runner.Serve(); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-runner.Ready()
+	resp, err := http.Get("http://" + runner.Addr() + "/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	runner.Stop()
+	wg.Wait()
+
+	if args := logger.find("INFO recorded exchange"); args == nil || args["url"] != "/foo" || args["index"] != 0 {
+		t.Errorf("got logs %q, want recorded exchange", logger.entries)
+	}
+	if err := runner.Replay(false); err != nil {
+		t.Fatal(err)
+	}
+	if logger.find("DEBUG response matches recording") == nil {
+		t.Errorf("got logs %q, want matching response", logger.entries)
+	}
+}
@@ -0,0 +1,146 @@
+package replay
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// RecordMode is the way the record/replay server treats interactions with the dependency.
+type RecordMode int
+
+const (
+	// RecordNone replays recorded interactions only, same as record unset in NewHTTPServer.
+	RecordNone RecordMode = iota + 1
+	// RecordAll forwards every request to the dependency and overwrites the record file,
+	// same as record set in NewHTTPServer. As mode of a Route, it only replaces interactions matching
+	// requests of the route, other interactions are kept, see WithRoutes.
+	RecordAll
+	// RecordOnce records interactions if the record file doesn't exist or is empty, otherwise replays them.
+	RecordOnce
+	// RecordNewEpisodes replays recorded interactions and forwards requests that don't match any of them
+	// to the dependency, appending new interactions to the record file.
+	RecordNewEpisodes
+	// RecordPassthrough forwards every request to the dependency without recording anything.
+	RecordPassthrough
+)
+
+func (m RecordMode) String() string {
+	switch m {
+	case RecordNone:
+		return "none"
+	case RecordAll:
+		return "all"
+	case RecordOnce:
+		return "once"
+	case RecordNewEpisodes:
+		return "new-episodes"
+	case RecordPassthrough:
+		return "passthrough"
+	}
+	return fmt.Sprintf("RecordMode(%d)", int(m))
+}
+
+// WithRecordMode makes the record/replay server use mode instead of the one implied by record argument
+// of NewHTTPServer. Only RecordAll and RecordNewEpisodes require the dependency to be up.
+func WithRecordMode(mode RecordMode) Option {
+	return func(o *options) {
+		o.recordMode = mode
+	}
+}
+
+// resolveRecordMode returns the mode to run in for recordFile, RecordOnce is resolved to
+// either RecordAll or RecordNone.
+func resolveRecordMode(o *options, record bool, recordFile string) RecordMode {
+	mode := o.recordMode
+	if mode == 0 {
+		mode = RecordNone
+		if record {
+			mode = RecordAll
+		}
+	}
+	if mode == RecordOnce {
+		mode = RecordNone
+		if !recorded(recordFile) {
+			mode = RecordAll
+		}
+	}
+	return mode
+}
+
+// recorded reports whether file contains recorded interactions.
+func recorded(file string) bool {
+	fi, err := os.Stat(file)
+	return err == nil && fi.Size() > 0
+}
+
+var _ recorderOrReplayer = (*episodeRecorder)(nil)
+
+// episodeRecorder replays recorded interactions and records the ones that don't match, see RecordNewEpisodes.
+type episodeRecorder struct {
+	replayer *httpReplayer
+	recorder *httpRecorder
+}
+
+func newEpisodeRecorder(file string, rd *redactor, store *fileStore, o *options) (*episodeRecorder, error) {
+	lg := &httpLog{Version: logVersion}
+	if recorded(file) {
+		var err error
+		if lg, err = readLog(store, file); err != nil {
+			return nil, err
+		}
+	}
+	replayer, err := newLogReplayer(file, lg, rd, o)
+	if err != nil {
+		return nil, err
+	}
+	recorder := newHTTPRecorder(file, rd, store, o)
+	recorder.log = &httpLog{
+		Initial:   lg.Initial,
+		Version:   lg.Version,
+		Converter: lg.Converter,
+		Entries:   append([]*logEntry(nil), lg.Entries...),
+	}
+	return &episodeRecorder{
+		replayer: replayer,
+		recorder: recorder,
+	}, nil
+}
+
+func (r *episodeRecorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+func (r *episodeRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	lreq, err := convertRequest(req, r.replayer.redact)
+	if err != nil {
+		return nil, err
+	}
+	call := req.Method + " " + req.URL.RequestURI()
+	if e := r.replayer.match(lreq, call); e != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return e.Response.toHTTP(req)
+	}
+	r.recorder.logger.Info("no recorded interaction matches request, recording a new episode", "request", call)
+	return r.recorder.RoundTrip(req)
+}
+
+// Close writes recorded interactions followed by new ones. Interactions that weren't replayed are kept.
+func (r *episodeRecorder) Close() error {
+	return r.recorder.Close()
+}
+
+var _ recorderOrReplayer = passthrough{}
+
+// passthrough forwards requests to the dependency as is, see RecordPassthrough.
+type passthrough struct{}
+
+func (passthrough) Client() *http.Client {
+	return &http.Client{Transport: http.DefaultTransport}
+}
+
+func (passthrough) Close() error {
+	return nil
+}
@@ -0,0 +1,124 @@
+package replay_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/daulet/replay"
+)
+
+func TestRecordModes(t *testing.T) {
+	var calls atomic.Int32
+	remoteAddr := serveHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(r.URL.Path))
+	}))
+
+	run := func(t *testing.T, recordFile string, mode replay.RecordMode, opts []replay.Option, paths ...string) int {
+		t.Helper()
+		srv, err := replay.NewHTTPServer(0, false, remoteAddr, recordFile, append(opts, replay.WithRecordMode(mode))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := calls.Load()
+		getAll(t, srv, paths...)
+		if err := srv.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return int(calls.Load() - before)
+	}
+
+	t.Run("once", func(t *testing.T) {
+		recordFile := filepath.Join(t.TempDir(), "http.record")
+		if got := run(t, recordFile, replay.RecordOnce, nil, "/foo"); got != 1 {
+			t.Errorf("dependency called %d times, want 1", got)
+		}
+		if got := run(t, recordFile, replay.RecordOnce, nil, "/foo"); got != 0 {
+			t.Errorf("dependency called %d times, want 0", got)
+		}
+	})
+
+	t.Run("new episodes", func(t *testing.T) {
+		recordFile := filepath.Join(t.TempDir(), "http.record")
+		if got := run(t, recordFile, replay.RecordNewEpisodes, nil, "/foo"); got != 1 {
+			t.Errorf("dependency called %d times, want 1", got)
+		}
+		if got := run(t, recordFile, replay.RecordNewEpisodes, nil, "/foo", "/bar"); got != 1 {
+			t.Errorf("dependency called %d times, want 1", got)
+		}
+		// both interactions are replayed, the dependency isn't contacted
+		if got := run(t, recordFile, replay.RecordNone, []replay.Option{replay.WithStrict()}, "/bar", "/foo"); got != 0 {
+			t.Errorf("dependency called %d times, want 0", got)
+		}
+	})
+
+	t.Run("new episodes with blobs", func(t *testing.T) {
+		dir := t.TempDir()
+		recordFile := filepath.Join(dir, "http.record")
+		opts := []replay.Option{replay.WithBlobs(filepath.Join(dir, "blobs"), 1)}
+		if got := run(t, recordFile, replay.RecordNewEpisodes, opts, "/foo", "/bar"); got != 2 {
+			t.Errorf("dependency called %d times, want 2", got)
+		}
+		// hand edited fields of recorded interactions
+		var lg map[string]any
+		readJSON(t, recordFile, &lg)
+		entries := lg["Entries"].([]any)
+		foo, bar := entries[0].(map[string]any), entries[1].(map[string]any)
+		foo["Times"] = -1
+		bar["After"] = []any{foo["ID"]}
+		writeJSON(t, recordFile, lg)
+
+		if got := run(t, recordFile, replay.RecordNewEpisodes, opts, "/foo", "/bar", "/baz"); got != 1 {
+			t.Errorf("dependency called %d times, want 1", got)
+		}
+		readJSON(t, recordFile, &lg)
+		entries = lg["Entries"].([]any)
+		if len(entries) != 3 {
+			t.Fatalf("got %d interactions, want 3", len(entries))
+		}
+		foo, bar = entries[0].(map[string]any), entries[1].(map[string]any)
+		if foo["Times"] != float64(-1) {
+			t.Errorf("got Times %v, want -1", foo["Times"])
+		}
+		if after, _ := bar["After"].([]any); len(after) != 1 || after[0] != foo["ID"] {
+			t.Errorf("got After %v, want [%v]", bar["After"], foo["ID"])
+		}
+	})
+
+	t.Run("passthrough", func(t *testing.T) {
+		recordFile := filepath.Join(t.TempDir(), "http.record")
+		if got := run(t, recordFile, replay.RecordPassthrough, nil, "/foo", "/foo"); got != 2 {
+			t.Errorf("dependency called %d times, want 2", got)
+		}
+		if _, err := os.Stat(recordFile); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("record file exists: %v", err)
+		}
+	})
+}
+
+func readJSON(t *testing.T, file string, v any) {
+	t.Helper()
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeJSON(t *testing.T, file string, v any) {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
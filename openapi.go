@@ -0,0 +1,415 @@
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// openAPIMethods are operations of a path item, in the order test cases are generated in.
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+type openAPIDoc struct {
+	Servers []struct {
+		URL string `yaml:"url"`
+	} `yaml:"servers"`
+	Paths map[string]map[string]any `yaml:"paths"`
+}
+
+type openAPIOperation struct {
+	OperationID string             `yaml:"operationId"`
+	Parameters  []openAPIParameter `yaml:"parameters"`
+	RequestBody *struct {
+		Required bool                        `yaml:"required"`
+		Content  map[string]openAPIMediaType `yaml:"content"`
+	} `yaml:"requestBody"`
+}
+
+type openAPIParameter struct {
+	Name     string                    `yaml:"name"`
+	In       string                    `yaml:"in"`
+	Example  any                       `yaml:"example"`
+	Examples map[string]openAPIExample `yaml:"examples"`
+	Schema   *openAPISchema            `yaml:"schema"`
+}
+
+type openAPIMediaType struct {
+	Example  any                       `yaml:"example"`
+	Examples map[string]openAPIExample `yaml:"examples"`
+	Schema   *openAPISchema            `yaml:"schema"`
+}
+
+type openAPISchema struct {
+	Example any `yaml:"example"`
+	Default any `yaml:"default"`
+}
+
+type openAPIExample struct {
+	Value any `yaml:"value"`
+}
+
+// value returns the example of the parameter named name, or its default one.
+func (p *openAPIParameter) value(name string) (any, bool) {
+	if e, ok := p.Examples[name]; ok {
+		return e.Value, true
+	}
+	return exampleValue(p.Example, p.Schema)
+}
+
+func exampleValue(example any, schema *openAPISchema) (any, bool) {
+	switch {
+	case example != nil:
+		return example, true
+	case schema != nil && schema.Example != nil:
+		return schema.Example, true
+	case schema != nil && schema.Default != nil:
+		return schema.Default, true
+	}
+	return nil, false
+}
+
+// GenerateFromOpenAPI writes a runner test case into testdataDir for every request example of operations
+// in specFile, an OpenAPI 3 document in YAML or JSON, and returns their names. A test case is named after
+// the operation ID, followed by the name of the example if the request body or parameters have named examples.
+// Parameters without examples use the example or default of their schema, operations missing values of path
+// parameters or a required request body are skipped. Responses are populated by Replay in update mode.
+func GenerateFromOpenAPI(specFile, testdataDir string, opts ...Option) ([]string, error) {
+	raw, err := readOpenAPI(specFile)
+	if err != nil {
+		return nil, err
+	}
+	// resolve local references, so the document can be decoded as a tree
+	if raw, err = resolveRefs(raw, raw, 0); err != nil {
+		return nil, err
+	}
+	b, err := yaml.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var doc openAPIDoc
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI document: [%w]", err)
+	}
+	basePath := ""
+	if len(doc.Servers) > 0 {
+		if u, err := url.Parse(doc.Servers[0].URL); err == nil {
+			basePath = strings.TrimSuffix(u.Path, "/")
+		}
+	}
+
+	paths := make([]string, 0, len(doc.Paths))
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	cases := make(map[string][]*http.Request)
+	var names []string
+	for _, path := range paths {
+		item := doc.Paths[path]
+		var common []openAPIParameter
+		if err := decodeAs(item["parameters"], &common); err != nil {
+			return nil, fmt.Errorf("invalid parameters of %s: [%w]", path, err)
+		}
+		for _, method := range openAPIMethods {
+			if item[method] == nil {
+				continue
+			}
+			var op openAPIOperation
+			if err := decodeAs(item[method], &op); err != nil {
+				return nil, fmt.Errorf("invalid operation %s %s: [%w]", method, path, err)
+			}
+			op.Parameters = mergeParameters(common, op.Parameters)
+			name := op.OperationID
+			if name == "" {
+				name = method + " " + path
+			}
+			reqs, err := op.requests(method, basePath+path)
+			if err != nil {
+				return nil, fmt.Errorf("operation %s: [%w]", name, err)
+			}
+			for _, example := range sortedKeys(reqs) {
+				caseName := sanitizeName(name)
+				if example != "" {
+					caseName += "-" + sanitizeName(example)
+				}
+				if _, ok := cases[caseName]; ok {
+					return nil, fmt.Errorf("duplicate test case %q", caseName)
+				}
+				cases[caseName] = []*http.Request{reqs[example]}
+				names = append(names, caseName)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no request examples in %q", specFile)
+	}
+	o := newOptions(opts)
+	for _, name := range names {
+		if err := writeGenerated(filepath.Join(testdataDir, name), cases[name], o); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// readOpenAPI reads OpenAPI document in YAML or JSON as a generic tree with string keys.
+func readOpenAPI(specFile string) (any, error) {
+	b, err := os.ReadFile(specFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenAPI document: [%w]", err)
+	}
+	var raw any
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI document: [%w]", err)
+	}
+	return stringKeys(raw), nil
+}
+
+// stringKeys converts mappings with non-string keys, e.g. response status codes, to ones with string keys.
+func stringKeys(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, item := range v {
+			v[k] = stringKeys(item)
+		}
+		return v
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, item := range v {
+			m[fmt.Sprint(k)] = stringKeys(item)
+		}
+		return m
+	case []any:
+		for i, item := range v {
+			v[i] = stringKeys(item)
+		}
+	}
+	return v
+}
+
+// requests returns a request for every named example of the operation, keyed by the name,
+// or a single one keyed by empty name if there are no named examples.
+func (op *openAPIOperation) requests(method, path string) (map[string]*http.Request, error) {
+	var mediaType string
+	var media openAPIMediaType
+	if op.RequestBody != nil {
+		// prefer JSON, otherwise the first media type with an example
+		types := sortedKeys(op.RequestBody.Content)
+		sort.SliceStable(types, func(i, j int) bool { return isJSON(types[i]) && !isJSON(types[j]) })
+		for _, t := range types {
+			m := op.RequestBody.Content[t]
+			if _, ok := exampleValue(m.Example, m.Schema); ok || len(m.Examples) > 0 {
+				mediaType, media = t, m
+				break
+			}
+		}
+	}
+	examples := map[string]bool{}
+	for name := range media.Examples {
+		examples[name] = true
+	}
+	for _, p := range op.Parameters {
+		for name := range p.Examples {
+			examples[name] = true
+		}
+	}
+	if len(examples) == 0 {
+		examples[""] = true
+	}
+
+	reqs := make(map[string]*http.Request)
+	for example := range examples {
+		target, query, header := path, url.Values{}, make(http.Header)
+		complete := true
+		for _, p := range op.Parameters {
+			v, ok := p.value(example)
+			if !ok {
+				complete = complete && p.In != "path"
+				continue
+			}
+			values := parameterValues(v)
+			switch p.In {
+			case "path":
+				target = strings.ReplaceAll(target, "{"+p.Name+"}", url.PathEscape(strings.Join(values, ",")))
+			case "query":
+				query[p.Name] = values
+			case "header":
+				header.Set(p.Name, strings.Join(values, ","))
+			case "cookie":
+				header.Add("Cookie", p.Name+"="+strings.Join(values, ","))
+			}
+		}
+		var body string
+		hasBody := false
+		if mediaType != "" {
+			v, ok := media.Examples[example]
+			value := v.Value
+			if !ok {
+				value, ok = exampleValue(media.Example, media.Schema)
+			}
+			if ok {
+				var err error
+				if body, err = encodeExample(mediaType, value); err != nil {
+					return nil, err
+				}
+				hasBody = true
+			}
+		}
+		if !complete || op.RequestBody != nil && op.RequestBody.Required && !hasBody {
+			continue
+		}
+		u := &url.URL{Path: target, RawQuery: query.Encode()}
+		req, err := http.NewRequest(strings.ToUpper(method), u.String(), strings.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header = header
+		if hasBody {
+			req.Header.Set("Content-Type", mediaType)
+		}
+		reqs[example] = req
+	}
+	return reqs, nil
+}
+
+// mergeParameters returns parameters of the path item overridden by parameters of the operation.
+func mergeParameters(common, own []openAPIParameter) []openAPIParameter {
+	merged := append([]openAPIParameter(nil), own...)
+	for _, p := range common {
+		overridden := false
+		for _, o := range own {
+			overridden = overridden || o.Name == p.Name && o.In == p.In
+		}
+		if !overridden {
+			merged = append(merged, p)
+		}
+	}
+	return merged
+}
+
+func parameterValues(v any) []string {
+	if list, ok := v.([]any); ok {
+		values := make([]string, len(list))
+		for i, item := range list {
+			values[i] = fmt.Sprint(item)
+		}
+		return values
+	}
+	return []string{fmt.Sprint(v)}
+}
+
+// encodeExample encodes example value as a request body of mediaType.
+func encodeExample(mediaType string, value any) (string, error) {
+	if s, ok := value.(string); ok && !isJSON(mediaType) {
+		return s, nil
+	}
+	if m, ok := value.(map[string]any); ok && mediaType == "application/x-www-form-urlencoded" {
+		form := url.Values{}
+		for k, v := range m {
+			form[k] = parameterValues(v)
+		}
+		return form.Encode(), nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode example: [%w]", err)
+	}
+	return string(b), nil
+}
+
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// resolveRefs replaces local references, {"$ref": "#/components/..."}, in v with values they point to.
+func resolveRefs(root, v any, depth int) (any, error) {
+	if depth > 32 {
+		return nil, errors.New("references are nested too deep, or cyclic")
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		if ref, ok := v["$ref"].(string); ok {
+			target, err := lookupRef(root, ref)
+			if err != nil {
+				return nil, err
+			}
+			return resolveRefs(root, target, depth+1)
+		}
+		out := make(map[string]any, len(v))
+		for k, item := range v {
+			resolved, err := resolveRefs(root, item, depth)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = resolved
+		}
+		return out, nil
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			resolved, err := resolveRefs(root, item, depth)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = resolved
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+func lookupRef(root any, ref string) (any, error) {
+	path, ok := strings.CutPrefix(ref, "#/")
+	if !ok {
+		return nil, fmt.Errorf("reference %q isn't local", ref)
+	}
+	v := root
+	for _, key := range strings.Split(path, "/") {
+		key = strings.NewReplacer("~1", "/", "~0", "~").Replace(key)
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("reference %q not found", ref)
+		}
+		if v, ok = m[key]; !ok {
+			return nil, fmt.Errorf("reference %q not found", ref)
+		}
+	}
+	return v, nil
+}
+
+// decodeAs decodes generic YAML value v into out.
+func decodeAs(v, out any) error {
+	if v == nil {
+		return nil
+	}
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(b, out)
+}
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// sanitizeName turns s into a test case directory name.
+func sanitizeName(s string) string {
+	return strings.Trim(unsafeNameChars.ReplaceAllString(s, "-"), "-.")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,91 @@
+package replay_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/daulet/replay"
+)
+
+const petstore = `openapi: 3.0.3
+servers:
+  - url: https://petstore.example.com/v1
+paths:
+  /pets:
+    get:
+      operationId: listPets
+      parameters:
+        - name: limit
+          in: query
+          schema: {type: integer, default: 20}
+    post:
+      operationId: createPet
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema: {$ref: '#/components/schemas/Pet'}
+            examples:
+              cat: {value: {name: Tom, kind: cat}}
+              dog: {$ref: '#/components/examples/Dog'}
+  /pets/{petId}:
+    parameters:
+      - name: petId
+        in: path
+        required: true
+        example: 42
+    get:
+      operationId: showPet
+      parameters:
+        - name: X-Trace
+          in: header
+          example: abc
+    delete:
+      operationId: deletePet
+      parameters:
+        - name: petId
+          in: path
+          required: true
+          schema: {type: integer}
+components:
+  schemas:
+    Pet: {type: object}
+  examples:
+    Dog: {value: {name: Rex, kind: dog}}
+`
+
+func TestGenerateFromOpenAPI(t *testing.T) {
+	dir := t.TempDir()
+	spec := filepath.Join(dir, "openapi.yaml")
+	if err := os.WriteFile(spec, []byte(petstore), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	testdataDir := filepath.Join(dir, "testdata")
+	names, err := replay.GenerateFromOpenAPI(spec, testdataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// deletePet has no example of its path parameter
+	want := []string{"listPets", "createPet-cat", "createPet-dog", "showPet"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+	for name, want := range map[string][]string{
+		"listPets":      {"GET /v1/pets?limit=20 HTTP/1.1"},
+		"createPet-dog": {"POST /v1/pets HTTP/1.1", "Content-Type: application/json", `{"kind":"dog","name":"Rex"}`},
+		"showPet":       {"GET /v1/pets/42 HTTP/1.1", "X-Trace: abc"},
+	} {
+		b, err := os.ReadFile(filepath.Join(testdataDir, name, "request0.data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range want {
+			if !strings.Contains(string(b), w) {
+				t.Errorf("%s: request doesn't contain %q:\n%s", name, w, b)
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package replay
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Option configures optional behaviour of the runner and the record/replay server.
+type Option func(*options)
+
+type options struct {
+	listener        net.Listener
+	controlListener net.Listener
+	redaction       Redaction
+	keyEnv          string
+	blobDir         string
+	blobThreshold   int
+	strict          bool
+	order           Order
+	recordMode      RecordMode
+	routes          []Route
+	openAPI         string
+	fallback        http.Handler
+	reloadInterval  time.Duration
+	logger          Logger
+	actual          bool
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{logger: nopLogger{}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithListener serves on provided listener instead of listening on the port passed to the constructor,
+// e.g. to bind to an ephemeral port ahead of time. The listener is closed on shutdown.
+func WithListener(l net.Listener) Option {
+	return func(o *options) {
+		o.listener = l
+	}
+}
+
+// dialAddr returns an address clients can connect to, replacing unspecified host
+// (as in ":0" or "[::]:0") with localhost.
+func dialAddr(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || !tcpAddr.IP.IsUnspecified() {
+		return addr.String()
+	}
+	return net.JoinHostPort("localhost", strconv.Itoa(tcpAddr.Port))
+}
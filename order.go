@@ -0,0 +1,119 @@
+package replay
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// Order is the order the replaying server expects requests to arrive in.
+type Order int
+
+const (
+	// OrderAny accepts requests in any order, default.
+	OrderAny Order = iota
+	// OrderRecorded expects requests in the order they were recorded.
+	OrderRecorded
+	// OrderDeclared expects every interaction to be requested after interactions listed in its After,
+	// a partial order declared by editing the record file.
+	OrderDeclared
+)
+
+// WithOrder makes the replaying server check the order of requests. Requests arriving out of order
+// still get their recorded responses, and Close reports the difference from the expected order.
+func WithOrder(order Order) Option {
+	return func(o *options) {
+		o.order = order
+	}
+}
+
+// orderConstraints returns, for every entry, indexes of entries that have to be replayed before it.
+func orderConstraints(entries []*logEntry, order Order) ([][]int, error) {
+	before := make([][]int, len(entries))
+	switch order {
+	case OrderRecorded:
+		for i := 1; i < len(entries); i++ {
+			before[i] = []int{i - 1}
+		}
+	case OrderDeclared:
+		index := make(map[string]int, len(entries))
+		for i, e := range entries {
+			index[e.ID] = i
+		}
+		for i, e := range entries {
+			for _, id := range e.After {
+				j, ok := index[id]
+				if !ok {
+					return nil, fmt.Errorf("interaction %s is after unknown interaction %s", e.ID, id)
+				}
+				before[i] = append(before[i], j)
+			}
+		}
+	}
+	return before, nil
+}
+
+// done reports whether i-th entry has been replayed as many times as expected,
+// at least once if it can be replayed any number of times.
+func (r *httpReplayer) done(i int) bool {
+	times := r.entries[i].times()
+	if times < 0 {
+		times = 1
+	}
+	return r.uses[i] >= times
+}
+
+// inOrder reports whether every entry that has to be replayed before i-th entry is done.
+func (r *httpReplayer) inOrder(i int) bool {
+	for _, j := range r.before[i] {
+		if !r.done(j) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *httpReplayer) violate(i int, call string) {
+	var pending []string
+	for _, j := range r.before[i] {
+		if !r.done(j) {
+			pending = append(pending, describeEntry(r.entries[j]))
+		}
+	}
+	r.violations = append(r.violations, fmt.Sprintf("%s (%s) arrived before %s", call, r.entries[i].ID, strings.Join(pending, ", ")))
+}
+
+// orderError describes requests that arrived out of order, nil if there were none.
+func (r *httpReplayer) orderError() error {
+	if len(r.violations) == 0 {
+		return nil
+	}
+	if r.order == OrderRecorded {
+		var recorded []string
+		for _, e := range r.entries {
+			times := e.times()
+			if times < 0 {
+				times = 1
+			}
+			for k := 0; k < times; k++ {
+				recorded = append(recorded, fmt.Sprintf("%s %s", e.Request.Method, requestURI(e.Request.URL)))
+			}
+		}
+		return fmt.Errorf("requests out of recorded order: (-recorded +actual)\n%s", cmp.Diff(recorded, r.sequence))
+	}
+	var b strings.Builder
+	b.WriteString("requests out of declared order:\n")
+	for _, v := range r.violations {
+		fmt.Fprintf(&b, "  %s\n", v)
+	}
+	b.WriteString("actual order:\n")
+	for i, call := range r.sequence {
+		fmt.Fprintf(&b, "  %d. %s\n", i+1, call)
+	}
+	return fmt.Errorf("%s", strings.TrimSuffix(b.String(), "\n"))
+}
+
+func describeEntry(e *logEntry) string {
+	return fmt.Sprintf("%s %s (%s)", e.Request.Method, requestURI(e.Request.URL), e.ID)
+}
@@ -0,0 +1,106 @@
+package replay_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/daulet/replay"
+)
+
+func getAll(t *testing.T, srv *replay.HTTPServer, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		resp, err := http.Get(fmt.Sprintf("http://%s%s", srv.Addr(), path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: got %s", path, resp.Status)
+		}
+	}
+}
+
+func TestOrder(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "http.record")
+	remoteAddr := serveHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+
+	srv, err := replay.NewHTTPServer(0, true, remoteAddr, recordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getAll(t, srv, "/auth", "/fetch", "/cache")
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayInOrder := func(t *testing.T, order replay.Order, paths ...string) error {
+		t.Helper()
+		srv, err := replay.NewHTTPServer(0, false, remoteAddr, recordFile, replay.WithOrder(order))
+		if err != nil {
+			t.Fatal(err)
+		}
+		getAll(t, srv, paths...)
+		return srv.Close()
+	}
+
+	t.Run("recorded", func(t *testing.T) {
+		if err := replayInOrder(t, replay.OrderRecorded, "/auth", "/fetch", "/cache"); err != nil {
+			t.Fatal(err)
+		}
+		err := replayInOrder(t, replay.OrderRecorded, "/fetch", "/auth", "/cache")
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		for _, want := range []string{"(-recorded +actual)", `"GET /auth"`, `"GET /fetch"`} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error doesn't contain %q:\n%v", want, err)
+			}
+		}
+		// order isn't checked by default
+		if err := replayInOrder(t, replay.OrderAny, "/cache", "/fetch", "/auth"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("declared", func(t *testing.T) {
+		// cache is written after fetch, auth is independent
+		b, err := os.ReadFile(recordFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lg map[string]any
+		if err := json.Unmarshal(b, &lg); err != nil {
+			t.Fatal(err)
+		}
+		entries := lg["Entries"].([]any)
+		fetch, cache := entries[1].(map[string]any), entries[2].(map[string]any)
+		cache["After"] = []string{fetch["ID"].(string)}
+		if b, err = json.Marshal(lg); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(recordFile, b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := replayInOrder(t, replay.OrderDeclared, "/fetch", "/cache", "/auth"); err != nil {
+			t.Fatal(err)
+		}
+		err = replayInOrder(t, replay.OrderDeclared, "/auth", "/cache", "/fetch")
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		for _, want := range []string{"GET /cache (", "arrived before GET /fetch (", "2. GET /cache"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error doesn't contain %q:\n%v", want, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,323 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/daulet/replay/internal/atomicfile"
+)
+
+// pactSpecification is the version of the Pact specification of exported contracts.
+const pactSpecification = "2.0.0"
+
+// Pact is a consumer contract in Pact specification v2 format, see ExportPact.
+type Pact struct {
+	Consumer     PactParticipant   `json:"consumer"`
+	Provider     PactParticipant   `json:"provider"`
+	Interactions []PactInteraction `json:"interactions"`
+	Metadata     PactMetadata      `json:"metadata"`
+}
+
+// PactParticipant is the consumer or the provider of a Pact.
+type PactParticipant struct {
+	Name string `json:"name"`
+}
+
+// PactMetadata describes the format of a Pact.
+type PactMetadata struct {
+	PactSpecification PactVersion `json:"pactSpecification"`
+}
+
+// PactVersion is a version of the Pact specification.
+type PactVersion struct {
+	Version string `json:"version"`
+}
+
+// PactInteraction is a request of the consumer and the response it expects.
+type PactInteraction struct {
+	Description   string       `json:"description"`
+	ProviderState string       `json:"providerState,omitempty"`
+	Request       PactRequest  `json:"request"`
+	Response      PactResponse `json:"response"`
+}
+
+// PactRequest is a request of the consumer.
+type PactRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Query is the encoded query string.
+	Query   string            `json:"query,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body is a JSON document for JSON bodies, a JSON string otherwise.
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// PactResponse is a response the consumer expects.
+type PactResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body is a JSON document for JSON bodies, a JSON string otherwise.
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// ExportPact converts interactions recorded by HTTPServer into recordFile to a contract of consumer,
+// the application, with provider, the dependency. Requests keep recorded headers, responses only keep
+// Content-Type, since other headers, e.g. Date, aren't part of what the consumer expects.
+// Interactions with multipart requests or binary bodies, i.e. not valid UTF-8, aren't supported by the format.
+func ExportPact(recordFile, consumer, provider string, opts ...Option) (*Pact, error) {
+	store, err := newFileStore(newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	lg, err := readLog(store, recordFile)
+	if err != nil {
+		return nil, err
+	}
+	pact := &Pact{
+		Consumer:     PactParticipant{Name: consumer},
+		Provider:     PactParticipant{Name: provider},
+		Interactions: []PactInteraction{},
+		Metadata:     PactMetadata{PactSpecification: PactVersion{Version: pactSpecification}},
+	}
+	// descriptions identify interactions, so repeated requests are numbered
+	seen := map[string]int{}
+	for _, e := range lg.Entries {
+		if len(e.Request.BodyParts) > 1 {
+			return nil, fmt.Errorf("interaction %s %s %s has multipart body, which Pact doesn't support", e.ID, e.Request.Method, requestURI(e.Request.URL))
+		}
+		u, err := url.Parse(e.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse URL of interaction %s: [%w]", e.ID, err)
+		}
+		req := PactRequest{
+			Method:  e.Request.Method,
+			Path:    u.Path,
+			Query:   u.RawQuery,
+			Headers: pactHeaders(e.Request.Header),
+		}
+		if len(e.Request.BodyParts) == 1 && len(e.Request.BodyParts[0]) > 0 {
+			if e.Request.MediaType != "" {
+				if req.Headers == nil {
+					req.Headers = map[string]string{}
+				}
+				req.Headers["Content-Type"] = e.Request.MediaType
+			}
+			if !utf8.Valid(e.Request.BodyParts[0]) {
+				return nil, fmt.Errorf("interaction %s %s %s has binary request body, which Pact doesn't support", e.ID, e.Request.Method, requestURI(e.Request.URL))
+			}
+			req.Body = pactBody(e.Request.BodyParts[0])
+		}
+		resp := PactResponse{Status: e.Response.StatusCode}
+		if contentType := e.Response.Header.Get("Content-Type"); contentType != "" {
+			resp.Headers = map[string]string{"Content-Type": contentType}
+		}
+		if len(e.Response.Body) > 0 {
+			if !utf8.Valid(e.Response.Body) {
+				return nil, fmt.Errorf("interaction %s %s %s has binary response body, which Pact doesn't support", e.ID, e.Request.Method, requestURI(e.Request.URL))
+			}
+			resp.Body = pactBody(e.Response.Body)
+		}
+		description := fmt.Sprintf("%s %s", e.Request.Method, requestURI(e.Request.URL))
+		seen[description]++
+		if n := seen[description]; n > 1 {
+			description = fmt.Sprintf("%s (%d)", description, n)
+		}
+		pact.Interactions = append(pact.Interactions, PactInteraction{
+			Description: description,
+			Request:     req,
+			Response:    resp,
+		})
+	}
+	return pact, nil
+}
+
+// pactHeaders joins values of header, except ones the HTTP client sets itself.
+func pactHeaders(header http.Header) map[string]string {
+	var out map[string]string
+	for k, v := range header {
+		if k == "Accept-Encoding" || k == "User-Agent" {
+			continue
+		}
+		if out == nil {
+			out = map[string]string{}
+		}
+		out[k] = strings.Join(v, ", ")
+	}
+	return out
+}
+
+// pactBody encodes body as a JSON document if it is one, as a JSON string otherwise. Body must be valid UTF-8,
+// JSON strings can't hold arbitrary bytes.
+func pactBody(body []byte) json.RawMessage {
+	if json.Valid(body) {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, body); err == nil {
+			return buf.Bytes()
+		}
+	}
+	b, _ := json.Marshal(string(body))
+	return b
+}
+
+// WritePact writes pact into file as indented JSON.
+func WritePact(file string, pact *Pact) error {
+	b, err := json.MarshalIndent(pact, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode pact: [%w]", err)
+	}
+	if err := atomicfile.WriteFile(file, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write pact: [%w]", err)
+	}
+	return nil
+}
+
+// ReadPact reads a contract written by WritePact, or by other Pact v2 tooling.
+func ReadPact(file string) (*Pact, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pact: [%w]", err)
+	}
+	var pact Pact
+	if err := json.Unmarshal(b, &pact); err != nil {
+		return nil, fmt.Errorf("failed to parse pact %q: [%w]", file, err)
+	}
+	return &pact, nil
+}
+
+// VerifyPact sends requests of pact to the provider at providerAddr in order and checks its responses:
+// status must be equal, expected headers present with equal values, and bodies equal, except objects of JSON
+// bodies may have properties the consumer doesn't expect. Mismatches of all interactions are joined.
+func VerifyPact(pact *Pact, providerAddr string) error {
+	var errs []error
+	for _, in := range pact.Interactions {
+		if err := verifyInteraction(&in, providerAddr); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", in.Description, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func verifyInteraction(in *PactInteraction, providerAddr string) error {
+	u := &url.URL{Scheme: "http", Host: providerAddr, Path: in.Request.Path, RawQuery: in.Request.Query}
+	var body io.Reader
+	if len(in.Request.Body) > 0 {
+		body = bytes.NewReader(pactBodyBytes(in.Request.Body))
+	}
+	req, err := http.NewRequest(in.Request.Method, u.String(), body)
+	if err != nil {
+		return fmt.Errorf("failed to build request: [%w]", err)
+	}
+	for k, v := range in.Request.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: [%w]", err)
+	}
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: [%w]", err)
+	}
+
+	var mismatches []string
+	if resp.StatusCode != in.Response.Status {
+		mismatches = append(mismatches, fmt.Sprintf("got status %d, want %d", resp.StatusCode, in.Response.Status))
+	}
+	for _, k := range sortedKeys(in.Response.Headers) {
+		if got, want := strings.Join(resp.Header.Values(k), ", "), in.Response.Headers[k]; !headerValuesMatch(got, want) {
+			mismatches = append(mismatches, fmt.Sprintf("got header %s %q, want %q", k, got, want))
+		}
+	}
+	if len(in.Response.Body) > 0 {
+		mismatches = append(mismatches, pactBodyMismatches(in.Response.Body, got)...)
+	}
+	if len(mismatches) > 0 {
+		return errors.New(strings.Join(mismatches, "; "))
+	}
+	return nil
+}
+
+// headerValuesMatch compares header values ignoring whitespace after commas and semicolons, as Pact does.
+func headerValuesMatch(got, want string) bool {
+	normalize := func(s string) string {
+		s = strings.ReplaceAll(s, ", ", ",")
+		return strings.ReplaceAll(s, "; ", ";")
+	}
+	return normalize(got) == normalize(want)
+}
+
+// pactBodyBytes returns body as sent on the wire: contents of a JSON string, or the compacted JSON document,
+// since writing the pact indents it.
+func pactBodyBytes(body json.RawMessage) []byte {
+	var s string
+	if err := json.Unmarshal(body, &s); err == nil {
+		return []byte(s)
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, body); err != nil {
+		return body
+	}
+	return buf.Bytes()
+}
+
+// pactBodyMismatches compares response body got with the expected one.
+func pactBodyMismatches(want json.RawMessage, got []byte) []string {
+	var text string
+	if err := json.Unmarshal(want, &text); err == nil {
+		if string(got) != text {
+			return []string{fmt.Sprintf("got body %q, want %q", got, text)}
+		}
+		return nil
+	}
+	wantValue, _ := decodeJSON(want)
+	gotValue, ok := decodeJSON(got)
+	if !ok {
+		return []string{fmt.Sprintf("got body %q, want JSON %s", got, want)}
+	}
+	var paths []string
+	matchPactJSON(wantValue, gotValue, nil, &paths)
+	var mismatches []string
+	for _, p := range paths {
+		mismatches = append(mismatches, fmt.Sprintf("body %s differs", p))
+	}
+	return mismatches
+}
+
+// matchPactJSON collects paths of values in got that don't match want. Objects may have unexpected properties.
+func matchPactJSON(want, got any, path jsonPath, paths *[]string) {
+	switch want := want.(type) {
+	case map[string]any:
+		if got, ok := got.(map[string]any); ok {
+			for _, k := range sortedKeys(want) {
+				matchPactJSON(want[k], got[k], append(path[:len(path):len(path)], k), paths)
+			}
+			return
+		}
+	case []any:
+		if got, ok := got.([]any); ok && len(want) == len(got) {
+			for i := range want {
+				matchPactJSON(want[i], got[i], append(path[:len(path):len(path)], fmt.Sprint(i)), paths)
+			}
+			return
+		}
+	default:
+		if reflect.DeepEqual(want, got) {
+			return
+		}
+	}
+	if len(path) == 0 {
+		*paths = append(*paths, "$")
+		return
+	}
+	*paths = append(*paths, path.String())
+}
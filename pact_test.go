@@ -0,0 +1,136 @@
+package replay_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/daulet/replay"
+)
+
+func serveProvider(t *testing.T, item string) string {
+	return serveHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/orders/1":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id": 1, "item": "` + item + `", "tags": ["new"], "created": "today"}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/orders":
+			var order struct{ Item string }
+			json.NewDecoder(r.Body).Decode(&order)
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("created " + order.Item))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestPact(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "orders.record")
+	srv, err := replay.NewHTTPServer(0, true, serveProvider(t, "book"), recordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get("http://" + srv.Addr() + "/orders/1?expand=tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, err = http.Post("http://"+srv.Addr()+"/orders", "application/json", strings.NewReader(`{"item": "pen"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	pact, err := replay.ExportPact(recordFile, "shop", "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pactFile := filepath.Join(t.TempDir(), "pact.json")
+	if err := replay.WritePact(pactFile, pact); err != nil {
+		t.Fatal(err)
+	}
+	if pact, err = replay.ReadPact(pactFile); err != nil {
+		t.Fatal(err)
+	}
+	if len(pact.Interactions) != 2 {
+		t.Fatalf("got %d interactions, want 2", len(pact.Interactions))
+	}
+	get, post := pact.Interactions[0], pact.Interactions[1]
+	if get.Description != "GET /orders/1?expand=tags" || get.Request.Query != "expand=tags" {
+		t.Errorf("got interaction %+v", get)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(get.Response.Body, &body); err != nil || body["item"] != "book" {
+		t.Errorf("got response body %s, %v", get.Response.Body, err)
+	}
+	if post.Request.Headers["Content-Type"] != "application/json" || post.Response.Status != http.StatusCreated {
+		t.Errorf("got interaction %+v", post)
+	}
+
+	if err := replay.VerifyPact(pact, serveProvider(t, "book")); err != nil {
+		t.Errorf("verify against the same provider: %v", err)
+	}
+	err = replay.VerifyPact(pact, serveProvider(t, "pen"))
+	if err == nil || !strings.Contains(err.Error(), "GET /orders/1?expand=tags: body item differs") {
+		t.Errorf("got %v, want item mismatch", err)
+	}
+}
+
+func TestPactBinaryBody(t *testing.T) {
+	binary := []byte{0x89, 'P', 'N', 'G', 0xff, 0x00}
+	remoteAddr := serveHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if r.URL.Path == "/image" {
+			w.Write(binary)
+		}
+	}))
+	tests := []struct {
+		name string
+		send func(addr string) (*http.Response, error)
+		want string
+	}{
+		{
+			name: "request",
+			send: func(addr string) (*http.Response, error) {
+				return http.Post("http://"+addr+"/upload", "application/octet-stream", bytes.NewReader(binary))
+			},
+			want: "binary request body",
+		},
+		{
+			name: "response",
+			send: func(addr string) (*http.Response, error) {
+				return http.Get("http://" + addr + "/image")
+			},
+			want: "binary response body",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recordFile := filepath.Join(t.TempDir(), "files.record")
+			srv, err := replay.NewHTTPServer(0, true, remoteAddr, recordFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := test.send(srv.Addr())
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if err := srv.Close(); err != nil {
+				t.Fatal(err)
+			}
+			// a JSON string would replace invalid UTF-8 and verify against different bytes
+			if _, err := replay.ExportPact(recordFile, "app", "files"); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want error for %s", err, test.want)
+			}
+		})
+	}
+}
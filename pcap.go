@@ -0,0 +1,177 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/daulet/replay/internal/pcap"
+)
+
+// PCAPFilter selects HTTP connections imported from a packet capture by their server side.
+type PCAPFilter struct {
+	// Host is the IP address of the server, any if empty.
+	Host string
+	// Port of the server, any if zero.
+	Port int
+}
+
+// exchange is an HTTP request and its response reassembled from a capture.
+type exchange struct {
+	time time.Time
+	req  *http.Request
+	resp *http.Response
+}
+
+// ImportPCAP reassembles HTTP/1.1 exchanges from pcapFile, a capture in the classic pcap format as written by
+// tcpdump, and writes them as a runner test case into testDir, in order requests were captured in.
+// Incomplete exchanges, e.g. missing packets or the response, are skipped. Returns the number of exchanges written.
+func ImportPCAP(pcapFile string, filter PCAPFilter, testDir string, opts ...Option) (int, error) {
+	exs, err := readExchanges(pcapFile, filter)
+	if err != nil {
+		return 0, err
+	}
+	o := newOptions(opts)
+	store, err := newFileStore(o)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(testDir, 0o755); err != nil {
+		return 0, fmt.Errorf("failed to create test case directory: [%w]", err)
+	}
+	if err := clearRecording(testDir); err != nil {
+		return 0, err
+	}
+	h := &httpRunner{writeDir: testDir, redact: newRedactor(o.redaction), store: store, logger: o.logger}
+	for i, ex := range exs {
+		if err := h.writeRequest(testDir, i, ex.req); err != nil {
+			return 0, fmt.Errorf("failed to write request file: [%w]", err)
+		}
+		// remove Date header as it's not deterministic
+		ex.resp.Header.Del("Date")
+		rawResp, err := h.dumpResponse(ex.resp)
+		if err != nil {
+			return 0, err
+		}
+		if err := h.writeResponse(testDir, i, rawResp, false); err != nil {
+			return 0, fmt.Errorf("failed to write response file: [%w]", err)
+		}
+	}
+	return len(exs), nil
+}
+
+// ImportPCAPRecording is like ImportPCAP, but writes exchanges as a dependency recording into recordFile,
+// to be replayed by NewHTTPServer.
+func ImportPCAPRecording(pcapFile string, filter PCAPFilter, recordFile string, opts ...Option) (int, error) {
+	exs, err := readExchanges(pcapFile, filter)
+	if err != nil {
+		return 0, err
+	}
+	o := newOptions(opts)
+	store, err := newFileStore(o)
+	if err != nil {
+		return 0, err
+	}
+	rd := newRedactor(o.redaction)
+	lg := &httpLog{Version: logVersion}
+	for _, ex := range exs {
+		lreq, err := convertRequest(ex.req, rd)
+		if err != nil {
+			return 0, err
+		}
+		lresp, err := convertResponse(ex.resp, rd)
+		if err != nil {
+			return 0, err
+		}
+		lg.Entries = append(lg.Entries, &logEntry{ID: entryID(lreq, len(lg.Entries)), Request: lreq, Response: lresp})
+	}
+	if err := writeLog(store, recordFile, lg); err != nil {
+		return 0, err
+	}
+	return len(exs), nil
+}
+
+// readExchanges reads HTTP exchanges of connections matching filter, ordered by the time of request.
+func readExchanges(pcapFile string, filter PCAPFilter) ([]*exchange, error) {
+	var host netip.Addr
+	if filter.Host != "" {
+		var err error
+		if host, err = netip.ParseAddr(filter.Host); err != nil {
+			return nil, fmt.Errorf("invalid host filter %q: [%w]", filter.Host, err)
+		}
+	}
+	f, err := os.Open(pcapFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open capture: [%w]", err)
+	}
+	defer f.Close()
+	flows, err := pcap.Read(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read capture %q: [%w]", pcapFile, err)
+	}
+	var exs []*exchange
+	for _, flow := range flows {
+		if host.IsValid() && flow.Server.Addr().Unmap() != host.Unmap() {
+			continue
+		}
+		if filter.Port != 0 && int(flow.Server.Port()) != filter.Port {
+			continue
+		}
+		exs = append(exs, flowExchanges(flow)...)
+	}
+	if len(exs) == 0 {
+		return nil, fmt.Errorf("no HTTP exchanges matching filter in %q", pcapFile)
+	}
+	sort.SliceStable(exs, func(i, j int) bool { return exs[i].time.Before(exs[j].time) })
+	return exs, nil
+}
+
+// flowExchanges parses HTTP/1.1 requests and responses sent over flow, up to the first incomplete one.
+func flowExchanges(flow *pcap.Flow) []*exchange {
+	reqData := bytes.NewReader(flow.ToServer.Data)
+	reqs := bufio.NewReader(reqData)
+	resps := bufio.NewReader(bytes.NewReader(flow.ToClient.Data))
+	var exs []*exchange
+	for {
+		offset := len(flow.ToServer.Data) - reqData.Len() - reqs.Buffered()
+		req, err := http.ReadRequest(reqs)
+		if err != nil {
+			return exs
+		}
+		if _, err := snapshotBody(&req.Body); err != nil {
+			return exs
+		}
+		req.URL.Scheme, req.URL.Host = "http", flow.Server.String()
+		resp, err := readFinalResponse(resps, req)
+		if err != nil {
+			return exs
+		}
+		if _, err := snapshotBody(&resp.Body); err != nil {
+			return exs
+		}
+		exs = append(exs, &exchange{time: flow.ToServer.TimeAt(offset), req: req, resp: resp})
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			// the rest of the connection isn't HTTP
+			return exs
+		}
+	}
+}
+
+// readFinalResponse reads response to req, skipping informational responses, e.g. 100 Continue.
+func readFinalResponse(r *bufio.Reader, req *http.Request) (*http.Response, error) {
+	for {
+		resp, err := http.ReadResponse(r, req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 200 || resp.StatusCode == http.StatusSwitchingProtocols {
+			return resp, nil
+		}
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,109 @@
+package replay_test
+
+import (
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/daulet/replay"
+	"github.com/daulet/replay/internal/pcap"
+)
+
+// writeCapture writes a capture of HTTP exchanges, each over its own connection, to a temporary file.
+func writeCapture(t *testing.T, server netip.AddrPort, exchanges ...[2]string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "capture.pcap")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := pcap.NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	write := func(src, dst netip.AddrPort, seq uint32, flags uint8, payload string) {
+		ts = ts.Add(time.Millisecond)
+		if err := w.WriteSegment(ts, src, dst, seq, flags, []byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, ex := range exchanges {
+		client := netip.AddrPortFrom(netip.MustParseAddr("10.0.0.1"), uint16(50000+i))
+		req, resp := ex[0], ex[1]
+		write(client, server, 0, pcap.FlagSYN, "")
+		write(server, client, 0, pcap.FlagSYN|pcap.FlagACK, "")
+		// request spans two segments
+		write(client, server, 1, pcap.FlagACK, req[:len(req)/2])
+		write(client, server, uint32(1+len(req)/2), pcap.FlagACK, req[len(req)/2:])
+		write(server, client, 1, pcap.FlagACK, resp)
+		write(client, server, uint32(1+len(req)), pcap.FlagFIN|pcap.FlagACK, "")
+	}
+	return name
+}
+
+func TestImportPCAP(t *testing.T) {
+	server := netip.MustParseAddrPort("10.0.0.2:8080")
+	capture := writeCapture(t, server,
+		// as sent by Go client through the record/replay server, since headers are matched
+		[2]string{
+			"GET /foo HTTP/1.1\r\nHost: app\r\nUser-Agent: Go-http-client/1.1\r\nAccept-Encoding: gzip\r\n\r\n",
+			"HTTP/1.1 200 OK\r\nContent-Length: 3\r\nContent-Type: text/plain; charset=utf-8\r\nDate: Tue, 02 Jan 2024 03:04:05 GMT\r\n\r\nfoo",
+		},
+		// response is missing
+		[2]string{"GET /foo HTTP/1.1\r\nHost: app\r\n\r\n", ""},
+	)
+
+	t.Run("test case", func(t *testing.T) {
+		testDir := filepath.Join(t.TempDir(), "imported")
+		n, err := replay.ImportPCAP(capture, replay.PCAPFilter{Host: "10.0.0.2", Port: 8080}, testDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Fatalf("imported %d exchanges, want 1", n)
+		}
+		runner, err := replay.NewHTTPRunner(0, serve(t), testDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := runner.Replay(false); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("recording", func(t *testing.T) {
+		recordFile := filepath.Join(t.TempDir(), "http.record")
+		if _, err := replay.ImportPCAPRecording(capture, replay.PCAPFilter{Port: 8080}, recordFile); err != nil {
+			t.Fatal(err)
+		}
+		srv, err := replay.NewHTTPServer(0, false, "localhost:1", recordFile, replay.WithStrict())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Get("http://" + srv.Addr() + "/foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "foo" {
+			t.Errorf("got %q, want %q", body, "foo")
+		}
+		if err := srv.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if _, err := replay.ImportPCAP(capture, replay.PCAPFilter{Port: 5432}, t.TempDir()); err == nil {
+		t.Error("expected no exchanges on other port")
+	}
+}
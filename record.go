@@ -0,0 +1,644 @@
+package replay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// logVersion is the version of the record file format, compatible with recordings made by
+// github.com/google/go-replayers/httpreplay. Recording and replaying is implemented here rather than
+// with httpreplay, since it only scrubs a fixed set of request headers and fields, while redaction needs
+// to rewrite recorded responses as well, and replay needs control over matching and served responses.
+// Recordings made by httpreplay are replayed as is, its converter settings are kept but not applied.
+const logVersion = "0.2"
+
+// httpLog is a record of HTTP interactions with a dependency.
+type httpLog struct {
+	Initial   []byte
+	Version   string
+	Converter json.RawMessage `json:",omitempty"`
+	Entries   []*logEntry
+}
+
+// logEntry is a single request-response pair.
+type logEntry struct {
+	ID       string
+	Request  *logRequest
+	Response *logResponse
+	// Times is the number of times the interaction is replayed: once if zero, any number of times if negative.
+	// Edit it in the record file for calls repeated nondeterministically, e.g. polling.
+	Times int `json:",omitempty"`
+	// After lists IDs of interactions that must be replayed before this one, see OrderDeclared.
+	After []string `json:",omitempty"`
+}
+
+type logRequest struct {
+	Method string
+	URL    string
+	Header http.Header
+	// MediaType is the media type part of the Content-Type header. Multipart bodies are split into parts,
+	// since boundaries are generated randomly and can't be compared.
+	MediaType string
+	BodyParts [][]byte
+	// BodyBlobs reference body parts stored as blobs by index, empty for parts stored inline.
+	BodyBlobs []string    `json:",omitempty"`
+	Trailer   http.Header `json:",omitempty"`
+}
+
+type logResponse struct {
+	StatusCode int
+	Proto      string
+	ProtoMajor int
+	ProtoMinor int
+	Header     http.Header
+	Body       []byte
+	// BodyBlob references body stored as a blob.
+	BodyBlob string      `json:",omitempty"`
+	Trailer  http.Header `json:",omitempty"`
+}
+
+// MarshalJSON stores text body parts as strings, so records are reviewable.
+func (lr *logRequest) MarshalJSON() ([]byte, error) {
+	type plain logRequest
+	out := struct {
+		*plain
+		BodyParts [][]byte `json:",omitempty"`
+		BodyText  []string `json:",omitempty"`
+	}{plain: (*plain)(lr)}
+	contentType := lr.MediaType
+	if len(lr.BodyParts) > 1 {
+		// parts of multipart body
+		contentType = ""
+	}
+	for _, part := range lr.BodyParts {
+		if !isText(contentType, part) {
+			out.BodyParts = lr.BodyParts
+			return json.Marshal(out)
+		}
+	}
+	for _, part := range lr.BodyParts {
+		out.BodyText = append(out.BodyText, string(part))
+	}
+	return json.Marshal(out)
+}
+
+func (lr *logRequest) UnmarshalJSON(b []byte) error {
+	type plain logRequest
+	in := struct {
+		*plain
+		BodyText []string
+	}{plain: (*plain)(lr)}
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	for _, part := range in.BodyText {
+		lr.BodyParts = append(lr.BodyParts, []byte(part))
+	}
+	return nil
+}
+
+// MarshalJSON stores text body as string, so records are reviewable.
+func (lr *logResponse) MarshalJSON() ([]byte, error) {
+	type plain logResponse
+	out := struct {
+		*plain
+		Body     []byte  `json:",omitempty"`
+		BodyText *string `json:",omitempty"`
+	}{plain: (*plain)(lr)}
+	if lr.BodyBlob != "" {
+		return json.Marshal(out)
+	}
+	if isText(lr.Header.Get("Content-Type"), lr.Body) {
+		text := string(lr.Body)
+		out.BodyText = &text
+	} else {
+		out.Body = lr.Body
+	}
+	return json.Marshal(out)
+}
+
+func (lr *logResponse) UnmarshalJSON(b []byte) error {
+	type plain logResponse
+	in := struct {
+		*plain
+		BodyText *string
+	}{plain: (*plain)(lr)}
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	if in.BodyText != nil {
+		lr.Body = []byte(*in.BodyText)
+	}
+	return nil
+}
+
+func readLog(store *fileStore, filename string) (*httpLog, error) {
+	b, err := store.readFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var lg httpLog
+	if err := json.Unmarshal(b, &lg); err != nil {
+		return nil, fmt.Errorf("failed to parse %q: [%w]", filename, err)
+	}
+	if lg.Version != logVersion {
+		return nil, fmt.Errorf("unsupported version %q of %q, re-record it", lg.Version, filename)
+	}
+	for _, e := range lg.Entries {
+		if e.Request == nil || e.Response == nil {
+			return nil, fmt.Errorf("entry %s of %q is missing request or response", e.ID, filename)
+		}
+		for i, ref := range e.Request.BodyBlobs {
+			if ref == "" || i >= len(e.Request.BodyParts) {
+				continue
+			}
+			if e.Request.BodyParts[i], err = store.readBlob(ref); err != nil {
+				return nil, fmt.Errorf("entry %s of %q: %w", e.ID, filename, err)
+			}
+		}
+		if ref := e.Response.BodyBlob; ref != "" {
+			if e.Response.Body, err = store.readBlob(ref); err != nil {
+				return nil, fmt.Errorf("entry %s of %q: %w", e.ID, filename, err)
+			}
+		}
+		e.Request.BodyBlobs, e.Response.BodyBlob = nil, ""
+	}
+	return &lg, nil
+}
+
+func writeLog(store *fileStore, filename string, lg *httpLog) error {
+	lg, err := storeLogBlobs(store, lg)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(lg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode record: [%w]", err)
+	}
+	if err := store.writeFile(filename, b); err != nil {
+		return fmt.Errorf("failed to write record file: [%w]", err)
+	}
+	return nil
+}
+
+// storeLogBlobs returns a copy of lg with bodies above the threshold stored as blobs.
+func storeLogBlobs(store *fileStore, lg *httpLog) (*httpLog, error) {
+	if store.blobDir == "" {
+		return lg, nil
+	}
+	out := *lg
+	out.Entries = make([]*logEntry, len(lg.Entries))
+	for i, e := range lg.Entries {
+		if e.Response == nil {
+			// still in flight
+			out.Entries[i] = e
+			continue
+		}
+		req, resp := *e.Request, *e.Response
+		req.BodyParts = append([][]byte(nil), e.Request.BodyParts...)
+		var stored bool
+		for j, part := range e.Request.BodyParts {
+			ref, err := store.storeBlob(part)
+			if err != nil {
+				return nil, err
+			}
+			if ref == "" {
+				continue
+			}
+			if !stored {
+				req.BodyBlobs = make([]string, len(req.BodyParts))
+				stored = true
+			}
+			req.BodyParts[j], req.BodyBlobs[j] = []byte{}, ref
+		}
+		ref, err := store.storeBlob(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if ref != "" {
+			resp.Body, resp.BodyBlob = nil, ref
+		}
+		// keep fields edited by hand, e.g. Times and After
+		ne := *e
+		ne.Request, ne.Response = &req, &resp
+		out.Entries[i] = &ne
+	}
+	return &out, nil
+}
+
+// ignoredRequestHeaders are neither recorded nor matched on replay,
+// because they are secret, hop-by-hop or differ from run to run.
+var ignoredRequestHeaders = regexp.MustCompile(`^(Authorization|Proxy-Authorization|Connection|Content-Length|Content-Type|Date|Host|Transfer-Encoding|Via|X-Forwarded-.*)$`)
+
+func convertRequest(req *http.Request, rd *redactor) (*logRequest, error) {
+	req, err := decodeRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode request: [%w]", err)
+	}
+	req, err = rd.request(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to redact request: [%w]", err)
+	}
+	body, err := snapshotBody(&req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: [%w]", err)
+	}
+	if body == nil {
+		body = []byte{}
+	}
+	mediaType, parts, err := parseRequestBody(req.Header.Get("Content-Type"), body)
+	if err != nil {
+		return nil, err
+	}
+	return &logRequest{
+		Method:    req.Method,
+		URL:       req.URL.String(),
+		Header:    removeHeaders(req.Header, ignoredRequestHeaders),
+		MediaType: mediaType,
+		BodyParts: parts,
+		Trailer:   removeHeaders(req.Trailer, ignoredRequestHeaders),
+	}, nil
+}
+
+// parseRequestBody splits multipart body into parts, other bodies are a single part.
+func parseRequestBody(contentType string, body []byte) (string, [][]byte, error) {
+	if contentType == "" {
+		return "", [][]byte{body}, nil
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse content type %q: [%w]", contentType, err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return mediaType, [][]byte{body}, nil
+	}
+	var parts [][]byte
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read multipart body: [%w]", err)
+		}
+		part, err := io.ReadAll(p)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read multipart body: [%w]", err)
+		}
+		parts = append(parts, part)
+	}
+	return mediaType, parts, nil
+}
+
+func convertResponse(resp *http.Response, rd *redactor) (*logResponse, error) {
+	resp, err := decodeResponse(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode response: [%w]", err)
+	}
+	resp, err = rd.response(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to redact response: [%w]", err)
+	}
+	body, err := snapshotBody(&resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: [%w]", err)
+	}
+	if body == nil {
+		body = []byte{}
+	}
+	return &logResponse{
+		StatusCode: resp.StatusCode,
+		Proto:      resp.Proto,
+		ProtoMajor: resp.ProtoMajor,
+		ProtoMinor: resp.ProtoMinor,
+		Header:     resp.Header,
+		Body:       body,
+		Trailer:    resp.Trailer,
+	}, nil
+}
+
+// toHTTP builds response to req, encoding body as recorded if req accepts it.
+func (lr *logResponse) toHTTP(req *http.Request) (*http.Response, error) {
+	header, body, err := encodeContent(lr.Header, lr.Body, req.Header.Get("Accept-Encoding"))
+	if err != nil {
+		return nil, err
+	}
+	resp := &http.Response{
+		StatusCode:    lr.StatusCode,
+		Status:        fmt.Sprintf("%d %s", lr.StatusCode, http.StatusText(lr.StatusCode)),
+		Proto:         lr.Proto,
+		ProtoMajor:    lr.ProtoMajor,
+		ProtoMinor:    lr.ProtoMinor,
+		Header:        header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Trailer:       lr.Trailer.Clone(),
+		Request:       req,
+	}
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+	// For HEAD, set ContentLength to the value of the Content-Length header, or -1 if there isn't one.
+	if req.Method == http.MethodHead {
+		resp.ContentLength = -1
+		if c, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err == nil {
+			resp.ContentLength = c
+		}
+	}
+	return resp, nil
+}
+
+func removeHeaders(h http.Header, re *regexp.Regexp) http.Header {
+	h2 := http.Header{}
+	for k, v := range h {
+		if re.MatchString(k) {
+			continue
+		}
+		h2[k] = v
+	}
+	return h2
+}
+
+// entryID is derived from the request, so re-recording the same interactions yields the same file.
+func entryID(req *logRequest, seq int) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d %s %s\n", seq, req.Method, req.URL)
+	for _, part := range req.BodyParts {
+		h.Write(part)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+var _ recorderOrReplayer = (*httpRecorder)(nil)
+
+// httpRecorder forwards requests to the dependency and records interactions, written to file on Close.
+type httpRecorder struct {
+	file      string
+	redact    *redactor
+	store     *fileStore
+	logger    Logger
+	transport http.RoundTripper
+
+	mux sync.Mutex
+	log *httpLog
+}
+
+func newHTTPRecorder(file string, rd *redactor, store *fileStore, o *options) *httpRecorder {
+	return &httpRecorder{
+		file:      file,
+		redact:    rd,
+		store:     store,
+		logger:    o.logger,
+		transport: http.DefaultTransport,
+		log:       &httpLog{Version: logVersion},
+	}
+}
+
+func (r *httpRecorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+func (r *httpRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	lreq, err := convertRequest(req, r.redact)
+	if err != nil {
+		return nil, err
+	}
+	r.mux.Lock()
+	entry := &logEntry{ID: entryID(lreq, len(r.log.Entries)), Request: lreq}
+	r.log.Entries = append(r.log.Entries, entry)
+	r.mux.Unlock()
+
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		r.logger.Warn("dependency request failed, interaction isn't recorded", "id", entry.ID, "method", req.Method, "url", req.URL.String(), "error", err)
+		r.drop(entry)
+		return nil, err
+	}
+	lresp, err := convertResponse(resp, r.redact)
+	if err != nil {
+		r.logger.Error("failed to convert response, interaction isn't recorded", "id", entry.ID, "method", req.Method, "url", req.URL.String(), "error", err)
+		r.drop(entry)
+		resp.Body.Close()
+		return nil, err
+	}
+	r.mux.Lock()
+	entry.Response = lresp
+	r.mux.Unlock()
+	r.logger.Debug("recorded interaction", "id", entry.ID, "method", req.Method, "url", req.URL.String(), "status", resp.StatusCode)
+	return resp, nil
+}
+
+// drop removes entry that failed to get a response, so the log stays replayable.
+func (r *httpRecorder) drop(entry *logEntry) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for i, e := range r.log.Entries {
+		if e == entry {
+			r.log.Entries = append(r.log.Entries[:i], r.log.Entries[i+1:]...)
+			return
+		}
+	}
+}
+
+func (r *httpRecorder) Close() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if err := writeLog(r.store, r.file, r.log); err != nil {
+		r.logger.Error("failed to write recording", "file", r.file, "error", err)
+		return err
+	}
+	r.logger.Info("wrote recording", "file", r.file, "interactions", len(r.log.Entries))
+	return nil
+}
+
+// WithStrict makes the record/replay server fail on Close, when replaying, if the application
+// made requests that don't match any recorded interaction, or didn't make recorded requests
+// as many times as they were recorded, see logEntry.Times.
+func WithStrict() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+var _ recorderOrReplayer = (*httpReplayer)(nil)
+
+// httpReplayer responds with recorded responses, each recorded interaction is used as many times as specified.
+type httpReplayer struct {
+	redact *redactor
+	logger Logger
+	strict bool
+	order  Order
+	// before lists indexes of entries that must be used before the entry, by index
+	before [][]int
+
+	mux        sync.Mutex
+	entries    []*logEntry
+	uses       []int
+	unmatched  []string
+	sequence   []string
+	violations []string
+}
+
+func newHTTPReplayer(file string, rd *redactor, store *fileStore, o *options) (*httpReplayer, error) {
+	lg, err := readLog(store, file)
+	if err != nil {
+		return nil, err
+	}
+	return newLogReplayer(file, lg, rd, o)
+}
+
+func newLogReplayer(file string, lg *httpLog, rd *redactor, o *options) (*httpReplayer, error) {
+	before, err := orderConstraints(lg.Entries, o.order)
+	if err != nil {
+		return nil, fmt.Errorf("invalid order of %q: [%w]", file, err)
+	}
+	return &httpReplayer{
+		redact:  rd,
+		logger:  o.logger,
+		strict:  o.strict,
+		order:   o.order,
+		before:  before,
+		entries: lg.Entries,
+		uses:    make([]int, len(lg.Entries)),
+	}, nil
+}
+
+// times returns the number of times e is expected to be used, negative for any number of times.
+func (e *logEntry) times() int {
+	if e.Times == 0 {
+		return 1
+	}
+	return e.Times
+}
+
+func (r *httpReplayer) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+func (r *httpReplayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	lreq, err := convertRequest(req, r.redact)
+	if err != nil {
+		return nil, err
+	}
+	call := fmt.Sprintf("%s %s", req.Method, req.URL.RequestURI())
+	e := r.match(lreq, call)
+	if e == nil {
+		r.logger.Warn("no recorded interaction matches request", "request", call)
+		r.mux.Lock()
+		r.unmatched = append(r.unmatched, call)
+		r.mux.Unlock()
+		return nil, fmt.Errorf("no recorded interaction matches %s %s", req.Method, req.URL)
+	}
+	return e.Response.toHTTP(req)
+}
+
+// match finds recorded interaction for lreq and marks it used, nil if there is none.
+func (r *httpReplayer) match(lreq *logRequest, call string) *logEntry {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.sequence = append(r.sequence, call)
+	// prefer interactions whose turn it is, but still respond out of order, so the violation is reported on Close
+	match := -1
+	for i, e := range r.entries {
+		if times := e.times(); times >= 0 && r.uses[i] >= times || !requestsMatch(lreq, e.Request) {
+			continue
+		}
+		if r.inOrder(i) {
+			match = i
+			break
+		}
+		if match < 0 {
+			match = i
+		}
+	}
+	if match < 0 {
+		return nil
+	}
+	if !r.inOrder(match) {
+		r.violate(match, call)
+		r.logger.Warn("request arrived out of order", "request", call, "id", r.entries[match].ID)
+	}
+	r.uses[match]++
+	r.logger.Debug("matched recorded interaction", "request", call, "id", r.entries[match].ID, "uses", r.uses[match])
+	return r.entries[match]
+}
+
+// Close reports requests out of order, if order is enforced, and unmatched requests and unused interactions
+// in strict mode.
+func (r *httpReplayer) Close() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	var errs []error
+	if err := r.orderError(); err != nil {
+		errs = append(errs, err)
+	}
+	if !r.strict {
+		return errors.Join(errs...)
+	}
+	for _, req := range r.unmatched {
+		errs = append(errs, fmt.Errorf("unexpected request %s", req))
+	}
+	for i, e := range r.entries {
+		if times := e.times(); times >= 0 && r.uses[i] < times {
+			errs = append(errs, fmt.Errorf("interaction %s %s %s used %d of %d times", e.ID, e.Request.Method, requestURI(e.Request.URL), r.uses[i], times))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// requestsMatch reports whether incoming request matches recorded one. Only path and query of URL are compared,
+// so recordings don't depend on the address of the dependency.
+func requestsMatch(in, rec *logRequest) bool {
+	if in.Method != rec.Method || in.MediaType != rec.MediaType || len(in.BodyParts) != len(rec.BodyParts) {
+		return false
+	}
+	if requestURI(in.URL) != requestURI(rec.URL) {
+		return false
+	}
+	for i := range in.BodyParts {
+		if !bytes.Equal(in.BodyParts[i], rec.BodyParts[i]) {
+			return false
+		}
+	}
+	return reflect.DeepEqual(matchedHeader(in.Header), matchedHeader(rec.Header))
+}
+
+// matchedHeader returns request header h as matched on replay. Referer, set by clients that follow
+// redirects, only keeps path and query, like URL of the request.
+func matchedHeader(h http.Header) http.Header {
+	h = removeHeaders(h, ignoredRequestHeaders)
+	if refs := h.Values("Referer"); len(refs) > 0 {
+		uris := make([]string, len(refs))
+		for i, ref := range refs {
+			uris[i] = requestURI(ref)
+		}
+		h["Referer"] = uris
+	}
+	return h
+}
+
+func requestURI(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.RequestURI()
+}
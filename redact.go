@@ -0,0 +1,344 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RedactedPlaceholder replaces redacted values in recordings. It is stable, so requests
+// redacted on replay match requests redacted on recording.
+const RedactedPlaceholder = "REDACTED"
+
+// Redaction describes secrets that are replaced with RedactedPlaceholder before recordings hit disk.
+// The same rules are applied to live traffic on replay, so recordings still match.
+type Redaction struct {
+	// Headers are names of request and response headers, case insensitive.
+	Headers []string
+	// QueryParams are names of URL query parameters.
+	QueryParams []string
+	// JSONPaths are dot separated paths into JSON request and response bodies, e.g. "user.token".
+	// Element "*" matches any object key or array index, e.g. "items.*.secret".
+	JSONPaths []string
+	// Patterns are replaced wherever they match in URL, header values and bodies.
+	// If a pattern has capture groups, only the groups are replaced, e.g. `api_key=(\w+)`.
+	Patterns []*regexp.Regexp
+	// Env maps redacted request headers, query parameters and JSON paths, as listed above, to environment
+	// variables holding their real values, e.g. {"Authorization": "APP_TOKEN"}. On replay, the runner sends
+	// them to the application instead of RedactedPlaceholder, so test cases of authenticated endpoints pass.
+	// Values redacted by Patterns can't be restored.
+	Env map[string]string
+}
+
+// WithRedaction redacts secrets from recordings, see Redaction. Multiple rule sets are combined.
+func WithRedaction(r Redaction) Option {
+	return func(o *options) {
+		o.redaction.Headers = append(o.redaction.Headers, r.Headers...)
+		o.redaction.QueryParams = append(o.redaction.QueryParams, r.QueryParams...)
+		o.redaction.JSONPaths = append(o.redaction.JSONPaths, r.JSONPaths...)
+		o.redaction.Patterns = append(o.redaction.Patterns, r.Patterns...)
+		for k, v := range r.Env {
+			if o.redaction.Env == nil {
+				o.redaction.Env = map[string]string{}
+			}
+			o.redaction.Env[k] = v
+		}
+	}
+}
+
+type redactor struct {
+	headers map[string]bool
+	params  map[string]bool
+	paths   []jsonPath
+	// pathNames are paths as configured, by index of paths
+	pathNames []string
+	patterns  []*regexp.Regexp
+	env       map[string]string
+}
+
+func newRedactor(r Redaction) *redactor {
+	rd := &redactor{
+		headers:  map[string]bool{},
+		params:   map[string]bool{},
+		patterns: r.Patterns,
+		env:      r.Env,
+	}
+	for _, h := range r.Headers {
+		rd.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, p := range r.QueryParams {
+		rd.params[p] = true
+	}
+	for _, p := range r.JSONPaths {
+		rd.paths = append(rd.paths, parseJSONPath(p))
+		rd.pathNames = append(rd.pathNames, p)
+	}
+	return rd
+}
+
+func (rd *redactor) empty() bool {
+	return len(rd.headers) == 0 && len(rd.params) == 0 && len(rd.paths) == 0 && len(rd.patterns) == 0
+}
+
+// request returns a shallow copy of r with secrets redacted, body of r is preserved.
+func (rd *redactor) request(r *http.Request) (*http.Request, error) {
+	if rd.empty() {
+		return r, nil
+	}
+	body, err := snapshotBody(&r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	if r.URL != nil {
+		r2.URL = rd.url(r.URL)
+		r2.RequestURI = r2.URL.RequestURI()
+	}
+	r2.Header = rd.header(r.Header)
+	r2.Trailer = rd.header(r.Trailer)
+	if body != nil {
+		body = rd.body(body)
+		r2.Body = io.NopCloser(bytes.NewReader(body))
+		r2.ContentLength = int64(len(body))
+		setContentLength(r2.Header, len(body))
+	}
+	return r2, nil
+}
+
+// response returns a shallow copy of resp with secrets redacted, body of resp is preserved.
+func (rd *redactor) response(resp *http.Response) (*http.Response, error) {
+	if rd.empty() {
+		return resp, nil
+	}
+	body, err := snapshotBody(&resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp2 := new(http.Response)
+	*resp2 = *resp
+	resp2.Header = rd.header(resp.Header)
+	resp2.Trailer = rd.header(resp.Trailer)
+	if body != nil {
+		body = rd.body(body)
+		resp2.Body = io.NopCloser(bytes.NewReader(body))
+		resp2.ContentLength = int64(len(body))
+		setContentLength(resp2.Header, len(body))
+	}
+	return resp2, nil
+}
+
+// restore replaces RedactedPlaceholder in headers, query and JSON body of recorded request r with real values
+// from the environment, see Redaction.Env.
+func (rd *redactor) restore(r *http.Request) error {
+	if len(rd.env) == 0 {
+		return nil
+	}
+	lookup := func(name string) (string, error) {
+		env := rd.env[name]
+		v, ok := os.LookupEnv(env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %q with value of redacted %q is not set", env, name)
+		}
+		return v, nil
+	}
+	for name := range rd.env {
+		key := http.CanonicalHeaderKey(name)
+		if !rd.headers[key] {
+			continue
+		}
+		for i, v := range r.Header[key] {
+			if v != RedactedPlaceholder {
+				continue
+			}
+			real, err := lookup(name)
+			if err != nil {
+				return err
+			}
+			r.Header[key][i] = real
+		}
+	}
+	if r.URL != nil && r.URL.RawQuery != "" {
+		params := strings.Split(r.URL.RawQuery, "&")
+		for i, param := range params {
+			key, value, ok := strings.Cut(param, "=")
+			if !ok || value != RedactedPlaceholder {
+				continue
+			}
+			name, err := url.QueryUnescape(key)
+			if err != nil || !rd.params[name] || rd.env[name] == "" {
+				continue
+			}
+			real, err := lookup(name)
+			if err != nil {
+				return err
+			}
+			params[i] = key + "=" + url.QueryEscape(real)
+		}
+		r.URL.RawQuery = strings.Join(params, "&")
+	}
+	body, err := snapshotBody(&r.Body)
+	if err != nil || body == nil {
+		return err
+	}
+	v, ok := decodeJSON(body)
+	if !ok {
+		return nil
+	}
+	var restored bool
+	for i, path := range rd.paths {
+		name := rd.pathNames[i]
+		if rd.env[name] == "" {
+			continue
+		}
+		var lookupErr error
+		v, _ = path.replace(v, func(old any) any {
+			if old != RedactedPlaceholder {
+				return old
+			}
+			real, err := lookup(name)
+			if err != nil {
+				lookupErr = err
+				return old
+			}
+			restored = true
+			return real
+		})
+		if lookupErr != nil {
+			return lookupErr
+		}
+	}
+	if restored {
+		body = encodeJSON(v, body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		setContentLength(r.Header, len(body))
+	}
+	return nil
+}
+
+func (rd *redactor) header(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	h2 := make(http.Header, len(h))
+	for k, vs := range h {
+		vs2 := make([]string, len(vs))
+		for i, v := range vs {
+			if rd.headers[http.CanonicalHeaderKey(k)] {
+				vs2[i] = RedactedPlaceholder
+				continue
+			}
+			vs2[i] = rd.string(v)
+		}
+		h2[k] = vs2
+	}
+	return h2
+}
+
+func (rd *redactor) url(u *url.URL) *url.URL {
+	u2 := *u
+	u2.RawQuery = rd.string(rd.query(u.RawQuery))
+	if len(rd.patterns) > 0 {
+		if p, err := url.PathUnescape(rd.string(u.EscapedPath())); err == nil {
+			u2.Path = p
+			u2.RawPath = ""
+		}
+	}
+	return &u2
+}
+
+// query replaces values of redacted query parameters, preserving order of parameters.
+func (rd *redactor) query(query string) string {
+	if len(rd.params) == 0 || query == "" {
+		return query
+	}
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok || value == "" {
+			continue
+		}
+		if ukey, err := url.QueryUnescape(key); err == nil && rd.params[ukey] {
+			params[i] = key + "=" + RedactedPlaceholder
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+func (rd *redactor) body(body []byte) []byte {
+	if len(rd.paths) > 0 {
+		if v, ok := decodeJSON(body); ok {
+			var redacted bool
+			for _, path := range rd.paths {
+				var matched bool
+				v, matched = path.replace(v, func(any) any { return RedactedPlaceholder })
+				redacted = redacted || matched
+			}
+			if redacted {
+				body = encodeJSON(v, body)
+			}
+		}
+	}
+	for _, re := range rd.patterns {
+		body = redactPattern(re, body)
+	}
+	return body
+}
+
+func (rd *redactor) string(s string) string {
+	for _, re := range rd.patterns {
+		s = string(redactPattern(re, []byte(s)))
+	}
+	return s
+}
+
+// redactPattern replaces matches of re in b, or only capture groups if re has any.
+func redactPattern(re *regexp.Regexp, b []byte) []byte {
+	if re.NumSubexp() == 0 {
+		return re.ReplaceAll(b, []byte(RedactedPlaceholder))
+	}
+	var (
+		out  []byte
+		last int
+	)
+	for _, m := range re.FindAllSubmatchIndex(b, -1) {
+		for g := 2; g < len(m); g += 2 {
+			start, end := m[g], m[g+1]
+			if start < last {
+				// unmatched or nested group
+				continue
+			}
+			out = append(out, b[last:start]...)
+			out = append(out, RedactedPlaceholder...)
+			last = end
+		}
+	}
+	return append(out, b[last:]...)
+}
+
+func setContentLength(h http.Header, n int) {
+	if h.Get("Content-Length") != "" {
+		h.Set("Content-Length", strconv.Itoa(n))
+	}
+}
+
+// snapshotBody reads body to completion and replaces it with an in-memory copy.
+func snapshotBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	data, err := io.ReadAll(*body)
+	if err != nil {
+		return nil, err
+	}
+	(*body).Close()
+	*body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
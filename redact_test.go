@@ -0,0 +1,211 @@
+package replay_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/daulet/replay"
+)
+
+var redaction = replay.Redaction{
+	Headers:     []string{"x-api-key"},
+	QueryParams: []string{"api_key"},
+	JSONPaths:   []string{"user.password", "tokens.*"},
+	Patterns:    []*regexp.Regexp{regexp.MustCompile(`sk-[a-z0-9]+`), regexp.MustCompile(`session=(\w+)`)},
+}
+
+// serveSecrets starts an application that leaks secrets from the request into the response.
+func serveSecrets(t *testing.T) string {
+	return serveHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("X-Api-Key", r.Header.Get("X-Api-Key"))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"tokens": []string{"sk-first", r.URL.Query().Get("api_key")},
+			"user":   req["user"],
+			"cookie": req["cookie"],
+		})
+	}))
+}
+
+func sendSecrets(t *testing.T, addr, secret string) *http.Response {
+	t.Helper()
+	body := fmt.Sprintf(`{"user":{"name":"joe","password":%q},"cookie":"session=%s"}`, secret, secret)
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/login?api_key=%s&page=1", addr, secret), strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", secret)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func assertRedacted(t *testing.T, files ...string) {
+	t.Helper()
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range []string{"hunter2", "sk-first"} {
+			if bytes.Contains(b, []byte(secret)) {
+				t.Errorf("%s contains secret %q:\n%s", file, secret, b)
+			}
+		}
+		if !bytes.Contains(b, []byte(replay.RedactedPlaceholder)) {
+			t.Errorf("%s doesn't contain placeholder:\n%s", file, b)
+		}
+	}
+}
+
+func TestRedactionHTTPServer(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "http.record")
+	remoteAddr := serveSecrets(t)
+
+	srv, err := replay.NewHTTPServer(0, true, remoteAddr, recordFile, replay.WithRedaction(redaction))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := sendSecrets(t, srv.Addr(), "hunter2")
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Api-Key"); got != "hunter2" {
+		t.Errorf("application got %q, want secrets to reach it while recording", got)
+	}
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assertRedacted(t, recordFile)
+
+	// different secret on replay still matches the recording
+	srv, err = replay.NewHTTPServer(0, false, remoteAddr, recordFile, replay.WithRedaction(redaction))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = sendSecrets(t, srv.Addr(), "swordfish")
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %s: %s", resp.Status, body)
+	}
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedactionRunner(t *testing.T) {
+	testDir := t.TempDir()
+	appAddr := serveSecrets(t)
+
+	runner, err := replay.NewHTTPRunner(0, appAddr, testDir, replay.WithRedaction(redaction))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := runner.Serve(); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-runner.Ready()
+	resp := sendSecrets(t, runner.Addr(), "hunter2")
+	resp.Body.Close()
+	runner.Stop()
+	wg.Wait()
+
+	assertRedacted(t, filepath.Join(testDir, "request0.data"), filepath.Join(testDir, "response0.data"))
+
+	if err := runner.Replay(false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedactionRestore(t *testing.T) {
+	const secret = "s3cret"
+	// application authenticates every request
+	appAddr := serveHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Password string }
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.Header.Get("Authorization") != "Bearer "+secret || r.URL.Query().Get("api_key") != secret || req.Password != secret {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("welcome"))
+	}))
+	t.Setenv("TEST_APP_AUTHORIZATION", "Bearer "+secret)
+	t.Setenv("TEST_APP_SECRET", secret)
+	opts := []replay.Option{replay.WithRedaction(replay.Redaction{
+		Headers:     []string{"Authorization"},
+		QueryParams: []string{"api_key"},
+		JSONPaths:   []string{"password"},
+		Env: map[string]string{
+			"Authorization": "TEST_APP_AUTHORIZATION",
+			"api_key":       "TEST_APP_SECRET",
+			"password":      "TEST_APP_SECRET",
+		},
+	})}
+
+	testDir := t.TempDir()
+	runner, err := replay.NewHTTPRunner(0, appAddr, testDir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := runner.Serve(); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-runner.Ready()
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/account?api_key=%s", runner.Addr(), secret), strings.NewReader(fmt.Sprintf(`{"password":%q}`, secret)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+secret)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	runner.Stop()
+	wg.Wait()
+	assertRedacted(t, filepath.Join(testDir, "request0.data"))
+	if b, _ := os.ReadFile(filepath.Join(testDir, "request0.data")); bytes.Contains(b, []byte(secret)) {
+		t.Errorf("request contains secret:\n%s", b)
+	}
+
+	// real values are sent to the application on replay
+	runner, err = replay.NewHTTPRunner(0, appAddr, testDir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Replay(false); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Unsetenv("TEST_APP_SECRET")
+	if err := runner.Replay(false); err == nil || !strings.Contains(err.Error(), "TEST_APP_SECRET") {
+		t.Errorf("got %v, want error about missing environment variable", err)
+	}
+}
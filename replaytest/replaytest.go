@@ -0,0 +1,286 @@
+// Package replaytest wires the replay runner and dependency proxies into go test.
+//
+// It registers the standard flags shared by replay based tests:
+//
+//	-create     record a new test case named by -test_name
+//	-test_name  name of the test case to create
+//	-update     re-record responses of existing test cases
+//	-detect     replay existing test cases twice and ignore response fields that differ
+//	-actual     save responses that differ from recorded ones for review with replay browse
+//
+// and picks the corresponding mode automatically, so a test only has to describe
+// how to start the application under test and where its dependencies live.
+package replaytest
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daulet/replay"
+)
+
+var (
+	create   = flag.Bool("create", false, "create (record) test case")
+	testName = flag.String("test_name", "newtest", "name of the test case to create")
+	update   = flag.Bool("update", false, "update recordings for existing test cases")
+	detect   = flag.Bool("detect", false, "replay existing test cases twice and write normalizations of nondeterministic response fields")
+	actual   = flag.Bool("actual", false, "save responses that differ from recorded ones as responseN.actual for review with replay browse")
+)
+
+// Mode is the way test cases are executed, derived from command line flags.
+type Mode int
+
+const (
+	// Replay replays existing test cases and compares responses, default mode.
+	Replay Mode = iota
+	// Update replays existing test cases and overwrites recorded responses.
+	Update
+	// Create records a new test case from live traffic.
+	Create
+	// Detect replays existing test cases twice, with dependencies replayed from their recordings, and adds
+	// normalizations for response fields that differ between runs to the test case, see replay.TestCaseFile.
+	Detect
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Replay:
+		return "replay"
+	case Update:
+		return "update"
+	case Create:
+		return "create"
+	case Detect:
+		return "detect"
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+// Recording reports whether dependencies are contacted and recorded in this mode.
+func (m Mode) Recording() bool {
+	return m == Create || m == Update
+}
+
+// CurrentMode returns the mode selected by -create, -update and -detect flags.
+func CurrentMode() Mode {
+	switch {
+	case *create:
+		return Create
+	case *update:
+		return Update
+	case *detect:
+		return Detect
+	}
+	return Replay
+}
+
+// TestdataDir resolves relDir relative to the package under test and creates it if missing.
+func TestdataDir(t testing.TB, relDir string) string {
+	t.Helper()
+	// this is complicated to support running this test in two different ways:
+	// 1. go test -tags cli.test
+	// 2. go test
+	// The second mode builds the test binary and runs from different working directory.
+	testdataDir := relDir
+	if _, err := os.Stat(testdataDir); err != nil {
+		ex, err := os.Executable()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dir := filepath.Join(filepath.Dir(ex), relDir); dirExists(dir) {
+			testdataDir = dir
+		}
+	}
+	// create if not exists
+	if err := os.MkdirAll(testdataDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return testdataDir
+}
+
+func dirExists(dir string) bool {
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
+
+// TestCases returns a list of test cases in the provided test data directory.
+// Each subdirectory is considered a test case, each of which could store
+// multiple recording files (for example, for different dependencies).
+// In Create mode, it creates a corresponding subdirectory to store the recordings,
+// and only returns that test case.
+func TestCases(t testing.TB, testdataDir string) []string {
+	t.Helper()
+	if CurrentMode() == Create {
+		if err := os.MkdirAll(filepath.Join(testdataDir, *testName), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		return []string{*testName}
+	}
+	files, err := os.ReadDir(testdataDir)
+	if err != nil {
+		wd, _ := os.Getwd()
+		t.Fatalf("unable to read directory (current directory: %q): %v", wd, err)
+	}
+	var cases []string
+	for _, testDir := range files {
+		// directories starting with a dot hold shared data, e.g. blobs
+		if !testDir.IsDir() || strings.HasPrefix(testDir.Name(), ".") {
+			continue
+		}
+		cases = append(cases, testDir.Name())
+	}
+	return cases
+}
+
+// Go runs a blocking serve function in the background for the duration of the test.
+// The context passed to serve is cancelled on test cleanup, which then waits for serve to return,
+// guaranteeing corresponding ports are released before the next test starts.
+func Go(t testing.TB, serve func(ctx context.Context) error) {
+	t.Helper()
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := serve(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+}
+
+// WaitListening blocks until addr accepts TCP connections, useful for servers started with
+// ListenAndServe that offer no readiness signal. It fails the test after a few seconds.
+func WaitListening(t testing.TB, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%q is not listening: %v", addr, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Dependency describes a service the application under test calls.
+type Dependency struct {
+	// Port the record/replay proxy listens on, the application should call this port
+	// instead of the dependency directly. Zero picks an ephemeral port, see HTTPServer.Addr.
+	Port int
+	// RemoteAddr of the real dependency, only contacted when recording.
+	RemoteAddr string
+	// File to store interactions in, relative to the test case directory.
+	File string
+	// Start, if set, starts the real dependency and returns the address it listens on, which replaces
+	// RemoteAddr unless empty, e.g. to start it on an ephemeral port. Only called when recording.
+	Start func(t *testing.T) string
+}
+
+// StartDependency starts the record/replay proxy for dep storing recordings in dir,
+// and the real dependency itself if recording. The proxy is closed on test cleanup.
+func StartDependency(t *testing.T, dir string, dep Dependency, opts ...replay.Option) *replay.HTTPServer {
+	t.Helper()
+	record := CurrentMode().Recording()
+	remoteAddr := dep.RemoteAddr
+	if record && dep.Start != nil {
+		if addr := dep.Start(t); addr != "" {
+			remoteAddr = addr
+		}
+	}
+	srv, err := replay.NewHTTPServer(dep.Port, record, remoteAddr, filepath.Join(dir, dep.File), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := srv.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return srv
+}
+
+// Config describes the application under test.
+type Config struct {
+	// Port the runner listens on when recording a new test case, zero picks an ephemeral port.
+	Port int
+	// Dependencies of the application, proxied for every test case.
+	Dependencies []Dependency
+	// App starts the application under test, configured to call provided dependency
+	// addresses (in order of Dependencies), and returns the address it listens on.
+	// Use Go or t.Cleanup to stop it at the end of the test case.
+	App func(t *testing.T, deps []string) string
+	// Options are passed to the runner and every dependency proxy, e.g. to share blob storage.
+	Options []replay.Option
+}
+
+// Run executes every test case in testdataDir as a subtest according to CurrentMode.
+func Run(t *testing.T, testdataDir string, cfg Config) {
+	t.Helper()
+	for _, testCase := range TestCases(t, testdataDir) {
+		testDir := filepath.Join(testdataDir, testCase)
+		t.Run(testCase, func(t *testing.T) {
+			runTestCase(t, testDir, cfg)
+		})
+	}
+}
+
+func runTestCase(t *testing.T, testDir string, cfg Config) {
+	var deps []string
+	for _, dep := range cfg.Dependencies {
+		srv := StartDependency(t, testDir, dep, cfg.Options...)
+		deps = append(deps, srv.Addr())
+	}
+	appAddr := cfg.App(t, deps)
+
+	opts := cfg.Options
+	if *actual {
+		opts = append(opts[:len(opts):len(opts)], replay.WithActualResponses())
+	}
+	runner, err := replay.NewHTTPRunner(cfg.Port, appAddr, testDir, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch mode := CurrentMode(); mode {
+	case Create:
+		// Serve may fail before it's ready, the test must not be logged to after it returns
+		served, logged := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(logged)
+			select {
+			case <-runner.Ready():
+				t.Logf("recording test case in %q: send requests to %s, then POST %sstop to finish", testDir, runner.Addr(), replay.ControlPrefix)
+			case <-served:
+			}
+		}()
+		err = runner.Serve()
+		close(served)
+		<-logged
+	case Detect:
+		var found []replay.Normalization
+		found, err = runner.DetectNondeterminism(true)
+		for _, n := range found {
+			t.Logf("ignoring nondeterministic %+v", n)
+		}
+	default:
+		err = runner.Replay(mode == Update)
+	}
+	if err != nil {
+		t.Error(err)
+	}
+}
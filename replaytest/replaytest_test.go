@@ -0,0 +1,139 @@
+package replaytest_test
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/daulet/replay/replaytest"
+)
+
+func TestRun(t *testing.T) {
+	replaytest.Run(t, replaytest.TestdataDir(t, "testdata"), replaytest.Config{
+		Dependencies: []replaytest.Dependency{
+			{
+				File: "http.record",
+				Start: func(t *testing.T) string {
+					return serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						fmt.Fprintf(w, "Hello from %s", r.URL.Path)
+					}))
+				},
+			},
+		},
+		App: func(t *testing.T, deps []string) string {
+			return serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				resp, err := http.Get(fmt.Sprintf("http://%s%s", deps[0], r.URL.Path))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadGateway)
+					return
+				}
+				defer resp.Body.Close()
+				io.Copy(w, resp.Body)
+			}))
+		},
+	})
+}
+
+func TestDetectReplaysDependencies(t *testing.T) {
+	testdataDir := t.TempDir()
+	for _, name := range []string{"http.record", "request0.data", "response0.data"} {
+		b, err := os.ReadFile(filepath.Join("testdata", "hello", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(testdataDir, "hello"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(testdataDir, "hello", name), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recordFile := filepath.Join(testdataDir, "hello", "http.record")
+	want, err := os.ReadFile(recordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := flag.Set("detect", "true"); err != nil {
+		t.Fatal(err)
+	}
+	defer flag.Set("detect", "false")
+	t.Run("detect", func(t *testing.T) {
+		// the real dependency isn't started, recorded interactions are replayed
+		replaytest.Run(t, testdataDir, replaytest.Config{
+			Dependencies: []replaytest.Dependency{{File: "http.record"}},
+			App: func(t *testing.T, deps []string) string {
+				return serve(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					resp, err := http.Get(fmt.Sprintf("http://%s%s", deps[0], r.URL.Path))
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusBadGateway)
+						return
+					}
+					defer resp.Body.Close()
+					io.Copy(w, resp.Body)
+				}))
+			},
+		})
+	})
+
+	got, err := os.ReadFile(recordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("detect changed recording:\n%s", got)
+	}
+}
+
+func TestModeRecording(t *testing.T) {
+	for mode, want := range map[replaytest.Mode]bool{
+		replaytest.Replay: false,
+		replaytest.Update: true,
+		replaytest.Create: true,
+		replaytest.Detect: false,
+	} {
+		if got := mode.Recording(); got != want {
+			t.Errorf("%s: got %v, want %v", mode, got, want)
+		}
+	}
+}
+
+func TestModeString(t *testing.T) {
+	for mode, want := range map[replaytest.Mode]string{
+		replaytest.Replay:  "replay",
+		replaytest.Update:  "update",
+		replaytest.Create:  "create",
+		replaytest.Detect:  "detect",
+		replaytest.Mode(7): "Mode(7)",
+	} {
+		if got := mode.String(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func serve(t *testing.T, handler http.Handler) string {
+	lstr, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: handler}
+	replaytest.Go(t, func(ctx context.Context) error {
+		go func() {
+			<-ctx.Done()
+			srv.Shutdown(context.Background())
+		}()
+		if err := srv.Serve(lstr); err != http.ErrServerClosed {
+			return err
+		}
+		return nil
+	})
+	return lstr.Addr().String()
+}
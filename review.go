@@ -0,0 +1,113 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Exchange is a request of a runner test case with its recorded response and, if the last replay
+// responded differently, the actual response.
+type Exchange struct {
+	Index   int
+	Request []byte
+	// Response is the recorded response, or the error the request failed with if Failed is set.
+	Response []byte
+	Failed   bool
+	// Actual is the response of the last replay that differed from Response, nil if it didn't
+	// or the replay didn't save it, see WithActualResponses.
+	Actual []byte
+}
+
+// WithActualResponses makes the runner save responses that differ from recorded ones on Replay
+// as responseN.actual next to them, for review with ReadExchanges and AcceptActual, e.g. by replay browse.
+// They aren't meant to be committed, add *.actual to .gitignore of the test data.
+func WithActualResponses() Option {
+	return func(o *options) {
+		o.actual = true
+	}
+}
+
+// FindTestCases returns directories under roots, searched recursively, that have recorded requests.
+func FindTestCases(roots []string) ([]string, error) {
+	var dirs []string
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			if _, err := os.Stat(filepath.Join(path, "request0.data")); err == nil {
+				dirs = append(dirs, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to find test cases in %q: [%w]", root, err)
+		}
+	}
+	return dirs, nil
+}
+
+// ReadExchanges reads requests of the test case in testDir with their recorded and actual responses,
+// as dumped into the test case files.
+func ReadExchanges(testDir string, opts ...Option) ([]*Exchange, error) {
+	store, err := newFileStore(newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	var exchanges []*Exchange
+	for i := 0; ; i++ {
+		req, err := store.readMessage(filepath.Join(testDir, fmt.Sprintf("request%v.data", i)))
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %d-th request: [%w]", i, err)
+		}
+		ex := &Exchange{Index: i, Request: req}
+		ex.Response, err = store.readMessage(filepath.Join(testDir, fmt.Sprintf("response%v.data", i)))
+		if errors.Is(err, os.ErrNotExist) {
+			ex.Failed = true
+			ex.Response, err = store.readFile(filepath.Join(testDir, fmt.Sprintf("response%v.err", i)))
+			if errors.Is(err, os.ErrNotExist) {
+				// generated test cases have no responses until the first update
+				ex.Failed, ex.Response, err = false, nil, nil
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %d-th response: [%w]", i, err)
+		}
+		ex.Actual, err = store.readMessage(filepath.Join(testDir, fmt.Sprintf("response%v.actual", i)))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read %d-th actual response: [%w]", i, err)
+		}
+		exchanges = append(exchanges, ex)
+	}
+	return exchanges, nil
+}
+
+// AcceptActual replaces i-th recorded response of the test case in testDir with the actual response
+// saved by the last replay, see Exchange.
+func AcceptActual(testDir string, i int, opts ...Option) error {
+	o := newOptions(opts)
+	store, err := newFileStore(o)
+	if err != nil {
+		return err
+	}
+	raw, err := store.readMessage(filepath.Join(testDir, fmt.Sprintf("response%v.actual", i)))
+	if err != nil {
+		return fmt.Errorf("failed to read %d-th actual response: [%w]", i, err)
+	}
+	// requests that failed are saved as the error they failed with
+	_, err = http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), nil)
+	h := &httpRunner{writeDir: testDir, redact: newRedactor(o.redaction), store: store, logger: o.logger}
+	return h.writeResponse(testDir, i, raw, err != nil)
+}
@@ -0,0 +1,81 @@
+package replay_test
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/daulet/replay"
+)
+
+func TestAcceptActual(t *testing.T) {
+	testDir := t.TempDir()
+	recordTestCase(t, serveText(t, "old"), testDir, func(addr string) {
+		for _, path := range []string{"/a", "/b"} {
+			resp, err := http.Get("http://" + addr + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+		}
+	})
+
+	// differing responses aren't saved unless asked to
+	runner, err := replay.NewHTTPRunner(0, serveText(t, "new"), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Replay(false); err == nil {
+		t.Fatal("expected responses to differ")
+	}
+	if _, err := os.Stat(filepath.Join(testDir, "response0.actual")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v, want no actual response saved", err)
+	}
+
+	runner, err = replay.NewHTTPRunner(0, serveText(t, "new"), testDir, replay.WithActualResponses())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every differing response is reported and saved for review
+	err = runner.Replay(false)
+	if err == nil || strings.Count(err.Error(), "HTTP response diff") != 2 {
+		t.Fatalf("got %v, want diffs of both responses", err)
+	}
+	exchanges, err := replay.ReadExchanges(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exchanges) != 2 {
+		t.Fatalf("got %d exchanges, want 2", len(exchanges))
+	}
+	for _, ex := range exchanges {
+		if !strings.Contains(string(ex.Response), "\r\n\r\nold") || !strings.Contains(string(ex.Actual), "\r\n\r\nnew") {
+			t.Errorf("exchange %d: got response %q, actual %q", ex.Index, ex.Response, ex.Actual)
+		}
+	}
+
+	if err := replay.AcceptActual(testDir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if exchanges, err = replay.ReadExchanges(testDir); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(exchanges[0].Response), "new /a") || exchanges[0].Actual != nil {
+		t.Errorf("got accepted exchange %q, actual %q", exchanges[0].Response, exchanges[0].Actual)
+	}
+	if err := replay.AcceptActual(testDir, 0); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v accepting again, want not exist", err)
+	}
+
+	// only the response left unaccepted differs now
+	err = runner.Replay(false)
+	if err == nil || !strings.HasPrefix(err.Error(), "1-th HTTP response diff") {
+		t.Fatalf("got %v, want diff of the second response", err)
+	}
+	if _, err := os.Stat(filepath.Join(testDir, "response0.actual")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v, want actual response of matching exchange removed", err)
+	}
+}
@@ -0,0 +1,172 @@
+package replay
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Route overrides record mode and remote address of the record/replay server for matching requests,
+// e.g. to pass requests of one service through to its local build while the rest are replayed.
+type Route struct {
+	// Host matches host the client requested, with or without port, any host if empty. That is
+	// X-Forwarded-Host header set by a gateway in front of the server, or the host of the request URL
+	// of clients that use the server as their HTTP proxy, otherwise Host header. Clients that send
+	// requests to the address of the server itself, see HTTPServer.Addr, request that address, so
+	// dependencies they call that way can't be told apart by host; use PathPrefix, a server per
+	// dependency, or have the clients set X-Forwarded-Host.
+	Host string
+	// PathPrefix matches path of the request, any path if empty.
+	PathPrefix string
+	// Mode of matching requests, mode of the server if zero. RecordAll forwards matching requests
+	// to the dependency and replaces interactions recorded for them, interactions no request matches
+	// are kept, since the server can't tell which route they were recorded for.
+	Mode RecordMode
+	// RemoteAddr to forward matching requests to, remote address of the server if empty.
+	RemoteAddr string
+}
+
+// WithRoutes makes the record/replay server handle requests matching a route according to the route,
+// the first matching route applies. All routes share the record file of the server.
+func WithRoutes(routes ...Route) Option {
+	return func(o *options) {
+		o.routes = append(o.routes, routes...)
+	}
+}
+
+func (rt *Route) matches(req *http.Request) bool {
+	if rt.Host != "" {
+		reqHost := requestedHost(req)
+		if rt.Host != reqHost {
+			host, _, err := net.SplitHostPort(reqHost)
+			if err != nil || rt.Host != host {
+				return false
+			}
+		}
+	}
+	return strings.HasPrefix(req.URL.Path, rt.PathPrefix)
+}
+
+// requestedHost returns the host the client of the server requested, see Route.Host. Go server sets
+// Host of requests with absolute URL, i.e. sent to a proxy, to the host of the URL.
+func requestedHost(req *http.Request) string {
+	if fwd := req.Header.Get("X-Forwarded-Host"); fwd != "" {
+		// the first gateway is the closest to the client
+		host, _, _ := strings.Cut(fwd, ",")
+		return strings.TrimSpace(host)
+	}
+	return req.Host
+}
+
+// route is a Route with the client serving it.
+type route struct {
+	Route
+	client *http.Client
+}
+
+var _ recorderOrReplayer = (*hybridRecorder)(nil)
+
+// hybridRecorder serves requests in a mode chosen per route, sharing a single record file, see WithRoutes.
+type hybridRecorder struct {
+	*episodeRecorder
+	mode   RecordMode
+	routes []*route
+	// write is set if any of the modes records interactions
+	write bool
+}
+
+func newHybridRecorder(file string, rd *redactor, store *fileStore, o *options, mode RecordMode) (*hybridRecorder, error) {
+	e, err := newEpisodeRecorder(file, rd, store, o)
+	if err != nil {
+		return nil, err
+	}
+	h := &hybridRecorder{episodeRecorder: e, mode: mode}
+	for _, rt := range o.routes {
+		if rt.Mode == 0 {
+			rt.Mode = mode
+		}
+		rt.Mode = resolveRecordMode(&options{recordMode: rt.Mode}, false, file)
+		h.routes = append(h.routes, &route{Route: rt, client: h.client(rt.Mode)})
+		h.write = h.write || records(rt.Mode)
+	}
+	h.write = h.write || records(mode)
+	return h, nil
+}
+
+// records reports whether mode writes interactions to the record file.
+func records(mode RecordMode) bool {
+	return mode == RecordAll || mode == RecordNewEpisodes
+}
+
+// routedModes reports whether the record file is used by the server mode or any of the routes.
+func routedModes(o *options, mode RecordMode) bool {
+	if mode != RecordPassthrough {
+		return true
+	}
+	for _, rt := range o.routes {
+		if rt.Mode != 0 && rt.Mode != RecordPassthrough {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *hybridRecorder) Client() *http.Client {
+	return h.client(h.mode)
+}
+
+func (h *hybridRecorder) client(mode RecordMode) *http.Client {
+	return &http.Client{Transport: &modeTransport{h: h, mode: mode}}
+}
+
+// route returns the route matching req, nil if there is none.
+func (h *hybridRecorder) route(req *http.Request) *route {
+	for _, rt := range h.routes {
+		if rt.matches(req) {
+			return rt
+		}
+	}
+	return nil
+}
+
+// Close writes the record file if any mode records interactions, and reports errors of replay,
+// see WithStrict and WithOrder.
+func (h *hybridRecorder) Close() error {
+	if !h.write {
+		return h.replayer.Close()
+	}
+	return errors.Join(h.episodeRecorder.Close(), h.replayer.Close())
+}
+
+// modeTransport serves requests in a single mode of hybridRecorder.
+type modeTransport struct {
+	h    *hybridRecorder
+	mode RecordMode
+}
+
+func (t *modeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.h.replayer.logger.Debug("routed request", "request", req.Method+" "+req.URL.RequestURI(), "mode", t.mode)
+	switch t.mode {
+	case RecordAll:
+		return t.h.rerecord(req)
+	case RecordNewEpisodes:
+		return t.h.episodeRecorder.RoundTrip(req)
+	case RecordPassthrough:
+		return http.DefaultTransport.RoundTrip(req)
+	default:
+		return t.h.replayer.RoundTrip(req)
+	}
+}
+
+// rerecord forwards req to the dependency, replacing the recorded interaction matching it with the new one.
+func (h *hybridRecorder) rerecord(req *http.Request) (*http.Response, error) {
+	lreq, err := convertRequest(req, h.replayer.redact)
+	if err != nil {
+		return nil, err
+	}
+	if e := h.replayer.match(lreq, req.Method+" "+req.URL.RequestURI()); e != nil {
+		h.recorder.drop(e)
+	}
+	return h.recorder.RoundTrip(req)
+}
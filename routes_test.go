@@ -0,0 +1,157 @@
+package replay_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/daulet/replay"
+)
+
+func serveText(t *testing.T, text string) string {
+	return serveHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", text, r.URL.Path)
+	}))
+}
+
+func TestRoutes(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "http.record")
+	remoteAddr := serveText(t, "recorded")
+
+	get := func(t *testing.T, srv *replay.HTTPServer, host, path, want string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s%s", srv.Addr(), path), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host != "" {
+			req.Host = host
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != want {
+			t.Errorf("%s%s: got %q, want %q", host, path, body, want)
+		}
+	}
+	start := func(t *testing.T, record bool, opts ...replay.Option) *replay.HTTPServer {
+		t.Helper()
+		srv, err := replay.NewHTTPServer(0, record, remoteAddr, recordFile, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return srv
+	}
+
+	srv := start(t, true)
+	get(t, srv, "", "/users", "recorded /users")
+	get(t, srv, "", "/orders", "recorded /orders")
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("passthrough", func(t *testing.T) {
+		srv := start(t, false, replay.WithStrict(), replay.WithRoutes(
+			replay.Route{PathPrefix: "/orders", Mode: replay.RecordPassthrough, RemoteAddr: serveText(t, "local")},
+			replay.Route{Host: "billing", Mode: replay.RecordPassthrough, RemoteAddr: serveText(t, "billing")},
+		))
+		get(t, srv, "", "/users", "recorded /users")
+		get(t, srv, "", "/orders", "local /orders")
+		get(t, srv, "billing:8080", "/invoices", "billing /invoices")
+		// passed through requests aren't expected to be recorded
+		if err := srv.Close(); err == nil {
+			t.Fatal("expected unused /orders interaction")
+		}
+	})
+
+	t.Run("record", func(t *testing.T) {
+		srv := start(t, false, replay.WithRoutes(
+			replay.Route{PathPrefix: "/users", Mode: replay.RecordAll, RemoteAddr: serveText(t, "v2")},
+		))
+		get(t, srv, "", "/users", "v2 /users")
+		get(t, srv, "", "/orders", "recorded /orders")
+		if err := srv.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		srv = start(t, false, replay.WithStrict())
+		get(t, srv, "", "/orders", "recorded /orders")
+		get(t, srv, "", "/users", "v2 /users")
+		if err := srv.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// replayed interactions are still checked while a route records
+		srv = start(t, false, replay.WithStrict(), replay.WithRoutes(
+			replay.Route{PathPrefix: "/users", Mode: replay.RecordAll, RemoteAddr: serveText(t, "v3")},
+		))
+		get(t, srv, "", "/users", "v3 /users")
+		if err := srv.Close(); err == nil || !strings.Contains(err.Error(), "GET /orders used 0 of") {
+			t.Errorf("got %v, want unused /orders interaction", err)
+		}
+		// interactions the recording route didn't request are kept
+		srv = start(t, false)
+		get(t, srv, "", "/orders", "recorded /orders")
+		get(t, srv, "", "/users", "v3 /users")
+		if err := srv.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("proxied", func(t *testing.T) {
+		srv := start(t, false, replay.WithRoutes(
+			replay.Route{Host: "billing", Mode: replay.RecordPassthrough, RemoteAddr: serveText(t, "billing")},
+			replay.Route{Mode: replay.RecordPassthrough, RemoteAddr: serveText(t, "other")},
+		))
+		defer srv.Close()
+		srvURL := &url.URL{Scheme: "http", Host: srv.Addr()}
+		// clients that use the server as their HTTP proxy request hosts of their dependencies
+		client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(srvURL)}}
+		// so do clients behind a gateway that sets X-Forwarded-Host
+		gateway := serveHandler(t, &httputil.ReverseProxy{Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(srvURL)
+			r.SetXForwarded()
+		}})
+		for _, test := range []struct {
+			client          *http.Client
+			url, host, want string
+		}{
+			{client: client, url: "http://billing:8080/invoices", want: "billing /invoices"},
+			{client: client, url: "http://orders:8080/orders", want: "other /orders"},
+			{client: http.DefaultClient, url: "http://" + gateway + "/invoices", host: "billing", want: "billing /invoices"},
+			{client: http.DefaultClient, url: "http://" + gateway + "/orders", want: "other /orders"},
+			// requests to the server itself are for its own address
+			{client: http.DefaultClient, url: "http://" + srv.Addr() + "/invoices", want: "other /invoices"},
+		} {
+			req, err := http.NewRequest(http.MethodGet, test.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.host != "" {
+				req.Host = test.host
+			}
+			resp, err := test.client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != test.want {
+				t.Errorf("%s (host %q): got %q, want %q", test.url, test.host, body, test.want)
+			}
+		}
+	})
+}
@@ -2,16 +2,17 @@ package replay
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sync"
 
 	"github.com/google/go-cmp/cmp"
@@ -20,24 +21,69 @@ import (
 type httpRunner struct {
 	remoteAddr string
 	writeDir   string
+	redact     *redactor
+	store      *fileStore
+	contract   *contract
+	logger     Logger
+	// actual is set if responses that differ from recorded ones are saved, see WithActualResponses
+	actual bool
 
 	// internal control
-	ready chan struct{}
-	done  chan struct{}
+	ready    chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
 
 	// internal state
-	srv       *http.Server
-	mux       sync.RWMutex
-	requestID int
+	lstr     net.Listener
+	srv      *http.Server
+	ctrlLstr net.Listener
+	ctrlSrv  *http.Server
+	mux      sync.RWMutex
+	// exchanges of the test case being recorded, in order of requests
+	exchanges []*exchangeRecord
+	// clearPending is set if a previous recording in writeDir is to be cleared before the first exchange
+	clearPending bool
 }
 
-func NewHTTPRunner(port int, remoteAddr string, writeDir string) (*httpRunner, error) {
+// exchangeRecord is a request being recorded with its response. Index and test case are assigned when the request
+// arrives, so the response is recorded next to it even if other requests arrive or the test case changes
+// in the meantime.
+type exchangeRecord struct {
+	dir   string
+	index int
+	// discarded is set if the exchange was discarded before its response arrived, see DiscardLast
+	discarded bool
+}
+
+type exchangeKey struct{}
+
+// NewHTTPRunner creates a runner that records traffic to remoteAddr into writeDir when serving,
+// and replays recorded traffic from writeDir on Replay. Pass port 0 to listen on an ephemeral port,
+// see Addr.
+func NewHTTPRunner(port int, remoteAddr string, writeDir string, opts ...Option) (*httpRunner, error) {
+	o := newOptions(opts)
+	store, err := newFileStore(o)
+	if err != nil {
+		return nil, err
+	}
+	var c *contract
+	if o.openAPI != "" {
+		if c, err = loadContract(o.openAPI); err != nil {
+			return nil, err
+		}
+	}
 	srvMux := http.NewServeMux()
 	runner := &httpRunner{
 		remoteAddr: fmt.Sprintf("http://%s", remoteAddr),
 		writeDir:   writeDir,
+		redact:     newRedactor(o.redaction),
+		store:      store,
+		contract:   c,
+		logger:     o.logger,
+		actual:     o.actual,
 		ready:      make(chan struct{}),
 		done:       make(chan struct{}),
+		lstr:       o.listener,
 		srv: &http.Server{
 			Addr:    fmt.Sprintf(":%v", port),
 			Handler: srvMux,
@@ -53,21 +99,23 @@ func NewHTTPRunner(port int, remoteAddr string, writeDir string) (*httpRunner, e
 	// without original request. Could be as simple as implementing a custom http.ResponseWriter
 	// and pass it to ServeHTTP in srvMux.HandleFunc("/", ...)
 	proxy.ModifyResponse = func(r *http.Response) error {
-		return runner.recordResponse(r, nil)
+		return runner.recordResponse(r.Request.Context().Value(exchangeKey{}).(*exchangeRecord), r, nil)
 	}
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		runner.recordResponse(nil, err)
+		runner.logger.Warn("application request failed", "method", r.Method, "url", r.URL.RequestURI(), "error", err)
+		runner.recordResponse(r.Context().Value(exchangeKey{}).(*exchangeRecord), nil, err)
 		w.WriteHeader(http.StatusBadGateway)
 	}
 	srvMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		runner.recordRequest(r)
-		proxy.ServeHTTP(w, r)
-	})
-	srvMux.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		close(runner.done)
+		ex := runner.recordRequest(r)
+		proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), exchangeKey{}, ex)))
 	})
+	if o.controlListener != nil {
+		runner.ctrlLstr = o.controlListener
+		runner.ctrlSrv = &http.Server{Handler: runner.controlHandler()}
+	} else {
+		srvMux.Handle(ControlPrefix, runner.controlHandler())
+	}
 
 	return runner, nil
 }
@@ -77,17 +125,61 @@ func (h *httpRunner) Ready() <-chan struct{} {
 	return h.ready
 }
 
+// Addr returns the address runner is listening on, empty until Ready.
+func (h *httpRunner) Addr() string {
+	select {
+	case <-h.ready:
+		return dialAddr(h.lstr.Addr())
+	default:
+		return ""
+	}
+}
+
+// ControlAddr returns the address control endpoints are served on, empty until Ready.
+func (h *httpRunner) ControlAddr() string {
+	if h.ctrlLstr == nil {
+		return h.Addr()
+	}
+	return dialAddr(h.ctrlLstr.Addr())
+}
+
+// Serve records incoming requests and responses until stopped, see Stop and ControlPrefix. A previous
+// recording of the test case is replaced once the first request arrives, so it's kept if Serve fails.
 func (h *httpRunner) Serve() error {
 	go func() {
 		<-h.done
-		_ = h.srv.Shutdown(context.Background())
+		h.logger.Info("runner shutting down")
+		if err := h.srv.Shutdown(context.Background()); err != nil {
+			h.logger.Error("failed to shut down runner", "error", err)
+		}
+		if h.ctrlSrv != nil {
+			if err := h.ctrlSrv.Shutdown(context.Background()); err != nil {
+				h.logger.Error("failed to shut down control server", "error", err)
+			}
+		}
 	}()
-	lstr, err := net.Listen("tcp", h.srv.Addr)
-	if err != nil {
-		return fmt.Errorf("failed to listen on address %q: [%w]", h.srv.Addr, err)
+	if h.ctrlSrv != nil {
+		go func() {
+			if err := h.ctrlSrv.Serve(h.ctrlLstr); err != http.ErrServerClosed {
+				h.logger.Error("control server stopped serving", "error", err)
+			}
+		}()
+	}
+	// the previous recording is kept until the first exchange of the new one, see recordRequest
+	h.mux.Lock()
+	h.clearPending = true
+	h.mux.Unlock()
+	if h.lstr == nil {
+		lstr, err := net.Listen("tcp", h.srv.Addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on address %q: [%w]", h.srv.Addr, err)
+		}
+		h.lstr = lstr
 	}
 	close(h.ready)
-	if err := h.srv.Serve(lstr); err != http.ErrServerClosed {
+	h.logger.Info("runner recording", "addr", dialAddr(h.lstr.Addr()), "remote", h.remoteAddr, "dir", h.writeDir)
+	if err := h.srv.Serve(h.lstr); err != http.ErrServerClosed {
+		h.logger.Error("runner stopped serving", "error", err)
 		return err
 	}
 	return nil
@@ -104,161 +196,433 @@ type indexedResponse struct {
 }
 
 func (h *httpRunner) Replay(updateResponses bool) error {
+	tc, err := readTestCase(h.store, h.writeDir)
+	if err != nil {
+		return err
+	}
+	// responses of generated test cases are populated by the first update
+	reqs, wantResps, err := h.load(updateResponses)
+	if err != nil {
+		return err
+	}
+	violations := h.contract.checkRequests(reqs)
+	resps, captured, err := h.send(h.remoteAddr, tc, reqs, wantResps)
+	if err != nil {
+		return err
+	}
+	violations = append(violations, h.contract.checkResponses(reqs, resps)...)
+	// schema violations are reported next to response diffs
+	if err := h.compare(tc, resps, wantResps, captured, updateResponses); err != nil {
+		return errors.Join(err, schemaError(violations))
+	}
+	return schemaError(violations)
+}
+
+// compare compares responses with recorded ones, or overwrites recorded ones if updateResponses is set.
+// Diffs of responses that differ are joined, and the responses saved as responseN.actual for review
+// if enabled, see WithActualResponses.
+func (h *httpRunner) compare(tc *TestCase, resps, wantResps []*httpResponse, captured captures, updateResponses bool) error {
+	var errs []error
+	for i, resp := range resps {
+		rawResp, err := h.dumpResult(resp)
+		if err != nil {
+			return err
+		}
+		if updateResponses {
+			if err := h.writeResponse(h.writeDir, i, rawResp, resp.err != nil); err != nil {
+				return fmt.Errorf("failed to update response file: [%w]", err)
+			}
+			h.logger.Info("updated response", "dir", h.writeDir, "index", i)
+			continue
+		}
+
+		diff, err := h.diff(tc, i, rawResp, resp.err != nil, wantResps[i], captured)
+		if err != nil {
+			return err
+		}
+		if err := h.writeActual(h.writeDir, i, rawResp, h.actual && diff != ""); err != nil {
+			return fmt.Errorf("failed to write actual response file: [%w]", err)
+		}
+		if diff != "" {
+			h.logger.Warn("response differs from recording", "dir", h.writeDir, "index", i)
+			errs = append(errs, errors.New(diff))
+			continue
+		}
+		h.logger.Debug("response matches recording", "dir", h.writeDir, "index", i)
+	}
+	return errors.Join(errs...)
+}
+
+// diff describes how i-th response, dumped as rawResp, or its error if failed, differs from the recorded one,
+// empty if it doesn't.
+func (h *httpRunner) diff(tc *TestCase, i int, rawResp []byte, failed bool, wantResp *httpResponse, captured captures) (string, error) {
+	if wantResp.err != nil {
+		if diff := cmp.Diff(wantResp.err.Error(), string(rawResp)); diff != "" {
+			return fmt.Sprintf("%d-th HTTP error diff: (-got +want)\n%s", i, diff), nil
+		}
+		return "", nil
+	}
+	if failed {
+		return fmt.Sprintf("%d-th HTTP request failed: %s", i, rawResp), nil
+	}
+
+	rawWantResp, err := h.dumpResult(wantResp)
+	if err != nil {
+		return "", err
+	}
+	if rawWantResp, err = tc.normalize(i, rawWantResp); err != nil {
+		return "", fmt.Errorf("failed to normalize %d-th recorded HTTP response: [%w]", i, err)
+	}
+	if rawResp, err = tc.normalize(i, rawResp); err != nil {
+		return "", fmt.Errorf("failed to normalize %d-th HTTP response: [%w]", i, err)
+	}
+	diff, err := responseDiff(rawWantResp, rawResp, captured.masks())
+	if err != nil {
+		return "", fmt.Errorf("failed to compare %d-th HTTP response: [%w]", i, err)
+	}
+	if diff != "" {
+		return fmt.Sprintf("%d-th HTTP response diff: (-got +want)\n%s", i, diff), nil
+	}
+	return "", nil
+}
+
+// load reads recorded requests and responses of the test case. If missingResponses is set, requests
+// without a recorded response are loaded too, with nil response.
+func (h *httpRunner) load(missingResponses bool) ([]*http.Request, []*httpResponse, error) {
 	var (
 		reqs      []*http.Request
 		wantResps []*httpResponse
 	)
 	for i := 0; ; i++ {
 		reqPath := filepath.Join(h.writeDir, fmt.Sprintf("request%v.data", i))
-		f, err := os.Open(reqPath)
-		if err != nil {
+		b, err := h.store.readMessage(reqPath)
+		if errors.Is(err, os.ErrNotExist) {
 			break
 		}
-		req, err := http.ReadRequest(bufio.NewReader(f))
 		if err != nil {
-			return fmt.Errorf("failed to read request from file %q: [%w]", reqPath, err)
+			return nil, nil, fmt.Errorf("failed to open request file %q: [%w]", reqPath, err)
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(b)))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read request from file %q: [%w]", reqPath, err)
+		}
+		if err := loadRequest(req); err != nil {
+			return nil, nil, fmt.Errorf("failed to load request body from file %q: [%w]", reqPath, err)
 		}
 		reqs = append(reqs, req)
 
 		respPath := filepath.Join(h.writeDir, fmt.Sprintf("response%v.data", i))
-		f, err = os.Open(respPath)
-		if err != nil {
+		b, err = h.store.readMessage(respPath)
+		if errors.Is(err, os.ErrNotExist) {
 			respPath := filepath.Join(h.writeDir, fmt.Sprintf("response%v.err", i))
-			f, err = os.Open(respPath)
-			if err != nil {
-				return fmt.Errorf("failed to open response file %q: [%w]", respPath, err)
+			b, err = h.store.readFile(respPath)
+			if missingResponses && errors.Is(err, os.ErrNotExist) {
+				wantResps = append(wantResps, nil)
+				continue
 			}
-			b, err := io.ReadAll(f)
 			if err != nil {
-				return fmt.Errorf("failed to read response from file %q: [%w]", respPath, err)
+				return nil, nil, fmt.Errorf("failed to open response file %q: [%w]", respPath, err)
 			}
 			wantResps = append(wantResps, &httpResponse{err: fmt.Errorf("%s", b)})
 			continue
 		}
-		resp, err := http.ReadResponse(bufio.NewReader(f), nil)
 		if err != nil {
-			return fmt.Errorf("failed to read response from file %q: [%w]", respPath, err)
+			return nil, nil, fmt.Errorf("failed to open response file %q: [%w]", respPath, err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read response from file %q: [%w]", respPath, err)
 		}
 		wantResps = append(wantResps, &httpResponse{resp: resp})
 	}
+	return reqs, wantResps, nil
+}
+
+// send sends recorded requests to remoteAddr, returning responses and values captured from them.
+func (h *httpRunner) send(remoteAddr string, tc *TestCase, reqs []*http.Request, wantResps []*httpResponse) ([]*httpResponse, captures, error) {
+	if len(tc.Captures) == 0 {
+		return h.sendAll(remoteAddr, reqs), nil, nil
+	}
+	// later requests depend on values captured from earlier responses
+	return h.sendInOrder(remoteAddr, reqs, wantResps, tc.Captures)
+}
+
+// sendOne sends recorded request to remoteAddr with client, substituting captured values.
+func (h *httpRunner) sendOne(client *http.Client, remoteAddr string, req *http.Request, captured captures) *httpResponse {
+	req.RequestURI = ""
+	if err := captured.substituteRequest(req); err != nil {
+		return &httpResponse{err: fmt.Errorf("failed to substitute captured values: [%w]", err)}
+	}
+	if err := h.redact.restore(req); err != nil {
+		return &httpResponse{err: fmt.Errorf("failed to restore redacted values: [%w]", err)}
+	}
+	if err := encodeRequest(req); err != nil {
+		return &httpResponse{err: fmt.Errorf("failed to encode request: [%w]", err)}
+	}
+	u, err := url.Parse(remoteAddr)
+	if err != nil {
+		return &httpResponse{err: err}
+	}
+	u.Path, u.RawPath, u.RawQuery = req.URL.Path, req.URL.RawPath, req.URL.RawQuery
+	req.URL = u
+	resp, err := client.Do(req)
+	return &httpResponse{resp, err}
+}
+
+// sendAll sends requests concurrently.
+func (h *httpRunner) sendAll(remoteAddr string, reqs []*http.Request) []*httpResponse {
 	resps := make([]*httpResponse, len(reqs))
-	{
-		respCh := make(chan indexedResponse)
-		var wg sync.WaitGroup
-		for i, req := range reqs {
-			wg.Add(1)
-			go func(i int, req *http.Request) {
-				defer wg.Done()
-
-				req.RequestURI = ""
-				u, err := url.Parse(fmt.Sprintf("%s%s", h.remoteAddr, req.URL.Path))
-				if err != nil {
-					respCh <- indexedResponse{httpResponse{err: err}, i}
-					return
-				}
-				req.URL = u
-				resp, err := http.DefaultClient.Do(req)
-				respCh <- indexedResponse{httpResponse{resp, err}, i}
-			}(i, req)
-		}
-		for range reqs {
-			resp := <-respCh
-			resps[resp.index] = &resp.httpResponse
-		}
-		wg.Wait()
-		close(respCh)
+	respCh := make(chan indexedResponse)
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		wg.Add(1)
+		go func(i int, req *http.Request) {
+			defer wg.Done()
+			respCh <- indexedResponse{*h.sendOne(http.DefaultClient, remoteAddr, req, nil), i}
+		}(i, req)
 	}
-	for i, resp := range resps {
-		var rawResp []byte
-		var err error
-		if resp.resp != nil {
-			// remove Date header as it's not deterministic
-			resp.resp.Header.Del("Date")
-			rawResp, err = httputil.DumpResponse(resp.resp, true)
-			if err != nil {
-				return fmt.Errorf("failed to dump response: [%w]", err)
+	for range reqs {
+		resp := <-respCh
+		resps[resp.index] = &resp.httpResponse
+	}
+	wg.Wait()
+	close(respCh)
+	return resps
+}
+
+// sendInOrder sends requests one by one, capturing values from responses for the following requests.
+func (h *httpRunner) sendInOrder(remoteAddr string, reqs []*http.Request, wantResps []*httpResponse, captureList []Capture) ([]*httpResponse, captures, error) {
+	var captured captures
+	resps := make([]*httpResponse, len(reqs))
+	for i, req := range reqs {
+		resps[i] = h.sendOne(http.DefaultClient, remoteAddr, req, captured)
+		for _, c := range captureList {
+			if c.Response != i {
+				continue
 			}
-		} else {
-			// unwrap error to remove http layer addition: "Get "http://localhost:1234/foo": "
-			rawResp = []byte(errors.Unwrap(resp.err).Error())
-		}
-		if updateResponses {
-			respPath := filepath.Join(h.writeDir, fmt.Sprintf("response%v.data", i))
-			if resp.err != nil {
-				respPath = filepath.Join(h.writeDir, fmt.Sprintf("response%v.err", i))
+			if wantResps[i] == nil {
+				return nil, nil, fmt.Errorf("capture %q: %d-th response isn't recorded", c.Name, i)
 			}
-			err := os.WriteFile(respPath, rawResp, 0o644)
+			if wantResps[i].err != nil {
+				return nil, nil, fmt.Errorf("capture %q: recorded %d-th response is an error", c.Name, i)
+			}
+			rawWantResp, err := h.dumpResult(wantResps[i])
 			if err != nil {
-				return fmt.Errorf("failed to update response file: [%w]", err)
+				return nil, nil, err
 			}
-			continue
-		}
-
-		wantResp := wantResps[i]
-		if wantResp.err != nil {
-			if diff := cmp.Diff(wantResp.err.Error(), string(rawResp)); diff != "" {
-				return fmt.Errorf("%d-th HTTP error diff: (-got +want)\n%s", i, diff)
+			recorded, ok := c.extract(rawWantResp)
+			if !ok {
+				return nil, nil, fmt.Errorf("capture %q: recorded %d-th response doesn't have the value", c.Name, i)
 			}
-			continue
-		}
-
-		wantResp.resp.Header.Del("Date")
-		rawWantResp, err := httputil.DumpResponse(wantResp.resp, true)
-		if err != nil {
-			return fmt.Errorf("failed to dump response: [%w]", err)
-		}
-		if diff := cmp.Diff(string(rawWantResp), string(rawResp)); diff != "" {
-			return fmt.Errorf("%d-th HTTP response diff: (-got +want)\n%s", i, diff)
+			// keep recorded value if the live response doesn't have it, so the diff shows why
+			live := recorded
+			if resps[i].err == nil {
+				rawResp, err := h.dumpResult(resps[i])
+				if err != nil {
+					return nil, nil, err
+				}
+				if v, ok := c.extract(rawResp); ok {
+					live = v
+				}
+			}
+			value := capturedValue{name: c.Name, recorded: recorded, live: live}
+			if c.Regex != "" {
+				value.regex = regexp.MustCompile(c.Regex)
+			}
+			captured = append(captured, value)
 		}
 	}
-	return nil
+	return resps, captured, nil
 }
 
-func (h *httpRunner) recordRequest(r *http.Request) {
-	fullReq, _ := httputil.DumpRequest(r, true)
-	h.mux.RLock()
-	f, err := os.OpenFile(fmt.Sprintf("%s/request%v.data", h.writeDir, h.requestID), os.O_CREATE|os.O_WRONLY, 0o644)
-	h.mux.RUnlock()
-	if err != nil {
-		// h.log.Errorf("failed to create request file: %v", err)
-	} else {
-		defer f.Close()
-		_, err = f.Write(fullReq)
-		if err != nil {
-			// h.log.Errorf("failed to write request file: %v", err)
+// dumpResult dumps response, or error with http layer additions removed.
+func (h *httpRunner) dumpResult(resp *httpResponse) ([]byte, error) {
+	if resp.resp == nil {
+		// unwrap error to remove http layer addition: "Get "http://localhost:1234/foo": "
+		err := resp.err
+		if unwrapped := errors.Unwrap(err); unwrapped != nil {
+			err = unwrapped
 		}
+		return []byte(err.Error()), nil
+	}
+	// remove Date header as it's not deterministic
+	resp.resp.Header.Del("Date")
+	rawResp, err := h.dumpResponse(resp.resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump response: [%w]", err)
 	}
+	return rawResp, nil
 }
 
-func (h *httpRunner) recordResponse(resp *http.Response, respErr error) error {
+// recordRequest assigns the next index of the test case being recorded to r and writes it.
+func (h *httpRunner) recordRequest(r *http.Request) *exchangeRecord {
 	h.mux.Lock()
-	filename := fmt.Sprintf("%s/response%v.data", h.writeDir, h.requestID)
-	if respErr != nil {
-		filename = fmt.Sprintf("%s/response%v.err", h.writeDir, h.requestID)
+	defer h.mux.Unlock()
+	if h.clearPending {
+		if err := clearRecording(h.writeDir); err != nil {
+			h.logger.Error("failed to clear previous recording", "dir", h.writeDir, "error", err)
+		}
+		h.clearPending = false
 	}
-	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY, 0o644)
-	h.requestID += 1
-	h.mux.Unlock()
+	ex := &exchangeRecord{dir: h.writeDir, index: len(h.exchanges)}
+	h.exchanges = append(h.exchanges, ex)
+	if err := h.writeRequest(ex.dir, ex.index, r); err != nil {
+		h.logger.Error("failed to write request file", "dir", ex.dir, "index", ex.index, "error", err)
+	}
+	return ex
+}
+
+// writeRequest writes r as i-th request of the test case in dir.
+func (h *httpRunner) writeRequest(dir string, i int, r *http.Request) error {
+	fullReq, err := h.dumpRequest(r)
 	if err != nil {
-		// h.log.Errorf("failed to create response file: %v", err)
 		return err
 	}
-	defer f.Close()
+	return h.store.writeMessage(filepath.Join(dir, fmt.Sprintf("request%v.data", i)), fullReq)
+}
+
+// recordResponse writes the response of ex, or the error it failed with. Writes hold the same lock
+// as DiscardLast, so a response of a discarded exchange is never written.
+func (h *httpRunner) recordResponse(ex *exchangeRecord, resp *http.Response, respErr error) error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	dir, id := ex.dir, ex.index
+	if ex.discarded {
+		h.logger.Info("dropped response of discarded exchange", "dir", dir, "index", id)
+		return nil
+	}
 
 	if respErr != nil {
-		f.Write([]byte(respErr.Error()))
+		if err := h.writeResponse(dir, id, []byte(respErr.Error()), true); err != nil {
+			h.logger.Error("failed to write response file", "dir", dir, "index", id, "error", err)
+			return err
+		}
+		h.logger.Info("recorded failed exchange", "dir", dir, "index", id, "error", respErr)
 		return nil
 	}
 
 	// remove Date header as it's not deterministic
 	resp.Header.Del("Date")
-	rawResp, err := httputil.DumpResponse(resp, true)
+	rawResp, err := h.dumpResponse(resp)
 	if err != nil {
-		return fmt.Errorf("failed to dump response: [%w]", err)
+		// the error handler records the error at the same index
+		return err
 	}
-	_, err = f.Write(rawResp)
-	if err != nil {
+	if err := h.writeResponse(dir, id, rawResp, false); err != nil {
+		h.logger.Error("failed to write response file", "dir", dir, "index", id, "error", err)
 		return fmt.Errorf("failed to write response file: [%w]", err)
 	}
+	args := []any{"dir", dir, "index", id, "status", resp.StatusCode}
+	if resp.Request != nil {
+		args = append(args, "method", resp.Request.Method, "url", resp.Request.URL.RequestURI())
+	}
+	h.logger.Info("recorded exchange", args...)
+	return nil
+}
+
+// writeResponse writes i-th response of the test case in dir, or the error it failed with, and removes
+// the counterpart left by a previous recording, so a test case never has both responseN.data and responseN.err,
+// and the actual response left by a previous replay.
+func (h *httpRunner) writeResponse(dir string, i int, raw []byte, failed bool) error {
+	name, stale := fmt.Sprintf("response%v.data", i), fmt.Sprintf("response%v.err", i)
+	write := h.store.writeMessage
+	if failed {
+		name, stale = stale, name
+		write = h.store.writeFile
+	}
+	if err := write(filepath.Join(dir, name), raw); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, stale)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale %q: [%w]", stale, err)
+	}
+	return h.writeActual(dir, i, nil, false)
+}
+
+// writeActual writes raw as i-th actual response of the test case in dir if save is set,
+// otherwise removes the actual response left by a previous replay.
+func (h *httpRunner) writeActual(dir string, i int, raw []byte, save bool) error {
+	name := filepath.Join(dir, fmt.Sprintf("response%v.actual", i))
+	if save {
+		return h.store.writeMessage(name, raw)
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale %q: [%w]", name, err)
+	}
 	return nil
 }
+
+// recordingFile matches requests and responses of a recorded test case.
+var recordingFile = regexp.MustCompile(`^(request\d+\.data|response\d+\.(data|err|actual))$`)
+
+// clearRecording removes requests and responses of a previous recording from dir, so re-recording
+// a shorter test case doesn't leave stale ones behind. Other files, e.g. TestCaseFile, are kept.
+func clearRecording(dir string) error {
+	files, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read test case directory: [%w]", err)
+	}
+	for _, f := range files {
+		if f.IsDir() || !recordingFile.MatchString(f.Name()) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, f.Name())); err != nil {
+			return fmt.Errorf("failed to remove previous recording: [%w]", err)
+		}
+	}
+	return nil
+}
+
+// dumpRequest dumps request with body decoded, secrets redacted and binary content base64 encoded,
+// body of r is preserved.
+func (h *httpRunner) dumpRequest(r *http.Request) ([]byte, error) {
+	req, err := decodeRequest(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode request: [%w]", err)
+	}
+	req, err = h.redact.request(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to redact request: [%w]", err)
+	}
+	req, err = storeRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: [%w]", err)
+	}
+	// Host is the address of the runner itself, which is meaningless on replay,
+	// and random when listening on an ephemeral port.
+	u := *req.URL
+	u.Scheme, u.Host = "", ""
+	req.Host, req.URL = "", &u
+	fullReq, err := httputil.DumpRequest(req, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump request: [%w]", err)
+	}
+	return fullReq, nil
+}
+
+// dumpResponse dumps response with body decoded, secrets redacted and binary content base64 encoded,
+// body of resp is preserved.
+func (h *httpRunner) dumpResponse(resp *http.Response) ([]byte, error) {
+	decoded, err := decodeResponse(resp)
+	if err != nil {
+		// body is stored as received rather than failing the exchange
+		h.logger.Warn("failed to decode response, storing it encoded", "error", err)
+		decoded = resp
+	}
+	resp, err = h.redact.response(decoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to redact response: [%w]", err)
+	}
+	resp, err = storeResponse(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: [%w]", err)
+	}
+	rawResp, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump response: [%w]", err)
+	}
+	return rawResp, nil
+}
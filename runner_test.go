@@ -2,88 +2,36 @@ package replay_test
 
 import (
 	"context"
-	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sync"
 	"testing"
 
 	"github.com/daulet/replay"
+	"github.com/daulet/replay/replaytest"
 )
 
-func TestMain(m *testing.M) {
-	flag.Parse()
-	m.Run()
-}
-
-func findTestdataDir(t *testing.T, relDir string) string {
-	t.Helper()
-	// this is complicated to support running this test in two different ways:
-	// 1. go test -tags cli.test
-	// 2. go test
-	// The second mode builds the test binary and runs from different working directory.
-	testdataDir := relDir
-	if _, err := os.Stat(testdataDir); err != nil {
-		ex, err := os.Executable()
-		if err != nil {
-			t.Fatal(err)
-		}
-		testdataDir = filepath.Join(filepath.Dir(ex), relDir)
-	}
-	return testdataDir
-}
-
-func testCases(testdataDir string) ([]string, error) {
-	files, err := os.ReadDir(testdataDir)
-	if err != nil {
-		wd, _ := os.Getwd()
-		return nil, fmt.Errorf("unable to read directory (current directory: %q): [%w]", wd, err)
-	}
-	var cases []string
-	for _, testDir := range files {
-		if !testDir.IsDir() {
-			continue
-		}
-		cases = append(cases, testDir.Name())
-	}
-	return cases, nil
-}
-
 func TestRunner(t *testing.T) {
-	testdataDir := findTestdataDir(t, "testdata/app")
-
-	// create if not exists
-	_ = os.Mkdir(testdataDir, 0755)
-
-	testCases, err := testCases(testdataDir)
-	if err != nil {
-		t.Fatal(err)
-	}
+	testdataDir := replaytest.TestdataDir(t, "testdata/app")
 
-	for _, testCase := range testCases {
+	for _, testCase := range replaytest.TestCases(t, testdataDir) {
 		for _, mode := range []string{"create", "update", "replay"} {
 			t.Run(fmt.Sprintf("%s-%s", testCase, mode), func(t *testing.T) {
-				var wg sync.WaitGroup
-				ctx, cancel := context.WithCancel(context.Background())
-
-				wg.Add(1)
-				go func() {
-					defer wg.Done()
-					if err := serve(ctx, 8080); err != nil {
-						t.Error(err)
-					}
-				}()
+				appAddr := serve(t)
 
 				testDir := filepath.Join(testdataDir, testCase)
-				runner, err := replay.NewHTTPRunner(8079, "localhost:8080", testDir)
+				runner, err := replay.NewHTTPRunner(0, appAddr, testDir)
 				if err != nil {
 					t.Fatal(err)
 				}
 
 				switch mode {
 				case "create":
+					var wg sync.WaitGroup
 					wg.Add(1)
 					go func() {
 						defer wg.Done()
@@ -96,51 +44,43 @@ func TestRunner(t *testing.T) {
 					<-runner.Ready()
 
 					// make http request
-					_, err := http.Get(fmt.Sprintf("http://localhost:8079/%s", testCase))
+					_, err := http.Get(fmt.Sprintf("http://%s/%s", runner.Addr(), testCase))
 					if err != nil {
 						t.Fatal(err)
 					}
 					// stop test recording
-					_, err = http.Get("http://localhost:8079/stop")
-					if err != nil {
-						t.Fatal(err)
-					}
+					runner.Stop()
+					wg.Wait()
 				default:
 					err := runner.Replay(mode == "update")
 					if err != nil {
 						t.Fatal(err)
 					}
 				}
-
-				cancel()
-				wg.Wait()
 			})
 		}
 	}
 }
 
 func TestUnreachable(t *testing.T) {
-	testdataDir := findTestdataDir(t, "testdata/unavailable")
-	// create if not exists
-	_ = os.Mkdir(testdataDir, 0755)
-	testCases, err := testCases(testdataDir)
-	if err != nil {
-		t.Fatal(err)
-	}
+	testdataDir := replaytest.TestdataDir(t, "testdata/unavailable")
 
-	for _, testCase := range testCases {
+	for _, testCase := range replaytest.TestCases(t, testdataDir) {
 		for _, mode := range []string{"create", "update", "replay"} {
 			t.Run(fmt.Sprintf("%s-%s", testCase, mode), func(t *testing.T) {
-				var wg sync.WaitGroup
-
 				testDir := filepath.Join(testdataDir, testCase)
-				runner, err := replay.NewHTTPRunner(8079, "localhost:1234", testDir)
+				lstr, err := net.Listen("tcp", "localhost:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				runner, err := replay.NewHTTPRunner(0, "localhost:1234", testDir, replay.WithListener(lstr))
 				if err != nil {
 					t.Fatal(err)
 				}
 
 				switch {
 				case mode == "create":
+					var wg sync.WaitGroup
 					wg.Add(1)
 					go func() {
 						defer wg.Done()
@@ -154,46 +94,135 @@ func TestUnreachable(t *testing.T) {
 					<-runner.Ready()
 
 					// make http request
-					_, err := http.Get("http://localhost:8079/foo")
+					_, err := http.Get(fmt.Sprintf("http://%s/foo", runner.Addr()))
 					if err != nil {
 						t.Fatal(err)
 					}
-					_, err = http.Get("http://localhost:8079/stop")
+					_, err = http.Post(fmt.Sprintf("http://%s%sstop", runner.Addr(), replay.ControlPrefix), "", nil)
 					if err != nil {
 						t.Fatal(err)
 					}
+					wg.Wait()
 				default:
 					if err := runner.Replay(mode == "update"); err != nil {
 						t.Error(err)
 					}
 				}
-
-				wg.Wait()
 			})
 		}
 	}
 }
 
-// TODO add a test with expected diff so we can validate via runner_test
+func TestRerecord(t *testing.T) {
+	testDir := t.TempDir()
+	appAddr := serve(t)
+	// leftovers of a longer recording and a response that used to fail
+	for name, content := range map[string]string{
+		"request1.data":     "GET /bar HTTP/1.1\r\n\r\n",
+		"response1.err":     "connection refused",
+		replay.TestCaseFile: "{}",
+	} {
+		if err := os.WriteFile(filepath.Join(testDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(addr string) {
+		resp, err := http.Get("http://" + addr + "/foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	recordTestCase(t, appAddr, testDir, get)
+	if err := os.WriteFile(filepath.Join(testDir, "response0.err"), []byte("stale"), 0o644); err != nil {
+		t.Fatal(err)
+	}
 
-func serve(ctx context.Context, port int) error {
-	mux := http.NewServeMux()
-	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: mux,
+	runner, err := replay.NewHTTPRunner(0, appAddr, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Replay(true); err != nil {
+		t.Fatal(err)
+	}
+	files, err := os.ReadDir(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	want := []string{"request0.data", "response0.data", replay.TestCaseFile}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got files %v, want %v", names, want)
+	}
+}
+
+func TestRerecordKeepsRecordingUntilFirstRequest(t *testing.T) {
+	testDir := t.TempDir()
+	appAddr := serve(t)
+	recordTestCase(t, appAddr, testDir, func(addr string) {
+		resp, err := http.Get("http://" + addr + "/foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	})
+
+	// port is taken, so the runner fails to listen
+	lstr, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lstr.Close()
+	runner, err := replay.NewHTTPRunner(lstr.Addr().(*net.TCPAddr).Port, appAddr, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Serve(); err == nil {
+		t.Fatal("expected error listening on a taken port")
 	}
+	// no requests were recorded
+	recordTestCase(t, appAddr, testDir, func(addr string) {})
 
+	for _, name := range []string{"request0.data", "response0.data"} {
+		if _, err := os.Stat(filepath.Join(testDir, name)); err != nil {
+			t.Errorf("previous recording is gone: %v", err)
+		}
+	}
+}
+
+// TODO add a test with expected diff so we can validate via runner_test
+
+// serve starts test application on an ephemeral port and returns its address.
+func serve(t *testing.T) string {
+	mux := http.NewServeMux()
 	mux.HandleFunc("/foo", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "foo")
 	})
+	return serveHandler(t, mux)
+}
 
-	go func() {
-		<-ctx.Done()
-		srv.Shutdown(context.Background())
-	}()
-
-	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-		return err
+// serveHandler serves handler on an ephemeral port until the end of the test and returns its address.
+func serveHandler(t *testing.T, handler http.Handler) string {
+	lstr, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		Handler: handler,
 	}
-	return nil
+	replaytest.Go(t, func(ctx context.Context) error {
+		go func() {
+			<-ctx.Done()
+			srv.Shutdown(context.Background())
+		}()
+
+		if err := srv.Serve(lstr); err != http.ErrServerClosed {
+			return err
+		}
+		return nil
+	})
+	return lstr.Addr().String()
 }
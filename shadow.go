@@ -0,0 +1,69 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// Shadow replays the test case against the remote address of the runner, the baseline, and candidateAddr,
+// e.g. the current release and a candidate build, and reports differences between their responses.
+// Recorded responses are only used for captures, so it works even if they are stale. Normalizations and
+// captures of the test case apply the same way as in Replay.
+func (h *httpRunner) Shadow(candidateAddr string) error {
+	tc, err := readTestCase(h.store, h.writeDir)
+	if err != nil {
+		return err
+	}
+	type run struct {
+		resps    []*httpResponse
+		captured captures
+	}
+	var runs [2]run
+	for i, remoteAddr := range []string{h.remoteAddr, fmt.Sprintf("http://%s", candidateAddr)} {
+		reqs, wantResps, err := h.load(true)
+		if err != nil {
+			return err
+		}
+		resps, captured, err := h.send(remoteAddr, tc, reqs, wantResps)
+		if err != nil {
+			return err
+		}
+		runs[i] = run{resps, captured}
+	}
+	baseline, candidate := runs[0], runs[1]
+	// captured values differ between targets by design
+	masks := liveMasks(baseline.captured, candidate.captured)
+	var errs []error
+	for i := range baseline.resps {
+		rawBaseline, err := h.dumpResult(baseline.resps[i])
+		if err != nil {
+			return err
+		}
+		rawCandidate, err := h.dumpResult(candidate.resps[i])
+		if err != nil {
+			return err
+		}
+		if baseline.resps[i].err != nil || candidate.resps[i].err != nil {
+			if diff := cmp.Diff(string(rawBaseline), string(rawCandidate)); diff != "" {
+				errs = append(errs, fmt.Errorf("%d-th HTTP result diff: (-baseline +candidate)\n%s", i, diff))
+			}
+			continue
+		}
+		if rawBaseline, err = tc.normalize(i, rawBaseline); err != nil {
+			return fmt.Errorf("failed to normalize %d-th baseline HTTP response: [%w]", i, err)
+		}
+		if rawCandidate, err = tc.normalize(i, rawCandidate); err != nil {
+			return fmt.Errorf("failed to normalize %d-th candidate HTTP response: [%w]", i, err)
+		}
+		diff, err := responseDiff(rawBaseline, rawCandidate, masks)
+		if err != nil {
+			return fmt.Errorf("failed to compare %d-th HTTP response: [%w]", i, err)
+		}
+		if diff != "" {
+			errs = append(errs, fmt.Errorf("%d-th HTTP response diff: (-baseline +candidate)\n%s", i, diff))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,47 @@
+package replay_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/daulet/replay"
+)
+
+func TestShadow(t *testing.T) {
+	testDir := t.TempDir()
+	recordTestCase(t, serveNondeterministic(t, "widget"), testDir, func(addr string) {
+		resp, err := http.Get("http://" + addr + "/widget")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	})
+	// golden response is stale, only targets are compared
+	if err := os.WriteFile(filepath.Join(testDir, "response0.data"), []byte("HTTP/1.1 500 Internal Server Error\r\n\r\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tc := `{"normalize":[{"response":0,"header":"X-Request-Id"},{"response":0,"jsonPath":"request_id"},{"response":0,"jsonPath":"items.0.etag"}]}`
+	if err := os.WriteFile(filepath.Join(testDir, replay.TestCaseFile), []byte(tc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	runner, err := replay.NewHTTPRunner(0, serveNondeterministic(t, "widget"), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Shadow(serveNondeterministic(t, "widget")); err != nil {
+		t.Fatal(err)
+	}
+	err = runner.Shadow(serveNondeterministic(t, "gadget"))
+	if err == nil {
+		t.Fatal("expected candidate to differ")
+	}
+	for _, want := range []string{"(-baseline +candidate)", `"wi"`, `"ga"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error doesn't contain %q:\n%v", want, err)
+		}
+	}
+}
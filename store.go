@@ -0,0 +1,61 @@
+package replay
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/daulet/replay/internal/atomicfile"
+)
+
+// fileStore reads and writes recordings, encrypting them at rest if a key is set,
+// and storing large bodies as blobs if a blob directory is set.
+type fileStore struct {
+	key           []byte
+	blobDir       string
+	blobThreshold int
+}
+
+func newFileStore(o *options) (*fileStore, error) {
+	s := &fileStore{blobDir: o.blobDir, blobThreshold: o.blobThreshold}
+	if o.keyEnv == "" {
+		return s, nil
+	}
+	key, err := KeyFromEnv(o.keyEnv)
+	if err != nil {
+		return nil, err
+	}
+	s.key = key
+	return s, nil
+}
+
+// readFile reads recording, decrypting it if necessary.
+func (s *fileStore) readFile(name string) ([]byte, error) {
+	b, err := os.ReadFile(name)
+	if err != nil || !IsEncrypted(b) {
+		return b, err
+	}
+	key := s.key
+	if key == nil {
+		key, err = KeyFromEnv(DefaultKeyEnv)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: [%v]", errNoKey, name, err)
+		}
+	}
+	b, err = Decrypt(b, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %q: [%w]", name, err)
+	}
+	return b, nil
+}
+
+// writeFile writes recording, encrypting it if a key is set. An existing file keeps its permissions.
+func (s *fileStore) writeFile(name string, data []byte) error {
+	if s.key != nil {
+		var err error
+		data, err = Encrypt(data, s.key)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %q: [%w]", name, err)
+		}
+	}
+	return atomicfile.WriteFile(name, data, 0o644)
+}
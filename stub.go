@@ -0,0 +1,331 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// defaultReloadInterval is how often the stub server checks the record file for changes.
+const defaultReloadInterval = time.Second
+
+// WithFallback makes the stub server pass requests that match no recorded interaction to handler,
+// e.g. a static response or a reverse proxy to the real dependency. By default they get 404 Not Found.
+func WithFallback(handler http.Handler) Option {
+	return func(o *options) {
+		o.fallback = handler
+	}
+}
+
+// WithReloadInterval sets how often the stub server checks the record file for changes, negative interval
+// disables reloading. Defaults to a second.
+func WithReloadInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.reloadInterval = interval
+	}
+}
+
+var _ io.Closer = (*StubServer)(nil)
+
+// StubServer serves recorded responses of a dependency as a long-running mock, without the application
+// under test, see NewStubServer.
+type StubServer struct {
+	// internal control
+	ready chan struct{}
+	stop  chan struct{}
+
+	// internal state
+	wg     *sync.WaitGroup
+	lstr   net.Listener
+	srv    *http.Server
+	logger Logger
+	// serveErr is the error the server stopped serving with, set once wg is done
+	serveErr error
+}
+
+// NewStubServer starts a server that responds with interactions recorded into recordFile by HTTPServer.
+// Unlike replay, matching is lenient: a request matches interactions with the same method and path,
+// and the one that agrees the most on body, query and headers is served. Interactions can be served
+// any number of times, equally good matches, e.g. recorded polling, are served in turns. Requests
+// that match nothing are passed to WithFallback handler. The record file is reloaded when it changes,
+// see WithReloadInterval.
+// Pass port 0 to listen on an ephemeral port, see Addr.
+func NewStubServer(port int, recordFile string, opts ...Option) (*StubServer, error) {
+	o := newOptions(opts)
+	store, err := newFileStore(o)
+	if err != nil {
+		return nil, err
+	}
+	stub := &stubHandler{
+		file:     recordFile,
+		store:    store,
+		redact:   newRedactor(o.redaction),
+		logger:   o.logger,
+		fallback: o.fallback,
+	}
+	if stub.fallback == nil {
+		stub.fallback = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, fmt.Sprintf("no recorded interaction matches %s %s", r.Method, r.URL.RequestURI()), http.StatusNotFound)
+		})
+	}
+	if err := stub.reload(); err != nil {
+		return nil, fmt.Errorf("failed to open record file: [%w]", err)
+	}
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: stub,
+	}
+
+	lstr := o.listener
+	if lstr == nil {
+		lstr, err = net.Listen("tcp", srv.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on address %q: [%w]", srv.Addr, err)
+		}
+	}
+	ready := make(chan struct{})
+	close(ready)
+	stop := make(chan struct{})
+
+	s := &StubServer{
+		ready:  ready,
+		stop:   stop,
+		wg:     &sync.WaitGroup{},
+		lstr:   lstr,
+		srv:    srv,
+		logger: o.logger,
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := srv.Serve(lstr); err != http.ErrServerClosed {
+			o.logger.Error("stub server stopped serving", "error", err)
+			s.serveErr = fmt.Errorf("stub server stopped serving: [%w]", err)
+		}
+	}()
+	o.logger.Info("stub server listening", "addr", dialAddr(lstr.Addr()), "file", recordFile)
+	interval := o.reloadInterval
+	if interval == 0 {
+		interval = defaultReloadInterval
+	}
+	if interval > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			stub.watch(interval, stop)
+		}()
+	}
+	return s, nil
+}
+
+// Ready is closed once the server is listening for incoming requests.
+func (s *StubServer) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Addr returns the address server is listening on.
+func (s *StubServer) Addr() string {
+	return dialAddr(s.lstr.Addr())
+}
+
+// Close stops the server. It returns the error the server stopped serving with, if it failed before.
+func (s *StubServer) Close() error {
+	s.logger.Info("stub server shutting down", "addr", s.Addr())
+	err := s.srv.Shutdown(context.Background())
+	close(s.stop)
+	s.wg.Wait()
+	if s.serveErr != nil {
+		return s.serveErr
+	}
+	return err
+}
+
+var _ http.Handler = (*stubHandler)(nil)
+
+type stubHandler struct {
+	file     string
+	store    *fileStore
+	redact   *redactor
+	logger   Logger
+	fallback http.Handler
+
+	mux     sync.Mutex
+	modTime time.Time
+	size    int64
+	entries []*logEntry
+	// turns counts responses served for groups of equally good matches, by index of the first entry
+	turns map[int]int
+}
+
+// reload reads the record file if it changed since the last read.
+func (s *stubHandler) reload() error {
+	fi, err := os.Stat(s.file)
+	if err != nil {
+		return err
+	}
+	s.mux.Lock()
+	changed := s.entries == nil || !fi.ModTime().Equal(s.modTime) || fi.Size() != s.size
+	s.mux.Unlock()
+	if !changed {
+		return nil
+	}
+	lg, err := readLog(s.store, s.file)
+	if err != nil {
+		return err
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.modTime, s.size = fi.ModTime(), fi.Size()
+	s.entries = lg.Entries
+	if s.entries == nil {
+		s.entries = []*logEntry{}
+	}
+	s.turns = map[int]int{}
+	s.logger.Info("loaded recording", "file", s.file, "interactions", len(s.entries))
+	return nil
+}
+
+// watch reloads the record file every interval until stop is closed. A file that fails to load,
+// e.g. while it's being edited, is ignored and interactions loaded before keep being served.
+func (s *stubHandler) watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := s.reload(); err != nil {
+				s.logger.Error("failed to reload recording, serving the previous one", "file", s.file, "error", err)
+			}
+		}
+	}
+}
+
+func (s *stubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	lreq, err := convertRequest(r, s.redact)
+	if err != nil {
+		s.logger.Error("failed to read request", "method", r.Method, "url", r.URL.RequestURI(), "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	e := s.match(lreq)
+	if e == nil {
+		s.logger.Info("no recorded interaction matches request, using fallback", "method", r.Method, "url", r.URL.RequestURI())
+		s.fallback.ServeHTTP(w, r)
+		return
+	}
+	s.logger.Debug("matched recorded interaction", "method", r.Method, "url", r.URL.RequestURI(), "id", e.ID)
+	resp, err := e.Response.toHTTP(r)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	defer resp.Body.Close()
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	// status is already sent, nothing to report to the client
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// match returns the best recorded interaction for lreq, nil if none has the same method and path.
+func (s *stubHandler) match(lreq *logRequest) *logEntry {
+	in, err := url.Parse(lreq.URL)
+	if err != nil {
+		return nil
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	var (
+		best      []int
+		bestScore stubScore
+	)
+	for i, e := range s.entries {
+		score, ok := scoreStub(lreq, in, e.Request)
+		if !ok {
+			continue
+		}
+		switch {
+		case len(best) == 0 || bestScore.less(score):
+			best, bestScore = []int{i}, score
+		case score == bestScore:
+			best = append(best, i)
+		}
+	}
+	if len(best) == 0 {
+		return nil
+	}
+	turn := s.turns[best[0]]
+	s.turns[best[0]]++
+	return s.entries[best[turn%len(best)]]
+}
+
+// stubScore ranks how well a recorded request matches the incoming one: whether they match exactly,
+// whether bodies are equal, and how many query parameters and headers are equal, in order of importance.
+type stubScore [4]int
+
+func (a stubScore) less(b stubScore) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// scoreStub scores recorded request rec against incoming lreq with URL in, reporting whether it matches at all.
+func scoreStub(lreq *logRequest, in *url.URL, rec *logRequest) (stubScore, bool) {
+	var score stubScore
+	u, err := url.Parse(rec.URL)
+	if err != nil || rec.Method != lreq.Method || u.Path != in.Path {
+		return score, false
+	}
+	if requestsMatch(lreq, rec) {
+		score[0] = 1
+	}
+	if bodiesEqual(lreq, rec) {
+		score[1] = 1
+	}
+	query := in.Query()
+	for k, v := range u.Query() {
+		if reflect.DeepEqual(query[k], v) {
+			score[2]++
+		}
+	}
+	for k, v := range rec.Header {
+		if reflect.DeepEqual(lreq.Header[k], v) {
+			score[3]++
+		}
+	}
+	return score, true
+}
+
+// bodiesEqual compares bodies of requests, JSON bodies are compared as values, ignoring formatting.
+func bodiesEqual(a, b *logRequest) bool {
+	if len(a.BodyParts) != len(b.BodyParts) {
+		return false
+	}
+	for i := range a.BodyParts {
+		if bytes.Equal(a.BodyParts[i], b.BodyParts[i]) {
+			continue
+		}
+		va, okA := decodeJSON(a.BodyParts[i])
+		vb, okB := decodeJSON(b.BodyParts[i])
+		if !okA || !okB || !reflect.DeepEqual(va, vb) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,173 @@
+package replay_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daulet/replay"
+)
+
+func recordDependency(t *testing.T, recordFile string, handler http.HandlerFunc, send func(addr string)) {
+	t.Helper()
+	srv, err := replay.NewHTTPServer(0, true, serveHandler(t, handler), recordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(srv.Addr())
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func call(t *testing.T, method, url, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+func TestStubServer(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "http.record")
+	var polls atomic.Int32
+	recordDependency(t, recordFile, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/items":
+			if r.Method == http.MethodPost {
+				body, _ := io.ReadAll(r.Body)
+				fmt.Fprintf(w, "created %s", body)
+				return
+			}
+			fmt.Fprintf(w, "page %s", r.URL.Query().Get("page"))
+		case "/status":
+			if polls.Add(1) == 1 {
+				w.Write([]byte("pending"))
+				return
+			}
+			w.Write([]byte("done"))
+		}
+	}, func(addr string) {
+		call(t, http.MethodGet, "http://"+addr+"/items?page=1", "")
+		call(t, http.MethodGet, "http://"+addr+"/items?page=2", "")
+		call(t, http.MethodGet, "http://"+addr+"/status", "")
+		call(t, http.MethodGet, "http://"+addr+"/status", "")
+		call(t, http.MethodPost, "http://"+addr+"/items", `{"name":"gear"}`)
+	})
+
+	srv, err := replay.NewStubServer(0, recordFile,
+		replay.WithReloadInterval(10*time.Millisecond),
+		replay.WithFallback(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	base := "http://" + srv.Addr()
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		want               string
+	}{
+		{http.MethodGet, "/items?page=2&lang=en", "", http.StatusOK, "page 2"},
+		{http.MethodGet, "/items?page=1", "", http.StatusOK, "page 1"},
+		{http.MethodGet, "/items?page=1", "", http.StatusOK, "page 1"},
+		// equally good matches are served in turns
+		{http.MethodGet, "/status", "", http.StatusOK, "pending"},
+		{http.MethodGet, "/status", "", http.StatusOK, "done"},
+		{http.MethodGet, "/status", "", http.StatusOK, "pending"},
+		// JSON bodies are compared as values
+		{http.MethodPost, "/items", `{ "name": "gear" }`, http.StatusOK, `created {"name":"gear"}`},
+		{http.MethodPost, "/items", `{"name": "bolt"}`, http.StatusOK, `created {"name":"gear"}`},
+		{http.MethodGet, "/orders", "", http.StatusTeapot, ""},
+		{http.MethodDelete, "/items", "", http.StatusTeapot, ""},
+	}
+	for _, tt := range tests {
+		status, body := call(t, tt.method, base+tt.path, tt.body)
+		if status != tt.status || body != tt.want {
+			t.Errorf("%s %s: got %d %q, want %d %q", tt.method, tt.path, status, body, tt.status, tt.want)
+		}
+	}
+
+	recordDependency(t, recordFile, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fresh page"))
+	}, func(addr string) {
+		call(t, http.MethodGet, "http://"+addr+"/items?page=1", "")
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, body := call(t, http.MethodGet, base+"/items?page=1", "")
+		if body == "fresh page" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %q after the record file changed, want reloaded response", body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status, _ := call(t, http.MethodGet, base+"/status", ""); status != http.StatusTeapot {
+		t.Errorf("got %d for interaction removed from the record file, want fallback", status)
+	}
+}
+
+func TestStubServerDefaultFallback(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "http.record")
+	recordDependency(t, recordFile, func(w http.ResponseWriter, r *http.Request) {}, func(addr string) {
+		call(t, http.MethodGet, "http://"+addr+"/", "")
+	})
+	srv, err := replay.NewStubServer(0, recordFile, replay.WithReloadInterval(-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	status, body := call(t, http.MethodGet, "http://"+srv.Addr()+"/missing?q=1", "")
+	if want := "no recorded interaction matches GET /missing?q=1\n"; status != http.StatusNotFound || body != want {
+		t.Errorf("got %d %q, want 404 %q", status, body, want)
+	}
+}
+
+func TestStubServerServeError(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "http.record")
+	recordDependency(t, recordFile, func(w http.ResponseWriter, r *http.Request) {}, func(addr string) {
+		call(t, http.MethodGet, "http://"+addr+"/", "")
+	})
+	lstr, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the server fails to accept connections right away
+	lstr.Close()
+	logger := &testLogger{}
+	srv, err := replay.NewStubServer(0, recordFile, replay.WithListener(lstr), replay.WithReloadInterval(-1), replay.WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for logger.find("ERROR stub server stopped serving") == nil {
+		time.Sleep(time.Millisecond)
+	}
+	if err := srv.Close(); err == nil || !strings.Contains(err.Error(), "stub server stopped serving") {
+		t.Errorf("got %v, want error the server stopped serving with", err)
+	}
+}
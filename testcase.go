@@ -0,0 +1,532 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TestCaseFile is the name of the optional file describing a runner test case, next to its recordings.
+const TestCaseFile = "testcase.json"
+
+// TestCase describes how to replay recorded traffic of a runner test case, see TestCaseFile.
+type TestCase struct {
+	// Captures are values generated by the application, such as IDs, that later requests refer to.
+	// Requests of a test case with captures are replayed in order.
+	Captures []Capture `json:"captures,omitempty"`
+	// Normalize lists nondeterministic response values ignored on comparison, see DetectNondeterminism.
+	Normalize []Normalization `json:"normalize,omitempty"`
+}
+
+// Capture extracts a value from a response. On replay, the recorded value is substituted with the live one
+// in paths, headers and bodies of later requests, as is the "{{name}}" placeholder, and responses
+// are compared modulo captured values. Exactly one of JSONPath, Header and Regex must be set.
+type Capture struct {
+	Name string `json:"name"`
+	// Response is the index of the recorded response to capture from, only later requests may use the value.
+	Response int `json:"response"`
+	// JSONPath into response body, e.g. "order.id".
+	JSONPath string `json:"jsonPath,omitempty"`
+	// Header of the response.
+	Header string `json:"header,omitempty"`
+	// Regex matching response body, value is its first capture group or the whole match if it has none.
+	Regex string `json:"regex,omitempty"`
+}
+
+// readTestCase reads TestCaseFile of dir, zero TestCase if there is none.
+func readTestCase(store *fileStore, dir string) (*TestCase, error) {
+	filename := filepath.Join(dir, TestCaseFile)
+	b, err := store.readFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return &TestCase{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tc TestCase
+	if err := json.Unmarshal(b, &tc); err != nil {
+		return nil, fmt.Errorf("failed to parse %q: [%w]", filename, err)
+	}
+	for _, c := range tc.Captures {
+		if err := c.validate(); err != nil {
+			return nil, fmt.Errorf("invalid capture %q in %q: [%w]", c.Name, filename, err)
+		}
+	}
+	if len(tc.Captures) > 0 {
+		if err := validateCaptureRefs(store, dir, tc.Captures); err != nil {
+			return nil, fmt.Errorf("invalid capture in %q: [%w]", filename, err)
+		}
+	}
+	for _, n := range tc.Normalize {
+		if (n.Header == "") == (n.JSONPath == "") {
+			return nil, fmt.Errorf("invalid normalization of response %d in %q: exactly one of header and jsonPath must be set", n.Response, filename)
+		}
+	}
+	return &tc, nil
+}
+
+func (c *Capture) validate() error {
+	if c.Name == "" {
+		return errors.New("name is required")
+	}
+	var n int
+	for _, s := range []string{c.JSONPath, c.Header, c.Regex} {
+		if s != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return errors.New("exactly one of jsonPath, header and regex must be set")
+	}
+	if c.Regex != "" {
+		if _, err := regexp.Compile(c.Regex); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateCaptureRefs checks captures refer to recorded requests of the test case in dir, and their
+// placeholders are only used by requests that follow the captured response.
+func validateCaptureRefs(store *fileStore, dir string, captureList []Capture) error {
+	var reqs [][]byte
+	for i := 0; ; i++ {
+		req, err := store.readMessage(filepath.Join(dir, fmt.Sprintf("request%v.data", i)))
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %d-th request: [%w]", i, err)
+		}
+		reqs = append(reqs, req)
+	}
+	for _, c := range captureList {
+		if c.Response < 0 || c.Response >= len(reqs) {
+			return fmt.Errorf("capture %q: response %d is out of range, test case has %d requests", c.Name, c.Response, len(reqs))
+		}
+		placeholder := []byte((&capturedValue{name: c.Name}).placeholder())
+		for i := 0; i <= c.Response; i++ {
+			if bytes.Contains(reqs[i], placeholder) {
+				return fmt.Errorf("capture %q: %d-th request refers to it before %d-th response is captured", c.Name, i, c.Response)
+			}
+		}
+	}
+	return nil
+}
+
+// extract returns captured value from response dump, ok is false if response doesn't have it.
+func (c *Capture) extract(rawResp []byte) (string, bool) {
+	_, header, body, err := splitResponse(rawResp)
+	if err != nil {
+		return "", false
+	}
+	switch {
+	case c.Header != "":
+		v := header.Get(c.Header)
+		return v, v != ""
+	case c.JSONPath != "":
+		v, ok := decodeJSON(body)
+		if !ok {
+			return "", false
+		}
+		var value any
+		_, ok = parseJSONPath(c.JSONPath).replace(v, func(v any) any {
+			value = v
+			return v
+		})
+		if !ok {
+			return "", false
+		}
+		switch value := value.(type) {
+		case string:
+			return value, true
+		case json.Number:
+			return value.String(), true
+		case bool:
+			return strconv.FormatBool(value), true
+		default:
+			b, err := json.Marshal(value)
+			return string(b), err == nil
+		}
+	default:
+		m := regexp.MustCompile(c.Regex).FindSubmatch(body)
+		if m == nil {
+			return "", false
+		}
+		return string(m[len(m)-1]), true
+	}
+}
+
+type capturedValue struct {
+	name     string
+	recorded string
+	live     string
+	// regex the value was captured with, if any, locates it in text bodies
+	regex *regexp.Regexp
+}
+
+func (c *capturedValue) placeholder() string {
+	return "{{" + c.name + "}}"
+}
+
+// captures are values captured so far while replaying a test case. Recorded values are only substituted
+// into requests where they are whole values: path segments, query and form values, space separated fields
+// of headers, and string or number values of JSON bodies, so short values, e.g. numeric IDs, don't change
+// unrelated text. Placeholders are substituted anywhere, so a recorded request can use them where
+// a recorded value is ambiguous.
+type captures []capturedValue
+
+// substitute replaces recorded values and placeholders in path or header value s with live values.
+func (cs captures) substitute(s string) string {
+	for _, c := range cs {
+		if c.recorded != "" {
+			s = replaceValue(s, c.recorded, c.live)
+		}
+		s = strings.ReplaceAll(s, c.placeholder(), c.live)
+	}
+	return s
+}
+
+// substituteQuery replaces recorded values and placeholders in raw query with live values.
+func (cs captures) substituteQuery(query string) string {
+	for _, c := range cs {
+		if c.recorded != "" {
+			query = replaceQueryValue(query, c.recorded, c.live)
+		}
+		query = strings.ReplaceAll(query, url.QueryEscape(c.placeholder()), url.QueryEscape(c.live))
+		query = strings.ReplaceAll(query, c.placeholder(), url.QueryEscape(c.live))
+	}
+	return query
+}
+
+// substituteBody replaces recorded values and placeholders in request body of contentType with live values.
+func (cs captures) substituteBody(contentType string, body []byte) []byte {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for _, c := range cs {
+		if c.recorded != "" {
+			if b, ok := replaceJSONValues(body, c.recorded, c.live); ok {
+				body = b
+			} else if mediaType == "application/x-www-form-urlencoded" {
+				body = []byte(replaceQueryValue(string(body), c.recorded, c.live))
+			}
+		}
+		body = bytes.ReplaceAll(body, []byte(c.placeholder()), []byte(c.live))
+	}
+	return body
+}
+
+// masks pairs recorded values of captures with live ones, see captureMasks.
+func (cs captures) masks() captureMasks {
+	var ms captureMasks
+	for _, c := range cs {
+		ms = append(ms, captureMask{placeholder: c.placeholder(), a: c.recorded, b: c.live, regex: c.regex})
+	}
+	return ms
+}
+
+// liveMasks pairs live values of captures of two replays of the same test case, see captureMasks.
+func liveMasks(a, b captures) captureMasks {
+	var ms captureMasks
+	for i := range a {
+		if i < len(b) {
+			ms = append(ms, captureMask{placeholder: a[i].placeholder(), a: a[i].live, b: b[i].live, regex: a[i].regex})
+		}
+	}
+	return ms
+}
+
+// captureMask is a captured value as seen by two responses being compared.
+type captureMask struct {
+	placeholder string
+	a, b        string
+	regex       *regexp.Regexp
+}
+
+// captureMasks replace captured values of two responses with placeholders, so responses can be compared.
+// A value is only masked where one response has its a value and the other its b value in the same place,
+// e.g. the same header or JSON path, so values that merely equal one of them are compared as is.
+type captureMasks []captureMask
+
+// heads masks header values of response heads a and b. Status lines are left as is.
+func (ms captureMasks) heads(a, b string) (string, string) {
+	if len(ms) == 0 {
+		return a, b
+	}
+	linesA, linesB := strings.Split(a, "\r\n"), strings.Split(b, "\r\n")
+	// heads list headers sorted by key, so lines of the same header are matched in order
+	indexB := map[string][]int{}
+	for i := 1; i < len(linesB); i++ {
+		k, _, _ := strings.Cut(linesB[i], ": ")
+		indexB[k] = append(indexB[k], i)
+	}
+	for i := 1; i < len(linesA); i++ {
+		k, va, ok := strings.Cut(linesA[i], ": ")
+		if !ok || len(indexB[k]) == 0 {
+			continue
+		}
+		j := indexB[k][0]
+		indexB[k] = indexB[k][1:]
+		_, vb, _ := strings.Cut(linesB[j], ": ")
+		va, vb = ms.values(va, vb)
+		linesA[i], linesB[j] = k+": "+va, k+": "+vb
+	}
+	return strings.Join(linesA, "\r\n"), strings.Join(linesB, "\r\n")
+}
+
+// values masks header values a and b if that makes them equal, see replaceValue.
+func (ms captureMasks) values(a, b string) (string, string) {
+	if a == b {
+		return a, b
+	}
+	ma, mb := a, b
+	for _, m := range ms {
+		if m.a != "" && m.b != "" {
+			ma, mb = replaceValue(ma, m.a, m.placeholder), replaceValue(mb, m.b, m.placeholder)
+		}
+	}
+	if ma != mb {
+		return a, b
+	}
+	return ma, mb
+}
+
+// bodies masks JSON values at the same paths of bodies a and b, or values found by regex captures in other bodies.
+func (ms captureMasks) bodies(a, b []byte) ([]byte, []byte) {
+	if len(ms) == 0 || bytes.Equal(a, b) {
+		return a, b
+	}
+	va, okA := decodeJSON(a)
+	vb, okB := decodeJSON(b)
+	if okA && okB {
+		var masked bool
+		va, vb = ms.maskJSON(va, vb, &masked)
+		if !masked {
+			return a, b
+		}
+		return encodeJSON(va, a), encodeJSON(vb, b)
+	}
+	for _, m := range ms {
+		if m.regex != nil && m.a != "" && m.b != "" {
+			a, b = maskRegex(m.regex, a, m.a, m.placeholder), maskRegex(m.regex, b, m.b, m.placeholder)
+		}
+	}
+	return a, b
+}
+
+// maskJSON replaces values of decoded JSON documents a and b that are captured values at the same path.
+func (ms captureMasks) maskJSON(a, b any, masked *bool) (any, any) {
+	switch a := a.(type) {
+	case map[string]any:
+		if b, ok := b.(map[string]any); ok {
+			for k := range a {
+				if _, ok := b[k]; ok {
+					a[k], b[k] = ms.maskJSON(a[k], b[k], masked)
+				}
+			}
+			return a, b
+		}
+	case []any:
+		if b, ok := b.([]any); ok {
+			for i := range a {
+				if i < len(b) {
+					a[i], b[i] = ms.maskJSON(a[i], b[i], masked)
+				}
+			}
+			return a, b
+		}
+	}
+	sa, okA := jsonScalar(a)
+	sb, okB := jsonScalar(b)
+	if !okA || !okB || sa == sb {
+		return a, b
+	}
+	for _, m := range ms {
+		if sa == m.a && sb == m.b {
+			*masked = true
+			return m.placeholder, m.placeholder
+		}
+	}
+	return a, b
+}
+
+// jsonScalar returns string or number value v as text.
+func jsonScalar(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	}
+	return "", false
+}
+
+// maskRegex replaces value in matches of re in body, where it's their captured group, with placeholder.
+func maskRegex(re *regexp.Regexp, body []byte, value, placeholder string) []byte {
+	return re.ReplaceAllFunc(body, func(m []byte) []byte {
+		sub := re.FindSubmatchIndex(m)
+		start, end := sub[len(sub)-2], sub[len(sub)-1]
+		if start < 0 || string(m[start:end]) != value {
+			return m
+		}
+		return append(append(append([]byte{}, m[:start]...), placeholder...), m[end:]...)
+	})
+}
+
+// replaceValue replaces old with new in s where it's a whole value: s itself, a space separated field of s,
+// a segment of a path or a query value, e.g. 1 in "/orders/1?page=1" but not in "HTTP/1.1".
+func replaceValue(s, old, new string) string {
+	if s == old {
+		return new
+	}
+	fields := strings.Split(s, " ")
+	for i, f := range fields {
+		if f == old {
+			fields[i] = new
+			continue
+		}
+		path, query, hasQuery := strings.Cut(f, "?")
+		if strings.Contains(path, "/") {
+			segments := strings.Split(path, "/")
+			for j := range segments {
+				if segments[j] == old {
+					segments[j] = new
+				}
+			}
+			path = strings.Join(segments, "/")
+		}
+		if hasQuery {
+			path += "?" + replaceQueryValue(query, old, new)
+		}
+		fields[i] = path
+	}
+	return strings.Join(fields, " ")
+}
+
+// replaceQueryValue replaces values of raw query equal to old with new, keeping order of parameters.
+func replaceQueryValue(query, old, new string) string {
+	params := strings.Split(query, "&")
+	for i, p := range params {
+		k, v, ok := strings.Cut(p, "=")
+		if !ok {
+			continue
+		}
+		if v, err := url.QueryUnescape(v); err == nil && v == old {
+			params[i] = k + "=" + url.QueryEscape(new)
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// replaceJSONValues replaces string and number values of JSON document body equal to old with new,
+// keeping the rest of the document as is. Keys aren't values. ok is false if body isn't a JSON document.
+func replaceJSONValues(body []byte, old, new string) ([]byte, bool) {
+	if _, ok := decodeJSON(body); !ok {
+		return nil, false
+	}
+	type container struct {
+		object bool
+		// whether the next string of an object is a key
+		key bool
+	}
+	var (
+		stack []*container
+		out   []byte
+		last  int
+	)
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		if d, ok := tok.(json.Delim); ok && (d == '}' || d == ']') {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		if n := len(stack); n > 0 && stack[n-1].object {
+			top := stack[n-1]
+			top.key = !top.key
+			if !top.key {
+				// it was the key
+				continue
+			}
+		}
+		var raw, repl []byte
+		switch tok := tok.(type) {
+		case json.Delim:
+			stack = append(stack, &container{object: tok == '{', key: true})
+			continue
+		case string:
+			if tok != old {
+				continue
+			}
+			raw, repl = quoteJSON(old), quoteJSON(new)
+		case json.Number:
+			if tok.String() != old {
+				continue
+			}
+			raw, repl = []byte(old), quoteJSON(new)
+			if _, err := strconv.ParseFloat(new, 64); err == nil {
+				repl = []byte(new)
+			}
+		default:
+			continue
+		}
+		end := int(dec.InputOffset())
+		start := end - len(raw)
+		// values written with escapes other than ours are left as is
+		if start < last || !bytes.Equal(body[start:end], raw) {
+			continue
+		}
+		out = append(append(out, body[last:start]...), repl...)
+		last = end
+	}
+	if out == nil {
+		return body, true
+	}
+	return append(out, body[last:]...), true
+}
+
+// quoteJSON encodes s as a JSON string without escaping HTML characters.
+func quoteJSON(s string) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// substituteRequest substitutes captured values in path, query, headers and body of req.
+func (cs captures) substituteRequest(req *http.Request) error {
+	if len(cs) == 0 {
+		return nil
+	}
+	req.URL.Path = cs.substitute(req.URL.Path)
+	req.URL.RawPath = ""
+	req.URL.RawQuery = cs.substituteQuery(req.URL.RawQuery)
+	for k, vs := range req.Header {
+		for i, v := range vs {
+			req.Header[k][i] = cs.substitute(v)
+		}
+	}
+	body, err := snapshotBody(&req.Body)
+	if err != nil || body == nil {
+		return err
+	}
+	body = cs.substituteBody(req.Header.Get("Content-Type"), body)
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	setContentLength(req.Header, len(body))
+	return nil
+}
@@ -0,0 +1,248 @@
+package replay_test
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/daulet/replay"
+)
+
+// serveOrders starts an application that generates random order IDs, so every instance differs.
+func serveOrders(t *testing.T) string {
+	var (
+		mux    sync.Mutex
+		orders = map[string]string{}
+	)
+	return serveHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/orders":
+			b := make([]byte, 8)
+			rand.Read(b)
+			id := hex.EncodeToString(b)
+			var order struct{ Item string }
+			json.NewDecoder(r.Body).Decode(&order)
+			orders[id] = order.Item
+			w.Header().Set("Location", "/orders/"+id)
+			w.Header().Set("X-Order-Id", id)
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]string{"id": id})
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/orders/"):
+			id := strings.TrimPrefix(r.URL.Path, "/orders/")
+			item, ok := orders[id]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": "no order " + id})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"id": id, "item": item})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestCaptures(t *testing.T) {
+	testDir := t.TempDir()
+	runner, err := replay.NewHTTPRunner(0, serveOrders(t), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := runner.Serve(); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-runner.Ready()
+	resp, err := http.Post("http://"+runner.Addr()+"/orders", "application/json", strings.NewReader(`{"Item":"book"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order struct{ ID string }
+	json.NewDecoder(resp.Body).Decode(&order)
+	resp.Body.Close()
+	resp, err = http.Get("http://" + runner.Addr() + "/orders/" + order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	runner.Stop()
+	wg.Wait()
+
+	// fresh application doesn't know the recorded ID
+	runner, err = replay.NewHTTPRunner(0, serveOrders(t), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Replay(false); err == nil {
+		t.Fatal("expected replay of recorded ID to fail")
+	}
+
+	for name, capture := range map[string]replay.Capture{
+		"json":   {Name: "order_id", Response: 0, JSONPath: "id"},
+		"header": {Name: "order_id", Response: 0, Header: "X-Order-Id"},
+		"regex":  {Name: "order_id", Response: 0, Regex: `"id":"(\w+)"`},
+	} {
+		capture := capture
+		t.Run(name, func(t *testing.T) {
+			b, err := json.Marshal(replay.TestCase{Captures: []replay.Capture{capture}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(testDir, replay.TestCaseFile), b, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			runner, err := replay.NewHTTPRunner(0, serveOrders(t), testDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := runner.Replay(false); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// serveItems starts an application that numbers items sequentially from first, and rejects requests
+// whose User-Agent isn't the one of Go HTTP client.
+func serveItems(t *testing.T, first int) string {
+	var (
+		mux   sync.Mutex
+		next  = first
+		items = map[string]bool{}
+	)
+	return serveHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		if ua := r.Header.Get("User-Agent"); ua != "Go-http-client/1.1" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "unexpected User-Agent %q", ua)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/items":
+			id := strconv.Itoa(next)
+			next++
+			items[id] = true
+			w.Header().Set("Location", "/items/"+id)
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"id":%s,"count":1}`, id)
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/items/"):
+			id := strings.TrimPrefix(r.URL.Path, "/items/")
+			if !items[id] {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fmt.Fprintf(w, `{"id":%s,"version":"1.1"}`, id)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestCapturesNumeric(t *testing.T) {
+	testDir := t.TempDir()
+	runner, err := replay.NewHTTPRunner(0, serveItems(t, 1), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := runner.Serve(); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-runner.Ready()
+	for _, send := range []func() (*http.Response, error){
+		func() (*http.Response, error) {
+			return http.Post("http://"+runner.Addr()+"/items", "application/json", strings.NewReader(`{}`))
+		},
+		func() (*http.Response, error) { return http.Get("http://" + runner.Addr() + "/items/1") },
+	} {
+		resp, err := send()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	runner.Stop()
+	wg.Wait()
+
+	tc := `{"captures":[{"name":"item_id","response":0,"jsonPath":"id"}]}`
+	if err := os.WriteFile(filepath.Join(testDir, replay.TestCaseFile), []byte(tc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// ID 1 appears in "HTTP/1.1", User-Agent and other values, which must be left as is
+	runner, err = replay.NewHTTPRunner(0, serveItems(t, 2), testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runner.Replay(false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCapturesInvalid(t *testing.T) {
+	tests := []struct {
+		name, testCase, want string
+	}{
+		{
+			name:     "selectors",
+			testCase: `{"captures":[{"name":"id","response":0,"jsonPath":"id","header":"Location"}]}`,
+			want:     "exactly one of",
+		},
+		{
+			name:     "out of range",
+			testCase: `{"captures":[{"name":"id","response":2,"jsonPath":"id"}]}`,
+			want:     "response 2 is out of range, test case has 2 requests",
+		},
+		{
+			name:     "negative",
+			testCase: `{"captures":[{"name":"id","response":-1,"jsonPath":"id"}]}`,
+			want:     "response -1 is out of range",
+		},
+		{
+			name:     "forward reference",
+			testCase: `{"captures":[{"name":"id","response":1,"jsonPath":"id"}]}`,
+			want:     "1-th request refers to it before 1-th response is captured",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testDir := t.TempDir()
+			for name, content := range map[string]string{
+				"request0.data":     "POST /items HTTP/1.1\r\nHost: app\r\n\r\n",
+				"request1.data":     "GET /items/{{id}} HTTP/1.1\r\nHost: app\r\n\r\n",
+				replay.TestCaseFile: test.testCase,
+			} {
+				if err := os.WriteFile(filepath.Join(testDir, name), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			runner, err := replay.NewHTTPRunner(0, "localhost:1", testDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := runner.Replay(false); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want %q", err, test.want)
+			}
+		})
+	}
+}